@@ -0,0 +1,114 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The supported JWT signing algorithms.
+const (
+	AlgorithmRS256 string = "RS256"
+	AlgorithmES256 string = "ES256"
+	AlgorithmEdDSA string = "EdDSA"
+)
+
+// ValidAlgorithm returns true if the given JWT signing algorithm is supported.
+func ValidAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmRS256 || algorithm == AlgorithmES256 || algorithm == AlgorithmEdDSA
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	if !ValidAlgorithm(algorithm) {
+		return nil, errors.New("unsupported signing algorithm '" + algorithm + "'")
+	}
+	return jwt.GetSigningMethod(algorithm), nil
+}
+
+func generatePrivateKey(algorithm string) (crypto.Signer, error) {
+
+	switch algorithm {
+	case AlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	}
+	return nil, errors.New("unsupported signing algorithm '" + algorithm + "'")
+}
+
+// keyMatchesAlgorithm returns true if the given public key can be used with the given signing algorithm.
+func keyMatchesAlgorithm(publicKey crypto.PublicKey, algorithm string) bool {
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return algorithm == AlgorithmRS256
+	case *ecdsa.PublicKey:
+		return algorithm == AlgorithmES256 && key.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		return algorithm == AlgorithmEdDSA
+	}
+	return false
+}
+
+// algorithmForKey returns the signing algorithm that is used with the given public key.
+func algorithmForKey(publicKey crypto.PublicKey) (string, error) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		if keyMatchesAlgorithm(publicKey, algorithm) {
+			return algorithm, nil
+		}
+	}
+	return "", errors.New("unsupported public key type")
+}
+
+// parsePrivateKeyPEM parses PKCS #8, PKCS #1 and SEC 1 encoded private keys.
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM encoded private key")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key encoding")
+}
+
+// parsePublicKeyPEM parses PKIX and PKCS #1 encoded public keys.
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM encoded public key")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, errors.New("unsupported public key encoding")
+}
@@ -1,7 +1,75 @@
 package token
 
+import (
+	"slices"
+	"time"
+)
+
+// APIKeyPrefix is the start of the visible prefix of all generated API keys.
+const APIKeyPrefix = "fapi"
+
+// The scopes of API keys.
+const (
+	ScopeSignup = "signup"
+	ScopeLogin  = "login"
+	ScopeRead   = "read"
+)
+
+// APIKeyScopes lists all scopes an API key can have, new API keys have all scopes by default.
+var APIKeyScopes = []string{ScopeSignup, ScopeLogin, ScopeRead}
+
+// APIKey is an API key as it is stored in the database, only the hash of the key is stored.
+// The key itself is only set when the API key is created.
 type APIKey struct {
-	ID      int    `json:"api_key_id" sql:"api_key_id"`
-	Key     string `json:"api_key" sql:"api_key"`
-	Comment string `json:"api_key_comment" sql:"api_key_comment"`
+	ID         int        `json:"api_key_id" sql:"api_key_id"`
+	Prefix     string     `json:"api_key_prefix" sql:"api_key_prefix"`
+	Hash       string     `json:"api_key_hash" sql:"api_key_hash"`
+	Comment    string     `json:"api_key_comment" sql:"api_key_comment"`
+	Enabled    bool       `json:"api_key_enabled" sql:"api_key_enabled"`
+	CreateDate time.Time  `json:"api_key_createdat" sql:"api_key_createdat"`
+	CreatedBy  *int       `json:"api_key_createdby" sql:"api_key_createdby"`
+	LastUsedAt *time.Time `json:"api_key_lastusedat" sql:"api_key_lastusedat"`
+	ExpiresAt  *time.Time `json:"api_key_expiresat" sql:"api_key_expiresat"`
+	Scopes     []string   `json:"api_key_scopes" sql:"api_key_scopes"`
+	Origin     string     `json:"api_key_origin" sql:"api_key_origin"`
+	RateLimit  int        `json:"api_key_ratelimit" sql:"api_key_ratelimit"`
+	Expired    bool       `json:"api_key_expired" sql:"-"`
+	Key        string     `json:"api_key,omitempty" sql:"-"`
+}
+
+// NewAPIKey generates a new API key with the given comment.
+func NewAPIKey(comment string) (*APIKey, error) {
+
+	prefix, key, err := newKey(APIKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &APIKey{Prefix: prefix, Hash: HashKey(key), Comment: comment, Enabled: true, Scopes: slices.Clone(APIKeyScopes), Key: key}, nil
+}
+
+// IsUsable returns true if the API key is enabled and not expired. The expiry date is checked when the key is used,
+// so loaded keys stop working when they expire and not when they are loaded again.
+func (key *APIKey) IsUsable() bool {
+	return key.Enabled && !key.Expired && (key.ExpiresAt == nil || time.Now().Before(*key.ExpiresAt))
+}
+
+// HasScope returns true if the API key has the given scope.
+func (key *APIKey) HasScope(scope string) bool {
+	return slices.Contains(key.Scopes, scope)
+}
+
+// AllowsOrigin returns true if the API key is not restricted to an origin or if the
+// given origin or app identifier matches the origin of the API key.
+func (key *APIKey) AllowsOrigin(origin string, appIdentifier string) bool {
+	return key.Origin == "" || key.Origin == origin || key.Origin == appIdentifier
+}
+
+// ValidAPIKeyScopes returns true if all given scopes are known.
+func ValidAPIKeyScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return false
+		}
+	}
+	return true
 }
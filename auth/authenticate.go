@@ -3,8 +3,10 @@ package token
 // rename package to prevent collisons with package go/token
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"errors"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -17,34 +19,159 @@ type Authentication interface {
 }
 
 type AuthService struct {
-	SigningKey        *rsa.PrivateKey
-	ValidationKey     *rsa.PublicKey
-	ValidationKeyFile string
-	TokenLifetime     time.Duration
-	Issuer            string
+	// Algorithm is the configured signing algorithm, it is used for new signing keys
+	// and it is the only algorithm the local validation service accepts.
+	Algorithm string
+	// ConfiguredKey is the key loaded from the configured PEM files, it is used to
+	// bootstrap the key ring if there are no signing keys yet.
+	ConfiguredKey   *SigningKey
+	ValidationKeys  *KeySet
+	TokenLifetime   time.Duration
+	RefreshLifetime time.Duration
+	// ServiceTokenLifetime is the lifetime of the service tokens issued with the client credentials grant.
+	ServiceTokenLifetime time.Duration
+	Issuer               string
+	// PasswordHasher hashes and verifies the passwords of users.
+	PasswordHasher *PasswordHasher
+
+	lock             sync.RWMutex
+	signingKey       crypto.Signer
+	signingKeyID     string
+	signingMethod    jwt.SigningMethod
+	validationKeyPEM string
+	publishedKeys    JSONWebKeySet
 }
 
-func NewAuthService(privatekey string, publickey string, tokenLifetime int, issuer string) *AuthService {
+func NewAuthService(privatekey string, publickey string, algorithm string, tokenLifetime int, refreshLifetime int, issuer string) *AuthService {
+
+	if !ValidAlgorithm(algorithm) {
+		log.Fatal().Str("algorithm", algorithm).Msg("unsupported signing algorithm")
+	}
 
-	var signKey *rsa.PrivateKey = nil
 	signBytes, err := os.ReadFile(privatekey)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to read private auth key")
 	}
-	signKey, err = jwt.ParseRSAPrivateKeyFromPEM(signBytes)
+	signKey, err := parsePrivateKeyPEM(signBytes)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to parse private auth key")
 	}
 
-	var verifyKey *rsa.PublicKey = nil
 	verifyBytes, err := os.ReadFile(publickey)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to read public auth key")
 	}
-	verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
+	verifyKey, err := parsePublicKeyPEM(verifyBytes)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to parse public auth key")
 	}
+	if equalKey, ok := verifyKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !equalKey.Equal(signKey.Public()) {
+		log.Fatal().Msg("public auth key does not match private auth key")
+	}
+
+	configuredKey, err := newSigningKey(signKey, algorithm, KeyStateActive)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to use auth key")
+	}
+
+	auth := &AuthService{Algorithm: algorithm, ConfiguredKey: configuredKey, ValidationKeys: NewKeySet(), TokenLifetime: time.Minute * time.Duration(tokenLifetime), RefreshLifetime: time.Minute * time.Duration(refreshLifetime), Issuer: issuer}
+	err = auth.SetSigningKeys([]SigningKey{*configuredKey})
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to initialize key ring")
+	}
+	return auth
+}
+
+// SetSigningKeys replaces the key ring with the given keys. Exactly one key needs to be active,
+// retired keys are ignored.
+func (auth *AuthService) SetSigningKeys(keys []SigningKey) error {
+
+	var activeKey crypto.Signer
+	var activeMethod jwt.SigningMethod
+	var activeKeyID, activeKeyPEM string
+	var defaultKey *ValidationKey
+	validationKeys := map[string]ValidationKey{}
+	published := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for _, key := range keys {
+		if key.State == KeyStateRetired {
+			continue
+		}
+		method, err := signingMethod(key.Algorithm)
+		if err != nil {
+			return errors.New("failed to use key '" + key.ID + "': " + err.Error())
+		}
+		publicKey, err := parsePublicKeyPEM([]byte(key.PublicKey))
+		if err != nil {
+			return errors.New("failed to parse public key '" + key.ID + "': " + err.Error())
+		}
+		if !keyMatchesAlgorithm(publicKey, key.Algorithm) {
+			return errors.New("the key '" + key.ID + "' can not be used with the signing algorithm '" + key.Algorithm + "'")
+		}
+		jwk, err := NewJSONWebKey(key.ID, key.Algorithm, publicKey)
+		if err != nil {
+			return errors.New("failed to encode public key '" + key.ID + "': " + err.Error())
+		}
+		validationKeys[key.ID] = ValidationKey{Algorithm: key.Algorithm, Key: publicKey}
+		published.Keys = append(published.Keys, jwk)
+
+		if key.State == KeyStateActive {
+			if activeKey != nil {
+				return errors.New("there is more than one active signing key")
+			}
+			activeKey, err = parsePrivateKeyPEM([]byte(key.PrivateKey))
+			if err != nil {
+				return errors.New("failed to parse private key '" + key.ID + "': " + err.Error())
+			}
+			activeMethod = method
+			activeKeyID = key.ID
+			activeKeyPEM = key.PublicKey
+			defaultKey = &ValidationKey{Algorithm: key.Algorithm, Key: publicKey}
+		}
+	}
+	if activeKey == nil {
+		return errors.New("there is no active signing key")
+	}
+
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	auth.signingKey = activeKey
+	auth.signingKeyID = activeKeyID
+	auth.signingMethod = activeMethod
+	auth.validationKeyPEM = activeKeyPEM
+	auth.publishedKeys = published
+	auth.ValidationKeys.Set(validationKeys, defaultKey)
+	return nil
+}
+
+// Sign signs the given claims with the active signing key and sets the key ID header.
+func (auth *AuthService) Sign(claims jwt.Claims) (string, error) {
+
+	auth.lock.RLock()
+	defer auth.lock.RUnlock()
+
+	token := jwt.NewWithClaims(auth.signingMethod, claims)
+	token.Header["kid"] = auth.signingKeyID
+	return token.SignedString(auth.signingKey)
+}
+
+// SigningAlgorithm returns the algorithm of the active signing key.
+func (auth *AuthService) SigningAlgorithm() string {
+	auth.lock.RLock()
+	defer auth.lock.RUnlock()
+	return auth.signingMethod.Alg()
+}
+
+// ValidationKey returns the PEM encoded public key of the active signing key.
+func (auth *AuthService) ValidationKey() string {
+	auth.lock.RLock()
+	defer auth.lock.RUnlock()
+	return auth.validationKeyPEM
+}
 
-	return &AuthService{SigningKey: signKey, ValidationKey: verifyKey, ValidationKeyFile: publickey, TokenLifetime: time.Minute * time.Duration(tokenLifetime), Issuer: issuer}
+// JSONWebKeySet returns the public keys of all signing keys that are not retired.
+func (auth *AuthService) JSONWebKeySet() JSONWebKeySet {
+	auth.lock.RLock()
+	defer auth.lock.RUnlock()
+	return auth.publishedKeys
 }
@@ -0,0 +1,122 @@
+package token
+
+import (
+	"bufio"
+	"errors"
+	"net/mail"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxEmailLength     = 254
+	maxEmailLocalPart  = 64
+	emailDomainDivider = "."
+)
+
+// ErrInvalidEmail is returned for email addresses that can't be normalized.
+var ErrInvalidEmail = errors.New("invalid email")
+
+// NormalizeEmail parses the given email address as defined in RFC 5322 and returns it in the
+// form it is stored in the database. The domain is converted to its lowercase ASCII form and the
+// local part is lowercased as well, so addresses that only differ in case belong to the same user.
+// Addresses with a display name, like 'Jane <jane@example.com>', are rejected.
+func NormalizeEmail(email string) (string, error) {
+
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	at := strings.LastIndex(address.Address, "@")
+	local := address.Address[:at]
+	if len(local) > maxEmailLocalPart {
+		return "", ErrInvalidEmail
+	}
+
+	domain, err := idna.Lookup.ToASCII(address.Address[at+1:])
+	if err != nil || !strings.Contains(domain, emailDomainDivider) {
+		return "", ErrInvalidEmail
+	}
+
+	normalized := strings.ToLower(local) + "@" + strings.ToLower(domain)
+	if len(normalized) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	return normalized, nil
+}
+
+// EmailDomain returns the domain of the given normalized email address.
+func EmailDomain(email string) string {
+	return email[strings.LastIndex(email, "@")+1:]
+}
+
+// EmailDomainBlocklist contains the email domains users can't signup with, e.g. the domains of disposable email providers.
+type EmailDomainBlocklist struct {
+	domains map[string]struct{}
+}
+
+// LoadEmailDomainBlocklist reads the blocked domains from the given file, the file contains one domain per line
+// and lines starting with '#' are ignored. If the path is empty the blocklist doesn't block any domain.
+func LoadEmailDomainBlocklist(path string) (*EmailDomainBlocklist, error) {
+
+	blocklist := &EmailDomainBlocklist{domains: map[string]struct{}{}}
+	if path == "" {
+		return blocklist, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domain, err := idna.Lookup.ToASCII(line)
+		if err != nil {
+			return nil, errors.New("invalid domain '" + line + "' in email domain blocklist")
+		}
+		blocklist.domains[strings.ToLower(domain)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// Len returns the number of blocked domains.
+func (blocklist *EmailDomainBlocklist) Len() int {
+	return len(blocklist.domains)
+}
+
+// Blocks returns true if the domain of the given normalized email address or one of its parent domains is blocked.
+func (blocklist *EmailDomainBlocklist) Blocks(email string) bool {
+
+	if blocklist == nil {
+		return false
+	}
+	domain := EmailDomain(email)
+	for domain != "" {
+		if _, blocked := blocklist.domains[domain]; blocked {
+			return true
+		}
+		next := strings.Index(domain, emailDomainDivider)
+		if next < 0 {
+			break
+		}
+		domain = domain[next+1:]
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package token
+
+import "slices"
+
+// Entity is a type of FestivalsApp resource that can be associated with a user.
+type Entity string
+
+const (
+	Festival Entity = "festival"
+	Artist   Entity = "artist"
+	Location Entity = "location"
+	Event    Entity = "event"
+	Link     Entity = "link"
+	Image    Entity = "image"
+	Place    Entity = "place"
+	Tag      Entity = "tag"
+)
+
+// OwnsEntity returns true if the entity with the given ID is associated with the user.
+func (claims *UserClaims) OwnsEntity(entity Entity, id int) bool {
+
+	switch entity {
+	case Festival:
+		return slices.Contains(claims.UserFestivals, id)
+	case Artist:
+		return slices.Contains(claims.UserArtists, id)
+	case Location:
+		return slices.Contains(claims.UserLocations, id)
+	case Event:
+		return slices.Contains(claims.UserEvents, id)
+	case Link:
+		return slices.Contains(claims.UserLinks, id)
+	case Image:
+		return slices.Contains(claims.UserImages, id)
+	case Place:
+		return slices.Contains(claims.UserPlaces, id)
+	case Tag:
+		return slices.Contains(claims.UserTags, id)
+	}
+	return false
+}
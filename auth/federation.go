@@ -0,0 +1,419 @@
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// federationScope is the scope requested from external identity providers, the email is needed to provision users.
+const federationScope = "openid email"
+
+// federationKeysRefreshInterval limits how often the keys of a provider are fetched again for an unknown key ID,
+// so tokens with made up key IDs can't be used to flood the provider with requests.
+const federationKeysRefreshInterval = 1 * time.Minute
+
+// Federation is the list of external OpenID Connect providers users can login with.
+type Federation struct {
+	Providers []*FederationProvider
+	// RedirectURL is the page of the web app the providers redirect users back to after they logged in.
+	RedirectURL string
+	// ChallengeLifetime is how long users have to login with the provider after the login was started.
+	ChallengeLifetime time.Duration
+}
+
+// NewFederation returns the federation with the given providers, provider IDs need to be unique.
+func NewFederation(redirectURL string, challengeLifetime time.Duration, providers []*FederationProvider) (*Federation, error) {
+
+	ids := []string{}
+	for _, provider := range providers {
+		if slices.Contains(ids, provider.ID) {
+			return nil, fmt.Errorf("the federation provider '%s' is configured twice", provider.ID)
+		}
+		ids = append(ids, provider.ID)
+	}
+	if len(providers) > 0 && !ValidRedirectURI(redirectURL) {
+		return nil, errors.New("the federation redirect URL is invalid")
+	}
+	return &Federation{Providers: providers, RedirectURL: redirectURL, ChallengeLifetime: challengeLifetime}, nil
+}
+
+// Provider returns the provider with the given ID or nil if there is no such provider.
+func (federation *Federation) Provider(id string) *FederationProvider {
+
+	for _, provider := range federation.Providers {
+		if provider.ID == id {
+			return provider
+		}
+	}
+	return nil
+}
+
+// FederationProvider is an external OpenID Connect provider. The endpoints and keys of the provider are
+// discovered on first use, so the identity service starts even if a provider is unavailable.
+type FederationProvider struct {
+	ID           string       `json:"federation_provider_id"`
+	Name         string       `json:"federation_provider_name"`
+	Issuer       string       `json:"-"`
+	ClientID     string       `json:"-"`
+	ClientSecret string       `json:"-"`
+	Client       *http.Client `json:"-"`
+
+	mutex         sync.Mutex
+	metadata      *federationProviderMetadata
+	keys          map[string]ValidationKey
+	keysFetchedAt time.Time
+}
+
+// federationProviderMetadata is the part of the OpenID Connect discovery document the login needs.
+type federationProviderMetadata struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JSONWebKeySetURI         string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// NewFederationProvider returns the provider with the given issuer and the credentials of the identity service as its client.
+func NewFederationProvider(id string, name string, issuer string, clientID string, clientSecret string) (*FederationProvider, error) {
+
+	if id == "" || len(id) > 64 {
+		return nil, errors.New("the federation provider ID needs to have between 1 and 64 characters")
+	}
+	// plain HTTP is only allowed for providers on the same host, like test providers during development
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.Scheme != "https" && (parsed.Scheme != "http" || !isLoopbackHost(parsed.Hostname())) {
+		return nil, fmt.Errorf("the issuer of the federation provider '%s' needs to be a HTTPS URL", id)
+	}
+	if clientID == "" {
+		return nil, fmt.Errorf("the client ID of the federation provider '%s' is required", id)
+	}
+	if name == "" {
+		name = id
+	}
+	return &FederationProvider{
+		ID:           id,
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// FederatedIdentity is the identity of a user at an external provider as stated by a verified ID token.
+type FederatedIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// federatedIDTokenClaims are the claims of ID tokens issued by external providers. Some providers send
+// email_verified as a string, so it is decoded by hand.
+type federatedIDTokenClaims struct {
+	Email           string          `json:"email"`
+	EmailVerified   json.RawMessage `json:"email_verified"`
+	Nonce           string          `json:"nonce"`
+	AuthorizedParty string          `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+func (claims *federatedIDTokenClaims) emailVerified() bool {
+
+	var verified interface{}
+	if json.Unmarshal(claims.EmailVerified, &verified) != nil {
+		return false
+	}
+	return verified == true || verified == "true"
+}
+
+// FederatedLoginChallenge is returned when a login with an external provider starts. The user is sent to the
+// authorization URL and the web app sends the state back together with the code the provider returned.
+type FederatedLoginChallenge struct {
+	State            string `json:"federation_state"`
+	AuthorizationURL string `json:"federation_authorization_url"`
+}
+
+// FederatedLogin is a started login with an external provider as it is stored in the database. The user is set if
+// the login links the external identity to an existing user.
+type FederatedLogin struct {
+	ID           int
+	Provider     string
+	UserID       *int
+	Nonce        string
+	CodeVerifier string
+}
+
+// LinkedIdentity links the identity of a user at an external provider to the user, so the user can login with the provider.
+type LinkedIdentity struct {
+	ID         int        `json:"linked_identity_id" sql:"linked_identity_id"`
+	UserID     int        `json:"linked_identity_user" sql:"linked_identity_user"`
+	Provider   string     `json:"linked_identity_provider" sql:"linked_identity_provider"`
+	Subject    string     `json:"linked_identity_subject" sql:"linked_identity_subject"`
+	Email      string     `json:"linked_identity_email" sql:"linked_identity_email"`
+	CreateDate time.Time  `json:"linked_identity_createdat" sql:"linked_identity_createdat"`
+	LastUsedAt *time.Time `json:"linked_identity_lastusedat" sql:"linked_identity_lastusedat"`
+}
+
+// NewCodeVerifier returns a random PKCE code verifier together with its S256 challenge.
+func NewCodeVerifier() (string, string, error) {
+
+	verifier, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthorizationURL returns the URL users are sent to for logging in with the provider.
+func (provider *FederationProvider) AuthorizationURL(redirectURL string, state string, nonce string, codeChallenge string) (string, error) {
+
+	metadata, err := provider.discover()
+	if err != nil {
+		return "", err
+	}
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", provider.ClientID)
+	values.Set("redirect_uri", redirectURL)
+	values.Set("scope", federationScope)
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", codeChallenge)
+	values.Set("code_challenge_method", OAuthCodeChallengeMethod)
+	return AppendQuery(metadata.AuthorizationEndpoint, values), nil
+}
+
+// Exchange exchanges the authorization code for an ID token of the user and returns the identity it states.
+// The ID token needs to be signed by the provider, issued to the identity service and contain the nonce of the login.
+func (provider *FederationProvider) Exchange(code string, redirectURL string, codeVerifier string, nonce string) (*FederatedIdentity, error) {
+
+	metadata, err := provider.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("grant_type", OAuthGrantAuthorizationCode)
+	values.Set("code", code)
+	values.Set("redirect_uri", redirectURL)
+	values.Set("code_verifier", codeVerifier)
+	// client_secret_basic is the default of OpenID Connect, client_secret_post is used if the provider only supports it
+	basicAuth := len(metadata.TokenEndpointAuthMethods) == 0 || slices.Contains(metadata.TokenEndpointAuthMethods, "client_secret_basic")
+	if !basicAuth {
+		values.Set("client_id", provider.ClientID)
+		values.Set("client_secret", provider.ClientSecret)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if basicAuth {
+		request.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+	}
+
+	body, status, err := provider.do(request)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || response.Error != "" {
+		return nil, fmt.Errorf("the token endpoint responded with status %d: %s %s", status, response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return nil, errors.New("the token endpoint responded without an ID token")
+	}
+	return provider.verifyIDToken(metadata, response.IDToken, nonce)
+}
+
+// verifyIDToken verifies the ID token against the exact issuer of the discovery document, which may differ from the
+// configured issuer by a trailing slash.
+func (provider *FederationProvider) verifyIDToken(metadata *federationProviderMetadata, idToken string, nonce string) (*FederatedIdentity, error) {
+
+	parsed, err := jwt.ParseWithClaims(idToken, &federatedIDTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := provider.key(keyID)
+		if err != nil {
+			return nil, err
+		}
+		// a key may only be used with the algorithm it was published for
+		if key.Algorithm != token.Method.Alg() {
+			return nil, errors.New("signing method in ID token does not match the signing key")
+		}
+		return key.Key, nil
+	}, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}), jwt.WithIssuer(metadata.Issuer), jwt.WithAudience(provider.ClientID), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := parsed.Claims.(*federatedIDTokenClaims)
+	if !ok || claims.Subject == "" {
+		return nil, errors.New("the ID token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("the nonce of the ID token does not match the login")
+	}
+	// the authorized party needs to be the identity service if the token was issued for several audiences
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != provider.ClientID {
+		return nil, errors.New("the ID token was issued to another authorized party")
+	}
+	return &FederatedIdentity{
+		Provider:      provider.ID,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.emailVerified(),
+	}, nil
+}
+
+// discover fetches the discovery document of the provider, it is cached once it was fetched successfully.
+func (provider *FederationProvider) discover() (*federationProviderMetadata, error) {
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.metadata != nil {
+		return provider.metadata, nil
+	}
+
+	request, err := http.NewRequest(http.MethodGet, provider.Issuer+OpenIDConfigurationPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, status, err := provider.do(request)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("the discovery document of the provider '%s' responded with status %d", provider.ID, status)
+	}
+	var metadata federationProviderMetadata
+	err = json.Unmarshal(body, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("the discovery document of the provider '%s' belongs to another issuer", provider.ID)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JSONWebKeySetURI == "" {
+		return nil, fmt.Errorf("the discovery document of the provider '%s' is missing endpoints", provider.ID)
+	}
+	provider.metadata = &metadata
+	return provider.metadata, nil
+}
+
+// key returns the signing key of the provider with the given key ID. The keys are fetched again if the key ID is
+// unknown, because providers rotate their keys.
+func (provider *FederationProvider) key(keyID string) (*ValidationKey, error) {
+
+	metadata, err := provider.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if key, ok := provider.keys[keyID]; ok {
+		return &key, nil
+	}
+	if time.Since(provider.keysFetchedAt) < federationKeysRefreshInterval {
+		return nil, errors.New("unknown signing key of the ID token")
+	}
+	provider.keysFetchedAt = time.Now()
+
+	request, err := http.NewRequest(http.MethodGet, metadata.JSONWebKeySetURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, status, err := provider.do(request)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("the keys of the provider '%s' responded with status %d", provider.ID, status)
+	}
+	keys, err := parseFederationKeySet(body)
+	if err != nil {
+		return nil, err
+	}
+	provider.keys = keys
+	if key, ok := provider.keys[keyID]; ok {
+		return &key, nil
+	}
+	return nil, errors.New("unknown signing key of the ID token")
+}
+
+func (provider *FederationProvider) do(request *http.Request) ([]byte, int, error) {
+
+	resp, err := provider.Client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+// parseFederationKeySet parses the keys of an external provider. Other than the keys of the identity service,
+// keys of external providers may omit the algorithm, it is then derived from the key type.
+func parseFederationKeySet(body []byte) (map[string]ValidationKey, error) {
+
+	var keySet JSONWebKeySet
+	err := json.Unmarshal(body, &keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]ValidationKey{}
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Debug().Err(err).Str("kid", jwk.KeyID).Msg("Skipping unsupported JSON web key of federation provider.")
+			continue
+		}
+		algorithm := jwk.Algorithm
+		if algorithm == "" {
+			algorithm, err = algorithmForKey(key)
+			if err != nil {
+				continue
+			}
+		}
+		if !keyMatchesAlgorithm(key, algorithm) {
+			continue
+		}
+		keys[jwk.KeyID] = ValidationKey{Algorithm: algorithm, Key: key}
+	}
+	return keys, nil
+}
@@ -0,0 +1,174 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testFederationClientID = "festivals-identity"
+	testFederationCode     = "code-1"
+	testFederationVerifier = "verifier-1"
+	testFederationNonce    = "nonce-1"
+)
+
+// fakeIdentityProvider is an external OpenID Connect provider that issues the configured ID token claims for the
+// test code, the claims are signed with the key it publishes.
+type fakeIdentityProvider struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newFakeIdentityProvider(t *testing.T) *fakeIdentityProvider {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &fakeIdentityProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc(OpenIDConfigurationPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(federationProviderMetadata{
+			Issuer:                provider.server.URL,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JSONWebKeySetURI:      provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := NewJSONWebKey("key-1", AlgorithmES256, &key.PublicKey)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, _, ok := r.BasicAuth()
+		if !ok || clientID != testFederationClientID || r.PostFormValue("code") != testFederationCode || r.PostFormValue("code_verifier") != testFederationVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": OAuthErrorInvalidGrant})
+			return
+		}
+		idToken := jwt.NewWithClaims(jwt.SigningMethodES256, provider.claims)
+		idToken.Header["kid"] = "key-1"
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+// validClaims returns the claims of a valid ID token for the login of the test user.
+func (provider *fakeIdentityProvider) validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            provider.server.URL,
+		"sub":            "subject-1",
+		"aud":            testFederationClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          testFederationNonce,
+		"email":          "user@festivalsapp.org",
+		"email_verified": true,
+	}
+}
+
+func TestNewFederationProviderIssuer(t *testing.T) {
+
+	issuers := map[string]bool{
+		"https://accounts.google.com": true,
+		"http://127.0.0.1:8080":       true,
+		"http://localhost:8080":       true,
+		"http://[::1]:8080":           true,
+		"http://accounts.google.com":  false,
+		"festivals://accounts":        false,
+		"https://":                    false,
+		"accounts.google.com":         false,
+	}
+	for issuer, valid := range issuers {
+		_, err := NewFederationProvider("provider", "", issuer, testFederationClientID, "")
+		if valid != (err == nil) {
+			t.Errorf("issuer %q: expected valid %t, got error %v", issuer, valid, err)
+		}
+	}
+}
+
+func TestFederationProviderExchange(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		claims        func(claims jwt.MapClaims)
+		valid         bool
+		emailVerified bool
+	}{
+		{name: "valid", claims: func(claims jwt.MapClaims) {}, valid: true, emailVerified: true},
+		{name: "wrong nonce", claims: func(claims jwt.MapClaims) { claims["nonce"] = "nonce-2" }},
+		{name: "missing nonce", claims: func(claims jwt.MapClaims) { delete(claims, "nonce") }},
+		{name: "wrong audience", claims: func(claims jwt.MapClaims) { claims["aud"] = "other-client" }},
+		{name: "wrong authorized party", claims: func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testFederationClientID, "other-client"}
+			claims["azp"] = "other-client"
+		}},
+		{name: "authorized party", claims: func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testFederationClientID, "other-client"}
+			claims["azp"] = testFederationClientID
+		}, valid: true, emailVerified: true},
+		{name: "wrong issuer", claims: func(claims jwt.MapClaims) { claims["iss"] = "https://accounts.google.com" }},
+		{name: "expired", claims: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "unverified email", claims: func(claims jwt.MapClaims) { claims["email_verified"] = false }, valid: true},
+		{name: "email verified as string", claims: func(claims jwt.MapClaims) { claims["email_verified"] = "true" }, valid: true, emailVerified: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			idp := newFakeIdentityProvider(t)
+			idp.claims = idp.validClaims()
+			test.claims(idp.claims)
+			provider, err := NewFederationProvider("provider", "", idp.server.URL, testFederationClientID, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, err := provider.Exchange(testFederationCode, "https://festivalsapp.org/login/federated", testFederationVerifier, testFederationNonce)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected the ID token to be rejected, got %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the ID token to be accepted: %v", err)
+			}
+			if identity.Provider != "provider" || identity.Subject != "subject-1" || identity.Email != "user@festivalsapp.org" || identity.EmailVerified != test.emailVerified {
+				t.Fatalf("unexpected identity: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestFederationProviderExchangeWrongCode(t *testing.T) {
+
+	idp := newFakeIdentityProvider(t)
+	idp.claims = idp.validClaims()
+	provider, err := NewFederationProvider("provider", "", idp.server.URL, testFederationClientID, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Exchange("code-2", "https://festivalsapp.org/login/federated", testFederationVerifier, testFederationNonce)
+	if err == nil {
+		t.Fatal("expected the exchange of an unknown code to fail")
+	}
+}
@@ -1,8 +1,10 @@
 package token
 
 import (
+	"net"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 )
@@ -15,6 +17,41 @@ func GetServiceToken(r *http.Request) string {
 	return r.Header.Get("Service-Key")
 }
 
+// GetAppIdentifier returns the identifier of the app the request was send from.
+func GetAppIdentifier(r *http.Request) string {
+	return r.Header.Get("App-Identifier")
+}
+
+// GetDeviceName returns the name of the device the request was send from.
+// Clients should send a stable "Device-Name" header, if they don't the user agent is used instead.
+func GetDeviceName(r *http.Request) string {
+	device := r.Header.Get("Device-Name")
+	if device == "" {
+		device = r.UserAgent()
+	}
+	// the device is stored in a column of 255 characters
+	return TruncateString(device, 255)
+}
+
+// TruncateString returns the valid UTF-8 of the value with at most the given number of characters,
+// it is cut on rune boundaries so it can be stored in a utf8mb4 column of that length.
+func TruncateString(value string, length int) string {
+	value = strings.ToValidUTF8(value, "")
+	if utf8.RuneCountInString(value) > length {
+		value = string([]rune(value)[:length])
+	}
+	return value
+}
+
+// GetClientIP returns the IP address the request was send from.
+func GetClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func GetValidClaims(r *http.Request, validator *ValidationService) *UserClaims {
 
 	tokenString := getBearerToken(r)
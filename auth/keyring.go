@@ -0,0 +1,140 @@
+package token
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The states a signing key can be in. A new key starts as KeyStateNext so validation services
+// learn about it before any token is signed with it, the active key signs all new tokens and
+// previous keys are only used to validate tokens that were issued before a rotation.
+const (
+	KeyStateNext     string = "next"
+	KeyStateActive   string = "active"
+	KeyStatePrevious string = "previous"
+	KeyStateRetired  string = "retired"
+)
+
+type SigningKey struct {
+	ID         string    `json:"signing_key_id" sql:"signing_key_id"`
+	Algorithm  string    `json:"signing_key_algorithm" sql:"signing_key_algorithm"`
+	State      string    `json:"signing_key_state" sql:"signing_key_state"`
+	PrivateKey string    `json:"-" sql:"signing_key_private"`
+	PublicKey  string    `json:"signing_key_public" sql:"signing_key_public"`
+	CreateDate time.Time `json:"signing_key_createdat" sql:"signing_key_createdat"`
+	UpdateDate time.Time `json:"signing_key_updatedat" sql:"signing_key_updatedat"`
+}
+
+// GenerateSigningKey creates a new signing key for the given algorithm in the KeyStateNext state.
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+
+	privateKey, err := generatePrivateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(privateKey, algorithm, KeyStateNext)
+}
+
+func newSigningKey(privateKey crypto.Signer, algorithm string, state string) (*SigningKey, error) {
+
+	if !keyMatchesAlgorithm(privateKey.Public(), algorithm) {
+		return nil, errors.New("the key can not be used with the signing algorithm '" + algorithm + "'")
+	}
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := KeyThumbprint(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:         keyID,
+		Algorithm:  algorithm,
+		State:      state,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes})),
+	}, nil
+}
+
+// ValidationKey is a public key together with the only algorithm it may be used with.
+type ValidationKey struct {
+	Algorithm string
+	Key       crypto.PublicKey
+}
+
+// KeySet holds the public keys used to validate tokens by their key ID.
+// The default key is used for tokens without a key ID, which were issued before key rotation was supported.
+type KeySet struct {
+	lock       sync.RWMutex
+	keys       map[string]ValidationKey
+	defaultKey *ValidationKey
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]ValidationKey{}}
+}
+
+func (set *KeySet) Set(keys map[string]ValidationKey, defaultKey *ValidationKey) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.keys = keys
+	set.defaultKey = defaultKey
+}
+
+// SetKeys replaces the keys used for tokens with a key ID.
+func (set *KeySet) SetKeys(keys map[string]ValidationKey) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.keys = keys
+}
+
+// SetDefaultKey replaces the key used for tokens without a key ID.
+func (set *KeySet) SetDefaultKey(defaultKey *ValidationKey) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.defaultKey = defaultKey
+}
+
+// Algorithm returns the signing algorithm of the default key, which is the active signing key of the identity service.
+// Without a default key it is the algorithm all keys share, it is empty if the algorithm is unknown.
+func (set *KeySet) Algorithm() string {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	if set.defaultKey != nil {
+		return set.defaultKey.Algorithm
+	}
+	algorithm := ""
+	for _, key := range set.keys {
+		if algorithm != "" && key.Algorithm != algorithm {
+			return ""
+		}
+		algorithm = key.Algorithm
+	}
+	return algorithm
+}
+
+// Get returns the key for the given key ID or an error if the key is unknown.
+func (set *KeySet) Get(keyID string) (*ValidationKey, error) {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	if keyID == "" {
+		if set.defaultKey == nil {
+			return nil, errors.New("no default validation key")
+		}
+		return set.defaultKey, nil
+	}
+	key, ok := set.keys[keyID]
+	if !ok {
+		return nil, errors.New("unknown validation key '" + keyID + "'")
+	}
+	return &key, nil
+}
@@ -0,0 +1,41 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// legacyKeyPrefixLength is the number of characters of the hash of a plaintext key
+// that are used as the visible prefix when the key is hashed.
+const legacyKeyPrefixLength = 12
+
+// newKey returns a new key of the given kind in the format '<kind>_<id>_<secret>'
+// and its visible prefix '<kind>_<id>'.
+func newKey(kind string) (string, string, error) {
+
+	id := make([]byte, 6)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	prefix := kind + "_" + base64.RawURLEncoding.EncodeToString(id)
+	return prefix, prefix + "_" + secret, nil
+}
+
+// HashKey returns the hex encoded SHA-256 hash of the given API or service key as it is stored in the database.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LegacyKeyPrefix returns the visible prefix of a key that was stored in plaintext. It is derived from the hash
+// of the key, so the prefix doesn't reveal any characters of a key that was never rotated.
+func LegacyKeyPrefix(key string) string {
+	return "legacy_" + HashKey(key)[:legacyKeyPrefixLength]
+}
@@ -0,0 +1,159 @@
+package token
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LoginAttempt is a successful or failed login of an email from an IP address.
+type LoginAttempt struct {
+	ID         int       `json:"attempt_id" sql:"attempt_id"`
+	Email      string    `json:"attempt_email" sql:"attempt_email"`
+	User       *int      `json:"attempt_user" sql:"attempt_user"`
+	IP         string    `json:"attempt_ip" sql:"attempt_ip"`
+	Peer       string    `json:"attempt_peer" sql:"attempt_peer"`
+	Success    bool      `json:"attempt_success" sql:"attempt_success"`
+	Cleared    bool      `json:"attempt_cleared" sql:"attempt_cleared"`
+	CreateDate time.Time `json:"attempt_createdat" sql:"attempt_createdat"`
+}
+
+// LoginThrottle describes how logins are slowed down after failed attempts. Once an account or an IP address
+// reached its threshold of failed attempts it is locked, the lockout doubles with every further failed attempt.
+type LoginThrottle struct {
+	// AccountThreshold is the number of failed attempts for an email before the email is locked.
+	AccountThreshold int
+	// IPThreshold is the number of failed attempts from an IP address before the IP address is locked.
+	IPThreshold int
+	// Lockout is the lockout after the threshold was reached.
+	Lockout time.Duration
+	// MaxLockout is the longest lockout.
+	MaxLockout time.Duration
+	// Window is how long failed attempts count towards the thresholds.
+	Window time.Duration
+	// TrustedProxies are the proxies that forward logins of clients, the IP address of the client
+	// is taken from the ClientIPHeader of their requests instead of the connection.
+	TrustedProxies []*net.IPNet
+	// ClientIPHeader is the header trusted proxies send the IP address of the client in.
+	ClientIPHeader string
+}
+
+// NewLoginThrottle returns a login throttle for the given thresholds and durations. The trusted proxies are
+// IP addresses or CIDR ranges, the IP address of clients is read from the given header of their requests.
+func NewLoginThrottle(accountThreshold int, ipThreshold int, lockout time.Duration, maxLockout time.Duration, window time.Duration, trustedProxies []string, clientIPHeader string) (*LoginThrottle, error) {
+
+	if accountThreshold < 1 || ipThreshold < 1 {
+		return nil, errors.New("the login attempt thresholds need to be at least 1")
+	}
+	if lockout <= 0 || maxLockout < lockout || window <= 0 {
+		return nil, errors.New("invalid login lockout durations")
+	}
+	if len(trustedProxies) > 0 && clientIPHeader == "" {
+		return nil, errors.New("trusted proxies need a client IP header")
+	}
+	proxies := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		network, err := parseIPNet(proxy)
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy '" + proxy + "'")
+		}
+		proxies = append(proxies, network)
+	}
+	return &LoginThrottle{
+		AccountThreshold: accountThreshold,
+		IPThreshold:      ipThreshold,
+		Lockout:          lockout,
+		MaxLockout:       maxLockout,
+		Window:           window,
+		TrustedProxies:   proxies,
+		ClientIPHeader:   clientIPHeader,
+	}, nil
+}
+
+// parseIPNet parses a CIDR range or a single IP address.
+func parseIPNet(value string) (*net.IPNet, error) {
+
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.New("invalid IP address")
+	}
+	bits := 8 * len(ip.To16())
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// ClientIP returns the IP address failed logins of the request are counted for. Requests of trusted proxies
+// are counted for the last address in the client IP header that wasn't added by a trusted proxy, so clients
+// can't choose their address by sending the header themselves.
+func (throttle *LoginThrottle) ClientIP(r *http.Request) string {
+
+	ip := GetClientIP(r)
+	if !throttle.isTrustedProxy(ip) {
+		return ip
+	}
+	addresses := strings.Split(strings.Join(r.Header.Values(throttle.ClientIPHeader), ","), ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if net.ParseIP(address) == nil {
+			break
+		}
+		ip = address
+		if !throttle.isTrustedProxy(address) {
+			break
+		}
+	}
+	return ip
+}
+
+func (throttle *LoginThrottle) isTrustedProxy(ip string) bool {
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range throttle.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountDelay returns how long the email is still locked after the given number of failed attempts,
+// the last one being the given time ago.
+func (throttle *LoginThrottle) AccountDelay(failures int, sinceLastFailure time.Duration) time.Duration {
+	return throttle.delay(failures, throttle.AccountThreshold, sinceLastFailure)
+}
+
+// IPDelay returns how long the IP address is still locked after the given number of failed attempts,
+// the last one being the given time ago.
+func (throttle *LoginThrottle) IPDelay(failures int, sinceLastFailure time.Duration) time.Duration {
+	return throttle.delay(failures, throttle.IPThreshold, sinceLastFailure)
+}
+
+func (throttle *LoginThrottle) delay(failures int, threshold int, sinceLastFailure time.Duration) time.Duration {
+
+	if failures < threshold {
+		return 0
+	}
+	lockout := throttle.Lockout
+	for i := threshold; i < failures && lockout < throttle.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > throttle.MaxLockout {
+		lockout = throttle.MaxLockout
+	}
+	if remaining := lockout - sinceLastFailure; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
@@ -0,0 +1,322 @@
+package token
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "festivals-user-claims"
+const apiKeyContextKey contextKey = "festivals-api-key"
+const serviceClaimsContextKey contextKey = "festivals-service-claims"
+const serviceIdentityContextKey contextKey = "festivals-service-identity"
+
+// KeySource lets a validation service check API and service keys against another store
+// than the keys loaded from the identity service, e.g. the identity service's own database.
+type KeySource interface {
+	// APIKey returns the given API key or nil if the key is unknown or not usable.
+	APIKey(key string) (*APIKey, error)
+	// ServiceKey returns the given service key or nil if the key is unknown or not usable.
+	ServiceKey(key string) (*ServiceKey, error)
+}
+
+// ContextWithClaims returns a copy of the given context carrying the given claims.
+func ContextWithClaims(ctx context.Context, claims *UserClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims put into the context by RequireJWT or RequireServiceKey.
+func ClaimsFromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*UserClaims)
+	return claims, ok && claims != nil
+}
+
+// ClaimsFromRequest returns the claims put into the request context by RequireJWT or RequireServiceKey.
+func ClaimsFromRequest(r *http.Request) (*UserClaims, bool) {
+	return ClaimsFromContext(r.Context())
+}
+
+// APIKeyFromContext returns the API key put into the context by RequireAPIKey or RequireAPIScope.
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(*APIKey)
+	return key, ok && key != nil
+}
+
+// ServiceClaimsFromContext returns the claims of the service token put into the context by RequireServiceKey or RequireServiceScope.
+func ServiceClaimsFromContext(ctx context.Context) (*ServiceClaims, bool) {
+	claims, ok := ctx.Value(serviceClaimsContextKey).(*ServiceClaims)
+	return claims, ok && claims != nil
+}
+
+// ServiceClaimsFromRequest returns the claims of the service token put into the request context by RequireServiceKey or RequireServiceScope.
+func ServiceClaimsFromRequest(r *http.Request) (*ServiceClaims, bool) {
+	return ServiceClaimsFromContext(r.Context())
+}
+
+// ServiceIdentityFromContext returns the service identity of the client certificate put into the context by
+// RequireServiceKey or RequireServiceScope.
+func ServiceIdentityFromContext(ctx context.Context) (*ServiceIdentity, bool) {
+	identity, ok := ctx.Value(serviceIdentityContextKey).(*ServiceIdentity)
+	return identity, ok && identity != nil
+}
+
+// ServiceIdentityFromRequest returns the service identity of the client certificate put into the request context by
+// RequireServiceKey or RequireServiceScope.
+func ServiceIdentityFromRequest(r *http.Request) (*ServiceIdentity, bool) {
+	return ServiceIdentityFromContext(r.Context())
+}
+
+// RequireJWT rejects requests without a valid JWT and puts the claims of the JWT into the request context.
+func (validator *ValidationService) RequireJWT(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		claims := GetValidClaims(r, validator)
+		if claims == nil {
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	})
+}
+
+// RequireJWTWithScope works like RequireJWT but accepts JWTs of every audience as long as they were issued
+// to an OAuth client that was granted the given scope, like the userinfo endpoint of the identity service.
+func (validator *ValidationService) RequireJWTWithScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			claims, err := validator.ValidateAccessTokenForScope(getBearerToken(r), scope)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to validate access token for user.")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				servertools.UnauthorizedResponse(w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// RequireAPIKey rejects requests without a valid API key and puts the API key into the request context.
+// Requests from other origins than the one the API key is restricted to are rejected as well as
+// requests exceeding the rate limit of the API key.
+func (validator *ValidationService) RequireAPIKey(next http.Handler) http.Handler {
+	return validator.requireAPIKey("", next)
+}
+
+// RequireAPIScope works like RequireAPIKey but also rejects API keys without the given scope.
+func (validator *ValidationService) RequireAPIScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return validator.requireAPIKey(scope, next)
+	}
+}
+
+func (validator *ValidationService) requireAPIKey(scope string, next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		key, err := validator.lookupAPIKey(GetAPIToken(r))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check API key.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if key == nil {
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if scope != "" && !key.HasScope(scope) {
+			log.Error().Msg("API key '" + key.Prefix + "' is missing the scope '" + scope + "' to access '" + r.URL.Path + "'.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if !key.AllowsOrigin(r.Header.Get("Origin"), GetAppIdentifier(r)) {
+			log.Error().Msg("API key '" + key.Prefix + "' is not allowed to be used from this origin.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		allowed, retryAfter := validator.limiter.Allow(key.ID, key.RateLimit)
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			servertools.RespondError(w, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+	})
+}
+
+// RequireServiceKey rejects requests without a valid service key, service token or the client certificate of a
+// service identity. Requests without either are accepted if they carry a valid JWT of a user with the permissions
+// named like all service scopes, the claims are put into the request context.
+func (validator *ValidationService) RequireServiceKey(next http.Handler) http.Handler {
+	return validator.requireServiceKey("", next)
+}
+
+// RequireServiceScope works like RequireServiceKey but also rejects service tokens and service identities without
+// the given scope, users only need the permission named like the scope. Service keys are still accepted until every
+// service switched to service tokens, but only for the scopes of the key.
+func (validator *ValidationService) RequireServiceScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return validator.requireServiceKey(scope, next)
+	}
+}
+
+func (validator *ValidationService) requireServiceKey(scope string, next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		servicekey := GetServiceToken(r)
+		if servicekey == "" {
+			bearer := getBearerToken(r)
+			if isServiceToken(bearer) {
+				claims, err := validator.ValidateServiceToken(bearer, scope)
+				if err != nil {
+					log.Error().Err(err).Str("peer", GetPeerName(r)).Msg("Service token is not authorized to access '" + r.URL.Path + "'.")
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					servertools.UnauthorizedResponse(w)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serviceClaimsContextKey, claims)))
+				return
+			}
+			// requests with the JWT of a user act on behalf of the user, even if they are forwarded by a service
+			if bearer == "" {
+				identity := validator.ServiceIdentities.Identify(GetPeerCertificate(r))
+				if identity != nil && (scope == "" || identity.HasScope(scope)) {
+					log.Debug().Str("service", identity.Name).Str("peer", GetPeerName(r)).Msg("Service authenticated with its client certificate to access '" + r.URL.Path + "'.")
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serviceIdentityContextKey, identity)))
+					return
+				}
+				if identity != nil {
+					log.Error().Str("service", identity.Name).Str("peer", GetPeerName(r)).Msg("Service is missing the scope '" + scope + "' to access '" + r.URL.Path + "'.")
+				}
+			}
+			claims := GetValidClaims(r, validator)
+			if claims != nil && hasServicePermission(claims, scope) {
+				next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+				return
+			}
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		key, err := validator.lookupServiceKey(servicekey)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check service key.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if key == nil {
+			log.Error().Str("peer", GetPeerName(r)).Msg("Invalid service key was sent to access '" + r.URL.Path + "'.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if !key.HasScope(scope) {
+			log.Error().Str("key", key.Prefix).Str("peer", GetPeerName(r)).Msg("Service key is missing the scope '" + scope + "' to access '" + r.URL.Path + "'.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasServicePermission returns true if the user has the permission named like the service scope,
+// without a scope the user needs the permissions of all service scopes.
+func hasServicePermission(claims *UserClaims, scope string) bool {
+	if scope != "" {
+		return claims.HasPermission(scope)
+	}
+	for _, scope := range ServiceKeyScopes {
+		if !claims.HasPermission(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequirePermission rejects requests whose claims do not have the given permission.
+// It needs to be used after RequireJWT.
+func (validator *ValidationService) RequirePermission(permission string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			claims, ok := ClaimsFromRequest(r)
+			if !ok || !claims.HasPermission(permission) {
+				log.Error().Msg("User is missing the permission '" + permission + "' to access '" + r.URL.Path + "'.")
+				servertools.UnauthorizedResponse(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole rejects requests whose claims do not have the given user role, prefer RequirePermission
+// so the access can be changed with the permissions of the roles. It needs to be used after RequireJWT.
+func (validator *ValidationService) RequireRole(role int) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			claims, ok := ClaimsFromRequest(r)
+			if !ok || claims.UserRole != role {
+				log.Error().Msg("User is not authorized to access '" + r.URL.Path + "'.")
+				servertools.UnauthorizedResponse(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireOwnership rejects requests of users that are not associated with the entity
+// identified by the "objectID" URL parameter. Users with the entities:write permission are allowed to access every entity.
+// It needs to be used after RequireJWT.
+func (validator *ValidationService) RequireOwnership(entity Entity) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			claims, ok := ClaimsFromRequest(r)
+			if !ok {
+				servertools.UnauthorizedResponse(w)
+				return
+			}
+			if claims.HasPermission(PermissionEntitiesWrite) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			objectID, err := strconv.Atoi(chi.URLParam(r, "objectID"))
+			if err != nil || !claims.OwnsEntity(entity, objectID) {
+				log.Error().Msg("User is not authorized to access " + string(entity) + " '" + chi.URLParam(r, "objectID") + "'.")
+				servertools.UnauthorizedResponse(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (validator *ValidationService) lookupAPIKey(key string) (*APIKey, error) {
+	if validator.KeySource != nil {
+		return validator.KeySource.APIKey(key)
+	}
+	apiKey, _ := validator.APIKey(key)
+	return apiKey, nil
+}
+
+func (validator *ValidationService) lookupServiceKey(key string) (*ServiceKey, error) {
+	if validator.KeySource != nil {
+		return validator.KeySource.ServiceKey(key)
+	}
+	serviceKey, _ := validator.ServiceKey(key)
+	return serviceKey, nil
+}
@@ -0,0 +1,55 @@
+package token
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testKeySource knows a single service key with the given scopes.
+type testKeySource struct {
+	key ServiceKey
+}
+
+func (source *testKeySource) APIKey(key string) (*APIKey, error) {
+	return nil, nil
+}
+
+func (source *testKeySource) ServiceKey(key string) (*ServiceKey, error) {
+	if HashKey(key) != source.key.Hash || !source.key.IsUsable() {
+		return nil, nil
+	}
+	return &source.key, nil
+}
+
+func TestRequireServiceScopeWithServiceKey(t *testing.T) {
+
+	source := &testKeySource{key: ServiceKey{Prefix: "prefix", Hash: HashKey("service-key"), Enabled: true, Scopes: []string{ServiceScopeKeysRead}}}
+	validator := &ValidationService{KeySource: source}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		key    string
+		scope  string
+		status int
+	}{
+		{name: "granted scope", key: "service-key", scope: ServiceScopeKeysRead, status: http.StatusOK},
+		{name: "missing scope", key: "service-key", scope: ServiceScopeUserEntities, status: http.StatusUnauthorized},
+		{name: "no scope", key: "service-key", scope: "", status: http.StatusOK},
+		{name: "unknown key", key: "other-key", scope: ServiceScopeKeysRead, status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/service", nil)
+			r.Header.Set("Service-Key", test.key)
+			w := httptest.NewRecorder()
+			validator.RequireServiceScope(test.scope)(ok).ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The scopes clients can request in addition to the access token.
+const (
+	// OAuthScopeOfflineAccess lets the client refresh the access token without the user.
+	OAuthScopeOfflineAccess = "offline_access"
+	// OAuthScopeOpenID lets the client get an ID token and call the userinfo endpoint.
+	OAuthScopeOpenID = "openid"
+	// OAuthScopeEmail adds the email of the user to the ID token and the userinfo.
+	OAuthScopeEmail = "email"
+)
+
+// OAuthScopes lists all scopes clients can request.
+var OAuthScopes = []string{OAuthScopeOpenID, OAuthScopeEmail, OAuthScopeOfflineAccess}
+
+// The error codes of OAuth 2.0 as defined in RFC 6749.
+const (
+	OAuthErrorInvalidRequest          = "invalid_request"
+	OAuthErrorInvalidClient           = "invalid_client"
+	OAuthErrorInvalidGrant            = "invalid_grant"
+	OAuthErrorUnauthorizedClient      = "unauthorized_client"
+	OAuthErrorUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthErrorUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrorInvalidScope            = "invalid_scope"
+	OAuthErrorAccessDenied            = "access_denied"
+	OAuthErrorServerError             = "server_error"
+)
+
+// The grant types the token endpoint supports.
+const (
+	OAuthGrantAuthorizationCode = "authorization_code"
+	OAuthGrantRefreshToken      = "refresh_token"
+	// OAuthGrantClientCredentials issues service tokens to services that authenticate with their service key.
+	OAuthGrantClientCredentials = "client_credentials"
+)
+
+// OAuthCodeChallengeMethod is the only PKCE method clients can use, plain challenges are not accepted.
+const OAuthCodeChallengeMethod = "S256"
+
+// OAuthClient is an application that gets tokens of users with their consent. Clients reuse API keys as their
+// credentials, the client ID is the prefix of the API key and the secret is the API key itself.
+type OAuthClient struct {
+	APIKeyID     int       `json:"oauth_client_api_key" sql:"oauth_client_api_key"`
+	Name         string    `json:"oauth_client_name" sql:"oauth_client_name"`
+	RedirectURIs []string  `json:"oauth_client_redirect_uris" sql:"oauth_client_redirect_uris"`
+	Confidential bool      `json:"oauth_client_confidential" sql:"oauth_client_confidential"`
+	Trusted      bool      `json:"oauth_client_trusted" sql:"oauth_client_trusted"`
+	CreateDate   time.Time `json:"oauth_client_createdat" sql:"oauth_client_createdat"`
+	Audiences    []string  `json:"oauth_client_audiences" sql:"oauth_client_audiences"`
+	ClientID     string    `json:"oauth_client_id" sql:"api_key_prefix"`
+	SecretHash   string    `json:"-" sql:"api_key_hash"`
+	Usable       bool      `json:"oauth_client_usable" sql:"-"`
+	Secret       string    `json:"oauth_client_secret,omitempty" sql:"-"`
+}
+
+// AllowsRedirectURI returns true if the redirect URI was registered for the client, URIs need to match exactly.
+func (client *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	return slices.Contains(client.RedirectURIs, redirectURI)
+}
+
+// AccessTokenAudience returns the audience of the access tokens issued to the client. Without configured audiences the
+// tokens are restricted to the client itself, so they are only accepted by the userinfo endpoint.
+func (client *OAuthClient) AccessTokenAudience() []string {
+
+	if len(client.Audiences) == 0 {
+		return []string{client.ClientID}
+	}
+	return client.Audiences
+}
+
+// Authenticate returns true if the client may use the token endpoint with the given secret.
+// Confidential clients need their secret, public clients rely on PKCE and may omit it.
+func (client *OAuthClient) Authenticate(secret string) bool {
+
+	if !client.Usable {
+		return false
+	}
+	if secret == "" {
+		return !client.Confidential
+	}
+	return subtle.ConstantTimeCompare([]byte(HashKey(secret)), []byte(client.SecretHash)) == 1
+}
+
+// ValidRedirectURI returns true if the URI can be registered as a redirect URI. Redirect URIs need to be absolute
+// without a fragment, plain HTTP is only allowed for loopback addresses of native apps.
+func ValidRedirectURI(redirectURI string) bool {
+
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || len(redirectURI) > 2048 || strings.ContainsAny(redirectURI, " \t\r\n") {
+		return false
+	}
+	if parsed.Scheme == "http" {
+		return isLoopbackHost(parsed.Hostname())
+	}
+	return true
+}
+
+func isLoopbackHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// ParseOAuthScope splits the space separated scope, it returns false if a scope is unknown.
+func ParseOAuthScope(scope string) ([]string, bool) {
+
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(OAuthScopes, s) {
+			return nil, false
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, true
+}
+
+// ValidCodeChallenge returns true if the challenge is a base64url encoded SHA-256 hash.
+func ValidCodeChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// VerifyCodeVerifier returns true if the verifier is valid as defined in RFC 7636 and its S256 challenge matches the given one.
+func VerifyCodeVerifier(verifier string, challenge string) bool {
+
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, char := range verifier {
+		if !(char >= 'A' && char <= 'Z' || char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || strings.ContainsRune("-._~", char)) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// OAuthAuthorizationRequest is the request of a client to get an authorization code of the user.
+type OAuthAuthorizationRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Nonce               string `json:"nonce"`
+	// Consent is the decision of the user, either 'approve', 'deny' or empty if the user wasn't asked yet.
+	Consent string `json:"consent,omitempty"`
+}
+
+// NewOAuthAuthorizationRequest returns the authorization request of the given query parameters.
+func NewOAuthAuthorizationRequest(values url.Values) *OAuthAuthorizationRequest {
+	return &OAuthAuthorizationRequest{
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		ResponseType:        values.Get("response_type"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+// OAuthAuthorization is the result of an authorization request. The client is either redirected to the redirect URI
+// with the code or an error, or the user needs to consent to the requested scope first.
+type OAuthAuthorization struct {
+	RedirectURI     string `json:"redirect_uri,omitempty"`
+	ConsentRequired bool   `json:"consent_required,omitempty"`
+	ClientName      string `json:"oauth_client_name,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// OAuthError is an error as defined in RFC 6749. Errors of authorization requests with a valid
+// redirect URI are sent to the client by redirecting the user.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	RedirectURI string `json:"-"`
+	State       string `json:"-"`
+}
+
+func (err *OAuthError) Error() string {
+	return err.Code + ": " + err.Description
+}
+
+// RedirectURL returns the redirect URI with the error as query parameters.
+func (err *OAuthError) RedirectURL() string {
+
+	values := url.Values{}
+	values.Set("error", err.Code)
+	if err.Description != "" {
+		values.Set("error_description", err.Description)
+	}
+	if err.State != "" {
+		values.Set("state", err.State)
+	}
+	return AppendQuery(err.RedirectURI, values)
+}
+
+// AppendQuery adds the values to the query of the URI.
+func AppendQuery(uri string, values url.Values) string {
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := parsed.Query()
+	for key, value := range values {
+		query[key] = value
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// OAuthConsent records the scope a user granted a client, the user isn't asked again for the same scope.
+type OAuthConsent struct {
+	UserID     int       `json:"oauth_consent_user" sql:"oauth_consent_user"`
+	ClientID   int       `json:"oauth_consent_client" sql:"oauth_consent_client"`
+	Scopes     []string  `json:"oauth_consent_scopes" sql:"oauth_consent_scopes"`
+	CreateDate time.Time `json:"oauth_consent_createdat" sql:"oauth_consent_createdat"`
+	UpdateDate time.Time `json:"oauth_consent_updatedat" sql:"oauth_consent_updatedat"`
+	ClientName string    `json:"oauth_client_name" sql:"oauth_client_name"`
+}
+
+// Covers returns true if the user consented to all given scopes.
+func (consent *OAuthConsent) Covers(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(consent.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// OAuthCode is an authorization code as it is stored in the database, only the hash of the code is stored.
+// The family is the refresh token family started by the exchange, so it can be revoked if the code is used twice.
+type OAuthCode struct {
+	ID            int        `json:"-" sql:"oauth_code_id"`
+	Hash          string     `json:"-" sql:"oauth_code_hash"`
+	ClientID      int        `json:"-" sql:"oauth_code_client"`
+	UserID        int        `json:"-" sql:"oauth_code_user"`
+	RedirectURI   string     `json:"-" sql:"oauth_code_redirect_uri"`
+	Scopes        []string   `json:"-" sql:"oauth_code_scopes"`
+	CodeChallenge string     `json:"-" sql:"oauth_code_challenge"`
+	Nonce         string     `json:"-" sql:"oauth_code_nonce"`
+	Family        *string    `json:"-" sql:"oauth_code_family"`
+	CreateDate    time.Time  `json:"-" sql:"oauth_code_createdat"`
+	ExpiresAt     time.Time  `json:"-" sql:"oauth_code_expiresat"`
+	UsedAt        *time.Time `json:"-" sql:"oauth_code_usedat"`
+	Expired       bool       `json:"-" sql:"-"`
+}
+
+// OAuthTokenResponse is returned by the token endpoint, the refresh token is only issued for the offline_access scope
+// and the ID token only for the openid scope.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
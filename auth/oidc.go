@@ -0,0 +1,112 @@
+package token
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// The paths of the OpenID Connect endpoints relative to the issuer.
+const (
+	OpenIDConfigurationPath = "/.well-known/openid-configuration"
+	OAuthAuthorizePath      = "/oauth/authorize"
+	OAuthTokenPath          = "/oauth/token"
+	UserInfoPath            = "/userinfo"
+	JSONWebKeySetPath       = "/.well-known/jwks.json"
+)
+
+// IDTokenClaims are the claims of an OpenID Connect ID token, the audience is the client ID of the client it was
+// issued to. The email is only added if the client was granted the email scope.
+type IDTokenClaims struct {
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Nonce         string `json:"nonce,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// NewIDTokenClaims returns the ID token claims of the user for the given client.
+func NewIDTokenClaims(issuer string, user *User, clientID string, nonce string, scopes []string, lifetime time.Duration) *IDTokenClaims {
+
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Nonce: nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    issuer,
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		},
+	}
+	if slices.Contains(scopes, OAuthScopeEmail) {
+		claims.Email = user.Email
+		claims.EmailVerified = &user.Verified
+	}
+	return claims
+}
+
+// UserInfo is returned by the userinfo endpoint, it contains the same claims about the user as the ID token.
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// NewUserInfo returns the userinfo of the user for a client that was granted the given scope.
+func NewUserInfo(user *User, claims *UserClaims) *UserInfo {
+
+	info := &UserInfo{Subject: strconv.Itoa(user.ID)}
+	if claims.HasScope(OAuthScopeEmail) {
+		info.Email = user.Email
+		info.EmailVerified = &user.Verified
+	}
+	return info
+}
+
+// OpenIDConfiguration is the discovery document of the OpenID Connect provider.
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// NewOpenIDConfiguration returns the discovery document of the issuer, ID tokens are signed with one of the
+// algorithms of the published keys.
+func NewOpenIDConfiguration(issuer string, keys JSONWebKeySet) *OpenIDConfiguration {
+
+	algorithms := []string{}
+	for _, key := range keys.Keys {
+		if !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	return &OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + OAuthAuthorizePath,
+		TokenEndpoint:                     issuer + OAuthTokenPath,
+		UserInfoEndpoint:                  issuer + UserInfoPath,
+		JWKSURI:                           issuer + JSONWebKeySetPath,
+		ScopesSupported:                   OAuthScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{OAuthGrantAuthorizationCode, OAuthGrantRefreshToken, OAuthGrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{OAuthCodeChallengeMethod},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "email_verified"},
+	}
+}
@@ -0,0 +1,165 @@
+package token
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Passkey is a WebAuthn credential a user registered to login without a password.
+type Passkey struct {
+	ID              int        `json:"passkey_id" sql:"passkey_id"`
+	UserID          int        `json:"passkey_user" sql:"passkey_user"`
+	CredentialID    []byte     `json:"-" sql:"passkey_credential_id"`
+	PublicKey       []byte     `json:"-" sql:"passkey_public_key"`
+	AttestationType string     `json:"-" sql:"passkey_attestation_type"`
+	AAGUID          []byte     `json:"-" sql:"passkey_aaguid"`
+	SignCount       uint32     `json:"passkey_sign_count" sql:"passkey_sign_count"`
+	Transports      []string   `json:"passkey_transports" sql:"passkey_transports"`
+	BackupEligible  bool       `json:"passkey_backup_eligible" sql:"passkey_backup_eligible"`
+	BackupState     bool       `json:"passkey_backup_state" sql:"passkey_backup_state"`
+	Name            string     `json:"passkey_name" sql:"passkey_name"`
+	CreateDate      time.Time  `json:"passkey_createdat" sql:"passkey_createdat"`
+	LastUsedAt      *time.Time `json:"passkey_lastusedat" sql:"passkey_lastusedat"`
+}
+
+// NewPasskey returns the passkey of the given user for a credential that finished the registration ceremony.
+func NewPasskey(userID int, credential *webauthn.Credential, name string) *Passkey {
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+	return &Passkey{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+	}
+}
+
+// Credential returns the passkey as a credential the WebAuthn ceremonies can verify assertions with.
+func (passkey *Passkey) Credential() webauthn.Credential {
+
+	transports := make([]protocol.AuthenticatorTransport, len(passkey.Transports))
+	for i, transport := range passkey.Transports {
+		transports[i] = protocol.AuthenticatorTransport(transport)
+	}
+	return webauthn.Credential{
+		ID:              passkey.CredentialID,
+		PublicKey:       passkey.PublicKey,
+		AttestationType: passkey.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: passkey.BackupEligible,
+			BackupState:    passkey.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    passkey.AAGUID,
+			SignCount: passkey.SignCount,
+		},
+	}
+}
+
+// PasskeyChallenge is returned when a registration or login ceremony starts. The options are passed to
+// navigator.credentials.create or navigator.credentials.get and the challenge is sent back with the result.
+type PasskeyChallenge struct {
+	Challenge string      `json:"passkey_challenge"`
+	Options   interface{} `json:"passkey_options"`
+}
+
+// PasskeyUser is a user together with the passkeys of the user as the WebAuthn ceremonies need it.
+type PasskeyUser struct {
+	User     *User
+	Passkeys []Passkey
+}
+
+// WebAuthnID returns the user handle, passkeys are discoverable so the authenticator returns it on login.
+func (user *PasskeyUser) WebAuthnID() []byte {
+	return PasskeyUserHandle(user.User.ID)
+}
+
+func (user *PasskeyUser) WebAuthnName() string {
+	return user.User.Email
+}
+
+func (user *PasskeyUser) WebAuthnDisplayName() string {
+	return user.User.Email
+}
+
+func (user *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+
+	credentials := make([]webauthn.Credential, len(user.Passkeys))
+	for i := range user.Passkeys {
+		credentials[i] = user.Passkeys[i].Credential()
+	}
+	return credentials
+}
+
+// Passkey returns the passkey with the given credential ID or nil if the user has no such passkey.
+func (user *PasskeyUser) Passkey(credentialID []byte) *Passkey {
+
+	for i := range user.Passkeys {
+		if bytes.Equal(user.Passkeys[i].CredentialID, credentialID) {
+			return &user.Passkeys[i]
+		}
+	}
+	return nil
+}
+
+// PasskeyUserHandle returns the user handle of the user with the given ID. The handle is stored
+// on the authenticator, so it is the plain user ID and doesn't contain the email of the user.
+func PasskeyUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+// PasskeyUserID returns the ID of the user the user handle belongs to.
+func PasskeyUserID(userHandle []byte) (int, error) {
+
+	userID, err := strconv.Atoi(string(userHandle))
+	if err != nil || userID <= 0 {
+		return 0, errors.New("invalid passkey user handle")
+	}
+	return userID, nil
+}
+
+// PasskeyService is the WebAuthn relying party that registers passkeys and verifies passkey logins.
+type PasskeyService struct {
+	RelyingParty *webauthn.WebAuthn
+	// ChallengeLifetime is how long users have to finish a registration or login after it was started.
+	ChallengeLifetime time.Duration
+}
+
+// NewPasskeyService returns the passkey service for the relying party with the given ID, name and origins.
+func NewPasskeyService(rpID string, rpName string, origins []string, challengeLifetime time.Duration) (*PasskeyService, error) {
+
+	if strings.TrimSpace(rpID) == "" {
+		return nil, errors.New("the relying party ID is required for passkeys")
+	}
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		// passkeys replace the password, so the authenticator needs to verify the user with a PIN or biometrics
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyService{RelyingParty: relyingParty, ChallengeLifetime: challengeLifetime}, nil
+}
@@ -0,0 +1,237 @@
+package token
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The rules of a password policy, they are returned to clients so they can tell which rule a password violates.
+const (
+	PasswordRuleMinLength        = "min-length"
+	PasswordRuleMaxLength        = "max-length"
+	PasswordRuleRequireLowercase = "require-lowercase"
+	PasswordRuleRequireUppercase = "require-uppercase"
+	PasswordRuleRequireDigit     = "require-digit"
+	PasswordRuleRequireSymbol    = "require-symbol"
+	PasswordRuleMinStrength      = "min-strength"
+	PasswordRuleBreached         = "breached"
+)
+
+// breachedPrefixLength is the length of the SHA-1 prefixes the breached passwords are grouped by.
+const breachedPrefixLength = 5
+
+// PasswordPolicy describes the passwords users are allowed to choose.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+	// MaxLength is the maximum number of bytes, 0 doesn't limit the length.
+	MaxLength        int
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// MinStrength is the minimum strength score from 0 (too guessable) to 4 (very unguessable), see PasswordStrength.
+	MinStrength int
+	// BreachedPasswords is the directory with the breached password range files, an empty path disables the check.
+	BreachedPasswords string
+}
+
+// PasswordPolicyError lists the rules of the policy a password violates.
+type PasswordPolicyError struct {
+	Rules []string
+}
+
+func (err *PasswordPolicyError) Error() string {
+	return "password violates the password policy: " + strings.Join(err.Rules, ", ")
+}
+
+// NewPasswordPolicy returns a password policy and checks that the breached passwords directory exists.
+func NewPasswordPolicy(minLength int, maxLength int, requireLowercase bool, requireUppercase bool, requireDigit bool, requireSymbol bool, minStrength int, breachedPasswords string) (*PasswordPolicy, error) {
+
+	if minLength < 1 || (maxLength > 0 && maxLength < minLength) {
+		return nil, errors.New("invalid password length limits")
+	}
+	if minStrength < 0 || minStrength > 4 {
+		return nil, errors.New("the minimum password strength needs to be between 0 and 4")
+	}
+	if breachedPasswords != "" {
+		info, err := os.Stat(breachedPasswords)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, errors.New("the breached passwords path needs to be a directory")
+		}
+	}
+	return &PasswordPolicy{
+		MinLength:         minLength,
+		MaxLength:         maxLength,
+		RequireLowercase:  requireLowercase,
+		RequireUppercase:  requireUppercase,
+		RequireDigit:      requireDigit,
+		RequireSymbol:     requireSymbol,
+		MinStrength:       minStrength,
+		BreachedPasswords: breachedPasswords,
+	}, nil
+}
+
+// Check returns a *PasswordPolicyError if the password violates the policy. The user inputs, like the email
+// of the user, make the password weaker if the password contains them. Other errors are returned if the
+// breached passwords couldn't be read.
+func (policy *PasswordPolicy) Check(password string, userInputs ...string) error {
+
+	violations := []string{}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violations = append(violations, PasswordRuleMinLength)
+	}
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		violations = append(violations, PasswordRuleMaxLength)
+	}
+
+	lower, upper, digit, symbol := characterClasses(password)
+	if policy.RequireLowercase && !lower {
+		violations = append(violations, PasswordRuleRequireLowercase)
+	}
+	if policy.RequireUppercase && !upper {
+		violations = append(violations, PasswordRuleRequireUppercase)
+	}
+	if policy.RequireDigit && !digit {
+		violations = append(violations, PasswordRuleRequireDigit)
+	}
+	if policy.RequireSymbol && !symbol {
+		violations = append(violations, PasswordRuleRequireSymbol)
+	}
+	if PasswordStrength(password, userInputs...) < policy.MinStrength {
+		violations = append(violations, PasswordRuleMinStrength)
+	}
+
+	breached, err := policy.isBreached(password)
+	if err != nil {
+		return err
+	}
+	if breached {
+		violations = append(violations, PasswordRuleBreached)
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Rules: violations}
+	}
+	return nil
+}
+
+// isBreached looks up the password in the breached passwords. The passwords are stored like the k-anonymity
+// range API of Have I Been Pwned returns them, one file per SHA-1 prefix named '<PREFIX>.txt' containing
+// lines of the form '<SUFFIX>:<COUNT>'. So only the file of the password's prefix needs to be read.
+func (policy *PasswordPolicy) isBreached(password string) (bool, error) {
+
+	if policy.BreachedPasswords == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	file, err := os.Open(filepath.Join(policy.BreachedPasswords, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// PasswordStrength estimates how hard the password is to guess and returns a score from 0 to 4 like zxcvbn does,
+// based on the estimated number of guesses. Repeated and sequential characters and the given user inputs are
+// only counted as a few guesses, as attackers try them first. Dictionary words aren't detected, common passwords
+// are rejected by the breached passwords check instead.
+func PasswordStrength(password string, userInputs ...string) int {
+
+	lowered := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if len(input) >= 3 && strings.Contains(lowered, input) {
+			lowered = strings.ReplaceAll(lowered, input, "\x00")
+		}
+	}
+
+	cardinality := 0.0
+	lower, upper, digit, symbol := characterClasses(password)
+	if lower {
+		cardinality += 26
+	}
+	if upper {
+		cardinality += 26
+	}
+	if digit {
+		cardinality += 10
+	}
+	if symbol {
+		cardinality += 33
+	}
+
+	// the guesses are summed up as log10 to not overflow for long passwords
+	guesses := 0.0
+	var previous rune = -1
+	for _, char := range lowered {
+		switch {
+		case char == 0:
+			// a user input, attackers try them with a small dictionary
+			guesses += 1
+		case previous >= 0 && (char == previous || char == previous+1 || char == previous-1):
+			guesses += math.Log10(2)
+		default:
+			guesses += math.Log10(cardinality)
+		}
+		previous = char
+	}
+
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// characterClasses returns which classes of characters the password contains.
+func characterClasses(password string) (lower bool, upper bool, digit bool, symbol bool) {
+	for _, char := range password {
+		switch {
+		case unicode.IsLower(char):
+			lower = true
+		case unicode.IsUpper(char):
+			upper = true
+		case unicode.IsDigit(char):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	return
+}
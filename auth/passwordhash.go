@@ -0,0 +1,191 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The algorithms passwords can be hashed with.
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	// bcryptMaxPasswordLength is the number of bytes bcrypt can hash, longer passwords are rejected instead of truncated.
+	bcryptMaxPasswordLength = 72
+)
+
+// ErrUnknownPasswordHash is returned for stored password hashes of unsupported algorithms or malformed hashes.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with the configured algorithm and verifies passwords against hashes of
+// all supported algorithms, the algorithm is detected from the stored hash. Argon2id hashes are stored
+// in the PHC string format '$argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<hash>'.
+type PasswordHasher struct {
+	Algorithm string
+	// Argon2Memory is the memory argon2id uses in KiB.
+	Argon2Memory      uint32
+	Argon2Time        uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// NewPasswordHasher returns a password hasher for the given algorithm and parameters.
+func NewPasswordHasher(algorithm string, argon2Memory int, argon2Time int, argon2Parallelism int, bcryptCost int) (*PasswordHasher, error) {
+
+	switch algorithm {
+	case PasswordHashArgon2id:
+		if argon2Memory < 8*argon2Parallelism || argon2Time < 1 || argon2Parallelism < 1 || argon2Parallelism > 255 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+	case PasswordHashBcrypt:
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, errors.New("invalid bcrypt cost")
+		}
+	default:
+		return nil, errors.New("unsupported password hash algorithm '" + algorithm + "'")
+	}
+	return &PasswordHasher{
+		Algorithm:         algorithm,
+		Argon2Memory:      uint32(argon2Memory),
+		Argon2Time:        uint32(argon2Time),
+		Argon2Parallelism: uint8(argon2Parallelism),
+		BcryptCost:        bcryptCost,
+	}, nil
+}
+
+// MaxPasswordLength returns the maximum number of bytes of a password the configured algorithm can hash, 0 means unlimited.
+func (hasher *PasswordHasher) MaxPasswordLength() int {
+	if hasher.Algorithm == PasswordHashBcrypt {
+		return bcryptMaxPasswordLength
+	}
+	return 0
+}
+
+// Hash hashes the password with the configured algorithm and parameters.
+func (hasher *PasswordHasher) Hash(password string) (string, error) {
+
+	if hasher.Algorithm == PasswordHashBcrypt {
+		if len(password) > bcryptMaxPasswordLength {
+			return "", bcrypt.ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.Argon2Time, hasher.Argon2Memory, hasher.Argon2Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, hasher.Argon2Memory, hasher.Argon2Time, hasher.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify returns true if the password matches the hash, the hash can be of any supported algorithm.
+func (hasher *PasswordHasher) Verify(password string, hash string) (bool, error) {
+
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+// VerifyDummy verifies the password against a hash made with the configured algorithm that no password matches.
+// It is used for unknown users, so the response time doesn't tell whether a user exists.
+func (hasher *PasswordHasher) VerifyDummy(password string) {
+
+	hasher.dummyOnce.Do(func() {
+		secret, err := NewOpaqueToken()
+		if err == nil {
+			hasher.dummyHash, _ = hasher.Hash(secret)
+		}
+	})
+	if hasher.dummyHash == "" {
+		return
+	}
+	_, _ = hasher.Verify(password, hasher.dummyHash)
+}
+
+// NeedsRehash returns true if the hash was not made with the configured algorithm and parameters,
+// so the password should be hashed again the next time the user provides it.
+func (hasher *PasswordHasher) NeedsRehash(hash string) bool {
+
+	if isBcryptHash(hash) {
+		if hasher.Algorithm != PasswordHashBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != hasher.BcryptCost
+	}
+
+	params, _, key, err := parseArgon2idHash(hash)
+	if err != nil || hasher.Algorithm != PasswordHashArgon2id {
+		return true
+	}
+	return params.Argon2Memory != hasher.Argon2Memory || params.Argon2Time != hasher.Argon2Time ||
+		params.Argon2Parallelism != hasher.Argon2Parallelism || len(key) != argon2KeyLength
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// parseArgon2idHash returns the parameters, the salt and the key of an argon2id hash in the PHC string format.
+func parseArgon2idHash(hash string) (*PasswordHasher, []byte, []byte, error) {
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != PasswordHashArgon2id {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	params := &PasswordHasher{Algorithm: PasswordHashArgon2id}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Parallelism)
+	if err != nil || params.Argon2Time < 1 || params.Argon2Parallelism < 1 {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+	return params, salt, key, nil
+}
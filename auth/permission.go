@@ -0,0 +1,77 @@
+package token
+
+import (
+	"slices"
+	"time"
+)
+
+// The permissions users get through their role, the permissions of a role are stored in the database
+// and embedded into the JWTs of its users.
+const (
+	// PermissionServerRead lets users read the version, info, health and metrics of the server.
+	PermissionServerRead = "server:read"
+	// PermissionServerUpdate lets users update the server.
+	PermissionServerUpdate = "server:update"
+	// PermissionLogsRead lets users read the info and trace logs.
+	PermissionLogsRead = "logs:read"
+	// PermissionUsersRead lets users read all users and their login attempts, passkeys, linked identities and OAuth consents.
+	PermissionUsersRead = "users:read"
+	// PermissionUsersWrite lets users suspend, unlock and verify other users and remove their sessions and credentials.
+	PermissionUsersWrite = "users:write"
+	// PermissionUsersEntities lets users associate festivals, artists and other entities with users.
+	PermissionUsersEntities = ServiceScopeUserEntities
+	// PermissionEntitiesWrite lets users change every festival, artist and other entity, not only the ones they own.
+	PermissionEntitiesWrite = "entities:write"
+	// PermissionKeysRead lets users read API keys, service keys, OAuth clients, signing keys and the revocation list.
+	PermissionKeysRead = ServiceScopeKeysRead
+	// PermissionKeysWrite lets users create, change and delete API keys, service keys, OAuth clients and signing keys.
+	PermissionKeysWrite = "keys:write"
+	// PermissionRolesRead lets users read the roles and their permissions.
+	PermissionRolesRead = "roles:read"
+	// PermissionRolesWrite lets users change the permissions of roles and assign roles to users.
+	PermissionRolesWrite = "roles:write"
+)
+
+// Permission is an entry of the permission catalogue.
+type Permission struct {
+	Name        string `json:"permission_name"`
+	Description string `json:"permission_description"`
+}
+
+// Permissions is the catalogue of all permissions roles can have.
+var Permissions = []Permission{
+	{PermissionServerRead, "Read the version, info, health and metrics of the server."},
+	{PermissionServerUpdate, "Update the server."},
+	{PermissionLogsRead, "Read the info and trace logs."},
+	{PermissionUsersRead, "Read all users and their login attempts, passkeys, linked identities and OAuth consents."},
+	{PermissionUsersWrite, "Suspend, unlock and verify other users and remove their sessions and credentials."},
+	{PermissionUsersEntities, "Associate festivals, artists and other entities with users."},
+	{PermissionEntitiesWrite, "Change every festival, artist and other entity, not only the owned ones."},
+	{PermissionKeysRead, "Read API keys, service keys, OAuth clients, signing keys and the revocation list."},
+	{PermissionKeysWrite, "Create, change and delete API keys, service keys, OAuth clients and signing keys."},
+	{PermissionRolesRead, "Read the roles and their permissions."},
+	{PermissionRolesWrite, "Change the permissions of roles and assign roles to users."},
+}
+
+// ValidPermissions returns true if all given permissions are in the permission catalogue.
+func ValidPermissions(permissions []string) bool {
+	for _, permission := range permissions {
+		if !slices.ContainsFunc(Permissions, func(p Permission) bool { return p.Name == permission }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Role is a user role together with the permissions it grants.
+type Role struct {
+	ID          int       `json:"role_id" sql:"role_id"`
+	Name        string    `json:"role_name" sql:"role_name"`
+	UpdateDate  time.Time `json:"role_updatedat" sql:"role_updatedat"`
+	Permissions []string  `json:"role_permissions" sql:"-"`
+}
+
+// HasPermission returns true if the user was granted the given permission by their role.
+func (claims *UserClaims) HasPermission(permission string) bool {
+	return slices.Contains(claims.UserPermissions, permission)
+}
@@ -0,0 +1,58 @@
+package token
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimitWindow is the window the per key rate limits of API keys apply to.
+const rateLimitWindow = time.Minute
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter counts the requests per key in fixed windows. The counts are kept in memory,
+// so every instance of a service enforces the limit on its own.
+type rateLimiter struct {
+	lock      sync.Mutex
+	windows   map[int]*rateWindow
+	lastSweep time.Time
+}
+
+// Allow counts a request of the key with the given ID and returns false and the time until the
+// next window starts if the key exceeded the given limit. A limit of 0 does not limit the key.
+func (limiter *rateLimiter) Allow(keyID int, limit int) (bool, time.Duration) {
+
+	if limit <= 0 {
+		return true, 0
+	}
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	now := time.Now()
+	if limiter.windows == nil {
+		limiter.windows = map[int]*rateWindow{}
+	}
+	if now.Sub(limiter.lastSweep) > rateLimitWindow {
+		for id, window := range limiter.windows {
+			if now.Sub(window.start) > rateLimitWindow {
+				delete(limiter.windows, id)
+			}
+		}
+		limiter.lastSweep = now
+	}
+
+	window, ok := limiter.windows[keyID]
+	if !ok || now.Sub(window.start) > rateLimitWindow {
+		window = &rateWindow{start: now}
+		limiter.windows[keyID] = window
+	}
+	if window.count >= limit {
+		return false, window.start.Add(rateLimitWindow).Sub(now)
+	}
+	window.count++
+	return true, 0
+}
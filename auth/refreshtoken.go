@@ -0,0 +1,52 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// RefreshToken is the database representation of an opaque refresh token.
+// Only the SHA-256 hash of the token is stored, the token itself is only known to the client.
+// All tokens that were rotated from the same login share a family. Tokens issued to an OAuth client
+// can only be exchanged by that client and keep the scopes the client was granted.
+type RefreshToken struct {
+	ID         int        `json:"refresh_token_id" sql:"refresh_token_id"`
+	Hash       string     `json:"-" sql:"refresh_token_hash"`
+	Family     string     `json:"refresh_token_family" sql:"refresh_token_family"`
+	UserID     int        `json:"refresh_token_user" sql:"refresh_token_user"`
+	Device     string     `json:"refresh_token_device" sql:"refresh_token_device"`
+	CreateDate time.Time  `json:"refresh_token_createdat" sql:"refresh_token_createdat"`
+	ExpiresAt  time.Time  `json:"refresh_token_expiresat" sql:"refresh_token_expiresat"`
+	UsedAt     *time.Time `json:"refresh_token_usedat" sql:"refresh_token_usedat"`
+	Revoked    bool       `json:"refresh_token_revoked" sql:"refresh_token_revoked"`
+	ClientID   *int       `json:"refresh_token_client" sql:"refresh_token_client"`
+	Scopes     []string   `json:"refresh_token_scopes" sql:"refresh_token_scopes"`
+	Expired    bool       `json:"-" sql:"-"`
+}
+
+// TokenPair is returned to clients exchanging a refresh token.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// NewOpaqueToken returns a random, URL safe token with 256 bits of entropy.
+func NewOpaqueToken() (string, error) {
+	buffer := make([]byte, 32)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// HashOpaqueToken returns the hex encoded SHA-256 hash of the given token as it is stored in the database.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
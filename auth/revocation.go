@@ -0,0 +1,33 @@
+package token
+
+// RevocationList contains all access tokens that were revoked before they expired.
+// Tokens maps the ID (jti) of a revoked token to its expiration date and Sessions maps a user ID
+// to a point in time before which all tokens issued to that user are revoked, both as unix timestamps.
+type RevocationList struct {
+	Tokens   map[string]int64 `json:"tokens"`
+	Sessions map[string]int64 `json:"sessions"`
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{Tokens: map[string]int64{}, Sessions: map[string]int64{}}
+}
+
+// IsRevoked returns true if the token with the given claims was revoked.
+func (list *RevocationList) IsRevoked(claims *UserClaims) bool {
+
+	if list == nil {
+		return false
+	}
+	if claims.ID != "" {
+		if _, revoked := list.Tokens[claims.ID]; revoked {
+			return true
+		}
+	}
+	if before, ok := list.Sessions[claims.UserID]; ok {
+		// tokens issued without an issue date are older than any revocation
+		if claims.IssuedAt == nil || claims.IssuedAt.Unix() < before {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package token
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// ServiceIdentity is a service that authenticates with its mTLS client certificate instead of a service key.
+// The certificate is mapped to the service by one of its subjects, the service gets the given scopes.
+type ServiceIdentity struct {
+	Name string
+	// Subjects are matched against the URI and DNS subject alternative names and the common name of the certificate.
+	Subjects []string
+	Scopes   []string
+}
+
+// HasScope returns true if the service was granted the given scope.
+func (identity *ServiceIdentity) HasScope(scope string) bool {
+	return slices.Contains(identity.Scopes, scope)
+}
+
+// ServiceIdentities maps verified client certificates to the configured service identities.
+type ServiceIdentities struct {
+	bySubject map[string]*ServiceIdentity
+}
+
+// NewServiceIdentities returns the mapping for the given service identities, every subject may only belong to one service.
+func NewServiceIdentities(identities []ServiceIdentity) (*ServiceIdentities, error) {
+
+	bySubject := map[string]*ServiceIdentity{}
+	for i := range identities {
+		identity := &identities[i]
+		if identity.Name == "" || len(identity.Subjects) == 0 {
+			return nil, errors.New("service identities need a name and at least one subject")
+		}
+		if !ValidServiceKeyScopes(identity.Scopes) {
+			return nil, errors.New("service identity '" + identity.Name + "' has an unknown scope")
+		}
+		for _, subject := range identity.Subjects {
+			if subject == "" {
+				return nil, errors.New("service identity '" + identity.Name + "' has an empty subject")
+			}
+			if _, exists := bySubject[subject]; exists {
+				return nil, errors.New("the subject '" + subject + "' belongs to more than one service identity")
+			}
+			bySubject[subject] = identity
+		}
+	}
+	return &ServiceIdentities{bySubject: bySubject}, nil
+}
+
+// Identify returns the service identity of the given client certificate or nil if the certificate
+// doesn't belong to a configured service.
+func (identities *ServiceIdentities) Identify(cert *x509.Certificate) *ServiceIdentity {
+
+	if identities == nil || cert == nil {
+		return nil
+	}
+	for _, subject := range certificateSubjects(cert) {
+		if identity, ok := identities.bySubject[subject]; ok {
+			return identity
+		}
+	}
+	return nil
+}
+
+// GetPeerCertificate returns the client certificate of the request if it was verified against the
+// client CA, requests of peers without a verified certificate return nil.
+func GetPeerCertificate(r *http.Request) *x509.Certificate {
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// GetPeerName returns the name of the verified client certificate of the request for logs and audit records,
+// it is the first subject of the certificate or empty if there is no verified certificate.
+func GetPeerName(r *http.Request) string {
+
+	subjects := certificateSubjects(GetPeerCertificate(r))
+	if len(subjects) == 0 {
+		return ""
+	}
+	return subjects[0]
+}
+
+// certificateSubjects returns the URI and DNS subject alternative names and the common name of the certificate.
+func certificateSubjects(cert *x509.Certificate) []string {
+
+	if cert == nil {
+		return nil
+	}
+	subjects := []string{}
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	subjects = append(subjects, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+	return subjects
+}
@@ -1,7 +1,141 @@
 package token
 
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ServiceKeyPrefix is the start of the visible prefix of all generated service keys.
+const ServiceKeyPrefix = "fsvc"
+
+// The scopes of service keys, services get service tokens with these scopes.
+const (
+	// ServiceScopeKeysRead lets the service load the API keys, service keys, validation keys and the revocation list.
+	ServiceScopeKeysRead = "keys:read"
+	// ServiceScopeUserEntities lets the service associate festivals, artists and other entities with users.
+	ServiceScopeUserEntities = "users:entities"
+)
+
+// ServiceKeyScopes lists all scopes a service key can have, new service keys have all scopes by default.
+var ServiceKeyScopes = []string{ServiceScopeKeysRead, ServiceScopeUserEntities}
+
+// ServiceKey is a service key as it is stored in the database, only the hash of the key is stored.
+// The key itself is only set when the service key is created.
 type ServiceKey struct {
-	ID      int    `json:"service_key_id" sql:"service_key_id"`
-	Key     string `json:"service_key" sql:"service_key"`
-	Comment string `json:"service_key_comment" sql:"service_key_comment"`
+	ID         int        `json:"service_key_id" sql:"service_key_id"`
+	Prefix     string     `json:"service_key_prefix" sql:"service_key_prefix"`
+	Hash       string     `json:"service_key_hash" sql:"service_key_hash"`
+	Comment    string     `json:"service_key_comment" sql:"service_key_comment"`
+	Enabled    bool       `json:"service_key_enabled" sql:"service_key_enabled"`
+	CreateDate time.Time  `json:"service_key_createdat" sql:"service_key_createdat"`
+	CreatedBy  *int       `json:"service_key_createdby" sql:"service_key_createdby"`
+	LastUsedAt *time.Time `json:"service_key_lastusedat" sql:"service_key_lastusedat"`
+	ExpiresAt  *time.Time `json:"service_key_expiresat" sql:"service_key_expiresat"`
+	Scopes     []string   `json:"service_key_scopes" sql:"service_key_scopes"`
+	Expired    bool       `json:"service_key_expired" sql:"-"`
+	Key        string     `json:"service_key,omitempty" sql:"-"`
+}
+
+// NewServiceKey generates a new service key with the given comment.
+func NewServiceKey(comment string) (*ServiceKey, error) {
+
+	prefix, key, err := newKey(ServiceKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceKey{Prefix: prefix, Hash: HashKey(key), Comment: comment, Enabled: true, Scopes: slices.Clone(ServiceKeyScopes), Key: key}, nil
 }
+
+// IsUsable returns true if the service key is enabled and not expired. The expiry date is checked when the key is used,
+// so loaded keys stop working when they expire and not when they are loaded again.
+func (key *ServiceKey) IsUsable() bool {
+	return key.Enabled && !key.Expired && (key.ExpiresAt == nil || time.Now().Before(*key.ExpiresAt))
+}
+
+// HasScope returns true if the service key was granted the given scope, every key has the empty scope.
+func (key *ServiceKey) HasScope(scope string) bool {
+	return scope == "" || slices.Contains(key.Scopes, scope)
+}
+
+// ValidServiceKeyScopes returns true if all given scopes are known.
+func ValidServiceKeyScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(ServiceKeyScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// GrantedScopes returns the requested space separated scopes if the service key has all of them,
+// services that request no scope get all scopes of the service key.
+func (key *ServiceKey) GrantedScopes(scope string) ([]string, bool) {
+
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return slices.Clone(key.Scopes), true
+	}
+	granted := []string{}
+	for _, s := range requested {
+		if !slices.Contains(key.Scopes, s) {
+			return nil, false
+		}
+		if !slices.Contains(granted, s) {
+			granted = append(granted, s)
+		}
+	}
+	return granted, true
+}
+
+// ServiceTokenSubjectPrefix starts the subject of service tokens, it is followed by the prefix of the service key.
+const ServiceTokenSubjectPrefix = "svc:"
+
+// ServiceClaims are the claims of the short-lived service tokens services get for their service key with the
+// client credentials grant. They have no user, so they are never accepted as the JWT of a user.
+type ServiceClaims struct {
+	Scope string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// NewServiceClaims returns the claims of a service token for the service key with the given scopes.
+func NewServiceClaims(issuer string, key *ServiceKey, scopes []string, lifetime time.Duration) *ServiceClaims {
+
+	now := time.Now()
+	return &ServiceClaims{
+		Scope: strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    issuer,
+			Subject:   ServiceTokenSubjectPrefix + key.Prefix,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		},
+	}
+}
+
+// Service returns the prefix of the service key the token was issued for.
+func (claims *ServiceClaims) Service() string {
+	return strings.TrimPrefix(claims.Subject, ServiceTokenSubjectPrefix)
+}
+
+// HasScope returns true if the service token was issued with the given scope.
+func (claims *ServiceClaims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(claims.Scope), scope)
+}
+
+// isServiceToken returns true if the unverified subject of the JWT is the one of a service token, so
+// it is validated as a service token instead of the JWT of a user.
+func isServiceToken(tokenString string) bool {
+
+	var claims jwt.RegisteredClaims
+	_, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims)
+	return err == nil && strings.HasPrefix(claims.Subject, ServiceTokenSubjectPrefix)
+}
+
+// errNoServiceToken is returned for JWTs that are valid but were not issued as service tokens.
+var errNoServiceToken = errors.New("invalid token: token is not a service token")
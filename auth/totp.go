@@ -0,0 +1,172 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The parameters of the time-based one-time passwords as defined in RFC 6238. They are the defaults
+// of authenticator apps, some apps ignore other values in the otpauth URI.
+const (
+	totpSecretLength = 20
+	totpDigits       = 6
+	totpPeriod       = 30
+	// totpSkew is the number of periods before and after the current one that codes are accepted for,
+	// to allow for clock drift between the server and the device of the user.
+	totpSkew = 1
+)
+
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// TOTP is the time-based one-time password enrollment of a user. The enrollment is only used
+// for logins after the user confirmed it with a code from the authenticator app.
+type TOTP struct {
+	UserID      int        `json:"totp_user" sql:"totp_user"`
+	Secret      string     `json:"-" sql:"totp_secret"`
+	LastStep    int64      `json:"-" sql:"totp_last_step"`
+	CreateDate  time.Time  `json:"totp_createdat" sql:"totp_createdat"`
+	ConfirmedAt *time.Time `json:"totp_confirmedat" sql:"totp_confirmedat"`
+}
+
+// IsConfirmed returns true if the user confirmed the enrollment.
+func (totp *TOTP) IsConfirmed() bool {
+	return totp.ConfirmedAt != nil
+}
+
+// TOTPEnrollment is returned to users enrolling into two-factor authentication, the URI is the
+// payload of the QR code authenticator apps scan.
+type TOTPEnrollment struct {
+	Secret string `json:"totp_secret"`
+	URI    string `json:"totp_uri"`
+}
+
+// MFAChallenge is returned by logins that need a second factor, the challenge is exchanged for the tokens
+// together with a code. If the user needs to enroll first the challenge is used for the enrollment too.
+type MFAChallenge struct {
+	Challenge          string `json:"mfa_challenge"`
+	EnrollmentRequired bool   `json:"mfa_enrollment_required"`
+}
+
+// MFAPolicy describes when users need to provide a second factor to login.
+type MFAPolicy struct {
+	// Issuer is the name authenticator apps show for the account.
+	Issuer string
+	// RequireForAdmin makes two-factor authentication mandatory for admins,
+	// admins that are not enrolled yet need to enroll during the login.
+	RequireForAdmin bool
+	// ChallengeLifetime is how long users have to provide the second factor after the password was verified.
+	ChallengeLifetime time.Duration
+}
+
+// Required returns true if the user needs to provide a second factor to login.
+func (policy *MFAPolicy) Required(user *User, enrolled bool) bool {
+	return enrolled || (policy.RequireForAdmin && user.Role == ADMIN)
+}
+
+// NewTOTPEnrollment returns a new random secret and the otpauth URI for the given account.
+func NewTOTPEnrollment(issuer string, account string) (*TOTPEnrollment, error) {
+
+	buffer := make([]byte, totpSecretLength)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buffer)
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    "otpauth://totp/" + label + "?" + strings.ReplaceAll(query.Encode(), "+", "%20"),
+	}, nil
+}
+
+// IsTOTPCode returns true if the code looks like a one-time password and not like a recovery code.
+func IsTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, char := range code {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateTOTP returns the time step the code is valid for at the given time. Callers need to make sure
+// that codes of a time step are only accepted once.
+func ValidateTOTP(secret string, code string, now time.Time) (int64, error) {
+
+	if !IsTOTPCode(code) {
+		return 0, errors.New("invalid one-time password format")
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, err
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, errors.New("invalid one-time password")
+}
+
+// totpCode returns the code for the given time step as defined in RFC 4226.
+func totpCode(key []byte, step int64) string {
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// NewRecoveryCodes returns new single-use recovery codes, users can login with them if they lost their authenticator app.
+func NewRecoveryCodes() ([]string, error) {
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buffer := make([]byte, recoveryCodeLength*5/8)
+		_, err := rand.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(buffer))
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash of the recovery code as it is stored in the database,
+// the code is normalized first so users can enter it without the dash and in any case.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashOpaqueToken(normalized)
+}
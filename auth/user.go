@@ -1,11 +1,15 @@
 package token
 
 import (
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// The IDs of the default roles, the permissions of the roles are stored in the database.
 const (
 	ADMIN       int = 42
 	CREATOR     int = 1
@@ -13,20 +17,32 @@ const (
 )
 
 type User struct {
-	ID           int       `json:"user_id" sql:"user_id"`
-	Email        string    `json:"user_email" sql:"user_email"`
-	PasswordHash string    `json:"user_password" sql:"user_password"`
-	CreateDate   time.Time `json:"user_createdat" sql:"user_createdat"`
-	UpdateDate   time.Time `json:"user_updatedat" sql:"user_updatedat"`
-	Role         int       `json:"user_role" sql:"user_role"`
+	ID              int        `json:"user_id" sql:"user_id"`
+	Email           string     `json:"user_email" sql:"user_email"`
+	PasswordHash    string     `json:"user_password" sql:"user_password"`
+	CreateDate      time.Time  `json:"user_createdat" sql:"user_createdat"`
+	UpdateDate      time.Time  `json:"user_updatedat" sql:"user_updatedat"`
+	Role            int        `json:"user_role" sql:"user_role"`
+	Suspended       bool       `json:"user_suspended" sql:"user_suspended"`
+	SuspendedReason string     `json:"user_suspended_reason" sql:"user_suspended_reason"`
+	SuspendedBy     *int       `json:"user_suspended_by" sql:"user_suspended_by"`
+	SuspendedAt     *time.Time `json:"user_suspended_at" sql:"user_suspended_at"`
+	Verified        bool       `json:"user_verified" sql:"user_verified"`
+	VerifiedAt      *time.Time `json:"user_verified_at" sql:"user_verified_at"`
 }
 
 type UserSummary struct {
-	ID         int       `json:"user_id" sql:"user_id"`
-	Email      string    `json:"user_email" sql:"user_email"`
-	CreateDate time.Time `json:"user_createdat" sql:"user_createdat"`
-	UpdateDate time.Time `json:"user_updatedat" sql:"user_updatedat"`
-	Role       int       `json:"user_role" sql:"user_role"`
+	ID              int        `json:"user_id" sql:"user_id"`
+	Email           string     `json:"user_email" sql:"user_email"`
+	CreateDate      time.Time  `json:"user_createdat" sql:"user_createdat"`
+	UpdateDate      time.Time  `json:"user_updatedat" sql:"user_updatedat"`
+	Role            int        `json:"user_role" sql:"user_role"`
+	Suspended       bool       `json:"user_suspended" sql:"user_suspended"`
+	SuspendedReason string     `json:"user_suspended_reason" sql:"user_suspended_reason"`
+	SuspendedBy     *int       `json:"user_suspended_by" sql:"user_suspended_by"`
+	SuspendedAt     *time.Time `json:"user_suspended_at" sql:"user_suspended_at"`
+	Verified        bool       `json:"user_verified" sql:"user_verified"`
+	VerifiedAt      *time.Time `json:"user_verified_at" sql:"user_verified_at"`
 }
 
 type UserClaims struct {
@@ -40,5 +56,40 @@ type UserClaims struct {
 	UserPlaces    []int
 	UserImages    []int
 	UserTags      []int
+	// UserPermissions are the permissions the role of the user had when the token was issued.
+	UserPermissions []string `json:"UserPermissions,omitempty"`
+	// Scope is the space separated scope granted to the OAuth client the token was issued to, it is empty for logins.
+	Scope string `json:"scope,omitempty"`
+	// ClientID is the client ID of the OAuth client the token was issued to, it is empty for logins.
+	ClientID string `json:"client_id,omitempty"`
 	jwt.RegisteredClaims
 }
+
+// NewClientClaims returns the claims of an access token of the user for the OAuth client. The token is restricted
+// to the audience of the client and only carries the granted scopes, not the role, entities or permissions of the user.
+func NewClientClaims(issuer string, userID string, client *OAuthClient, scopes []string, lifetime time.Duration) *UserClaims {
+
+	now := time.Now()
+	return &UserClaims{
+		UserID:   userID,
+		Scope:    strings.Join(scopes, " "),
+		ClientID: client.ClientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+			Issuer:    issuer,
+			Audience:  client.AccessTokenAudience(),
+		},
+	}
+}
+
+// IsClientToken returns true if the token was issued to an OAuth client and not for a login of the user.
+func (claims *UserClaims) IsClientToken() bool {
+	return claims.ClientID != "" || claims.Scope != "" || len(claims.Audience) > 0
+}
+
+// HasScope returns true if the token was issued to an OAuth client that was granted the given scope.
+func (claims *UserClaims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(claims.Scope), scope)
+}
@@ -1,7 +1,6 @@
 package token
 
 import (
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -10,6 +9,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -17,57 +20,334 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// DefaultRefreshInterval is the interval in which validation services reload keys and the revocation list from the identity service.
+const DefaultRefreshInterval = 30 * time.Second
+
+// DefaultStartupTimeout is the time validation services retry to load keys from the identity service on startup.
+const DefaultStartupTimeout = 2 * time.Minute
+
 type Validation interface {
 	ValidateAccessToken(token string) (string, error)
 }
 
 type ValidationService struct {
-	Key         *rsa.PublicKey
-	APIKeys     *[]string
-	ServiceKeys *[]string
-	Client      *http.Client
-	Endpoint    string
+	// Algorithm is the only signing algorithm that is accepted, if it is empty
+	// only the algorithm of the loaded validation key is accepted.
+	Algorithm       string
+	Key             *ValidationKey
+	Keys            *KeySet
+	Client          *http.Client
+	Endpoint        string
+	RefreshInterval time.Duration
+	StartupTimeout  time.Duration
+	// KeySource is consulted for API and service keys instead of the loaded keys if it is set.
+	KeySource KeySource
+	// Audience is the audience of the service, tokens issued to OAuth clients are only accepted if they were issued
+	// for it. Tokens of logins have no audience and are always accepted.
+	Audience string
+	// ServiceIdentities maps the verified client certificates of services to service identities,
+	// those services can access service endpoints without a service key.
+	ServiceIdentities *ServiceIdentities
+
+	serviceKey         string
+	loadingServiceKeys bool
+	apiKeys            atomic.Pointer[map[string]APIKey]
+	serviceKeys        atomic.Pointer[map[string]ServiceKey]
+	revocations        atomic.Pointer[RevocationList]
+	limiter            rateLimiter
+	etagLock           sync.Mutex
+	etags              map[string]string
+	done               chan struct{}
+	closeOnce          sync.Once
+}
+
+// ValidationOption configures a ValidationService created with NewValidationService.
+type ValidationOption func(validator *ValidationService)
+
+// WithRefreshInterval sets the interval in which keys and the revocation list are reloaded.
+func WithRefreshInterval(interval time.Duration) ValidationOption {
+	return func(validator *ValidationService) {
+		validator.RefreshInterval = interval
+	}
+}
+
+// WithStartupTimeout sets the time the initial load is retried before the validation service starts without keys.
+func WithStartupTimeout(timeout time.Duration) ValidationOption {
+	return func(validator *ValidationService) {
+		validator.StartupTimeout = timeout
+	}
+}
+
+// WithAlgorithm restricts the accepted signing algorithm.
+func WithAlgorithm(algorithm string) ValidationOption {
+	return func(validator *ValidationService) {
+		validator.Algorithm = algorithm
+	}
+}
+
+// WithAudience sets the audience of the service, so tokens of OAuth clients with that audience are accepted.
+func WithAudience(audience string) ValidationOption {
+	return func(validator *ValidationService) {
+		validator.Audience = audience
+	}
 }
 
-func NewValidationService(endpoint string, clientCert string, clientKey string, serverCA string, serviceKey string, loadingServiceKeys bool) *ValidationService {
+// WithServiceIdentities lets services authenticate with their verified mTLS client certificate.
+func WithServiceIdentities(identities *ServiceIdentities) ValidationOption {
+	return func(validator *ValidationService) {
+		validator.ServiceIdentities = identities
+	}
+}
+
+func NewValidationService(endpoint string, clientCert string, clientKey string, serverCA string, serviceKey string, loadingServiceKeys bool, options ...ValidationOption) *ValidationService {
 
 	client, err := validationClient(clientCert, clientKey, serverCA)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Unable to create validation client.")
 	}
 
-	keys, err := loadAPIKeys(endpoint, serviceKey, client)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load API keys from identity service.")
+	validator := &ValidationService{
+		Keys:               NewKeySet(),
+		Client:             client,
+		Endpoint:           endpoint,
+		RefreshInterval:    DefaultRefreshInterval,
+		StartupTimeout:     DefaultStartupTimeout,
+		serviceKey:         serviceKey,
+		loadingServiceKeys: loadingServiceKeys,
+		etags:              map[string]string{},
+		done:               make(chan struct{}),
+	}
+	for _, option := range options {
+		option(validator)
 	}
 
-	vaidationKey, err := loadValidationKey(endpoint, serviceKey, client)
+	validator.loadWithBackoff()
+	go validator.refreshRoutine()
+
+	return validator
+}
+
+// Close stops reloading keys and the revocation list.
+func (validator *ValidationService) Close() {
+	validator.closeOnce.Do(func() {
+		if validator.done != nil {
+			close(validator.done)
+		}
+	})
+}
+
+// IsValidAPIKey returns true if the given API key is known to the identity service.
+// Keys are looked up by their hash, so the lookup does not depend on the key itself.
+func (validator *ValidationService) IsValidAPIKey(key string) bool {
+	_, ok := validator.APIKey(key)
+	return ok
+}
+
+// APIKey returns the metadata of the given API key, like its scopes, if it is known to the identity service and did not expire.
+func (validator *ValidationService) APIKey(key string) (*APIKey, bool) {
+
+	keys := validator.apiKeys.Load()
+	if keys == nil || key == "" {
+		return nil, false
+	}
+	apiKey, ok := (*keys)[HashKey(key)]
+	if !ok || !apiKey.IsUsable() {
+		return nil, false
+	}
+	return &apiKey, true
+}
+
+// IsValidServiceKey returns true if the given service key is known to the identity service, did not expire and
+// has the given scope. Keys are looked up by their hash, so the lookup does not depend on the key itself.
+func (validator *ValidationService) IsValidServiceKey(key string, scope string) bool {
+	serviceKey, ok := validator.ServiceKey(key)
+	return ok && serviceKey.HasScope(scope)
+}
+
+// ServiceKey returns the metadata of the given service key, like its scopes, if it is known to the identity service and did not expire.
+func (validator *ValidationService) ServiceKey(key string) (*ServiceKey, bool) {
+
+	keys := validator.serviceKeys.Load()
+	if keys == nil || key == "" {
+		return nil, false
+	}
+	serviceKey, ok := (*keys)[HashKey(key)]
+	if !ok || !serviceKey.IsUsable() {
+		return nil, false
+	}
+	return &serviceKey, true
+}
+
+// SetRevocationList replaces the revocation list consulted by ValidateAccessToken.
+func (validator *ValidationService) SetRevocationList(list *RevocationList) {
+	validator.revocations.Store(list)
+}
+
+func (validator *ValidationService) isRevoked(claims *UserClaims) bool {
+	return validator.revocations.Load().IsRevoked(claims)
+}
+
+func (validator *ValidationService) loadWithBackoff() {
+
+	deadline := time.Now().Add(validator.StartupTimeout)
+	backoff := time.Second
+	for {
+		err := validator.Refresh()
+		if err == nil {
+			return
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			log.Error().Err(err).Msg("Failed to load keys from identity service, requests will be rejected until the keys are loaded.")
+			return
+		}
+		log.Warn().Err(err).Str("retry", backoff.String()).Msg("Failed to load keys from identity service.")
+		select {
+		case <-validator.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (validator *ValidationService) refreshRoutine() {
+
+	t := time.NewTicker(validator.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-validator.done:
+			return
+		case <-t.C:
+			err := validator.Refresh()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to refresh keys from identity service.")
+			}
+		}
+	}
+}
+
+// Refresh reloads all keys and the revocation list that changed since the last refresh.
+func (validator *ValidationService) Refresh() error {
+
+	var errs []error
+
+	body, etag, err := validator.fetch("/api-keys")
+	if err == nil && body != nil {
+		var keys []APIKey
+		keys, err = parseData[[]APIKey](body)
+		if err == nil {
+			values := map[string]APIKey{}
+			for _, key := range keys {
+				if key.IsUsable() {
+					values[key.Hash] = key
+				}
+			}
+			validator.apiKeys.Store(&values)
+			validator.setETag("/api-keys", etag)
+		}
+	}
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load JWT validation key from identity service.")
+		errs = append(errs, errors.New("failed to load API keys: "+err.Error()))
 	}
 
-	if loadingServiceKeys {
-		servieKeys, err := loadServiceKeys(endpoint, serviceKey, client)
+	if validator.loadingServiceKeys {
+		body, etag, err = validator.fetch("/service-keys")
+		if err == nil && body != nil {
+			var keys []ServiceKey
+			keys, err = parseData[[]ServiceKey](body)
+			if err == nil {
+				values := map[string]ServiceKey{}
+				for _, key := range keys {
+					if key.IsUsable() {
+						values[key.Hash] = key
+					}
+				}
+				validator.serviceKeys.Store(&values)
+				validator.setETag("/service-keys", etag)
+			}
+		}
 		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to load service keys from identity service.")
+			errs = append(errs, errors.New("failed to load service keys: "+err.Error()))
+		}
+	}
+
+	body, etag, err = validator.fetch("/validation-key")
+	if err == nil && body != nil {
+		var key *ValidationKey
+		key, err = parseValidationKey(body)
+		if err == nil {
+			validator.Keys.SetDefaultKey(key)
+			validator.setETag("/validation-key", etag)
+		}
+	}
+	if err != nil {
+		errs = append(errs, errors.New("failed to load validation key: "+err.Error()))
+	}
+
+	body, etag, err = validator.fetch("/.well-known/jwks.json")
+	if err == nil && body != nil {
+		var keys map[string]ValidationKey
+		keys, err = parseJSONWebKeySet(body)
+		if err == nil {
+			validator.Keys.SetKeys(keys)
+			validator.setETag("/.well-known/jwks.json", etag)
 		}
-		return &ValidationService{Key: vaidationKey, APIKeys: &keys, ServiceKeys: &servieKeys, Client: client, Endpoint: endpoint}
+	}
+	if err != nil {
+		errs = append(errs, errors.New("failed to load JSON web key set: "+err.Error()))
+	}
+
+	body, etag, err = validator.fetch("/revocation-list")
+	if err == nil && body != nil {
+		var list *RevocationList
+		list, err = parseData[*RevocationList](body)
+		if err == nil && list == nil {
+			err = errors.New("empty response")
+		}
+		if err == nil {
+			validator.SetRevocationList(list)
+			validator.setETag("/revocation-list", etag)
+		}
+	}
+	if err != nil {
+		errs = append(errs, errors.New("failed to load revocation list: "+err.Error()))
 	}
 
-	return &ValidationService{Key: vaidationKey, APIKeys: &keys, ServiceKeys: nil, Client: client, Endpoint: endpoint}
+	return errors.Join(errs...)
 }
 
 // ValidateAccessToken parses and validates the given access token
 // returns the custom claim present in the token payload
 func (validator *ValidationService) ValidateAccessToken(tokenString string) (*UserClaims, error) {
 
-	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			log.Error().Msg("Unexpected signing method in auth token")
-			return nil, errors.New("unexpected signing method in auth token")
-		}
-		return validator.Key, nil
-	})
+	claims, err := validator.parseAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsClientToken() && (validator.Audience == "" || !slices.Contains(claims.Audience, validator.Audience)) {
+		return nil, errors.New("invalid token: token was issued for another audience")
+	}
+	return claims, nil
+}
+
+// ValidateAccessTokenForScope works like ValidateAccessToken but accepts tokens of every audience
+// as long as they were issued to an OAuth client that was granted the given scope.
+func (validator *ValidationService) ValidateAccessTokenForScope(tokenString string, scope string) (*UserClaims, error) {
+
+	claims, err := validator.parseAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.HasScope(scope) {
+		return nil, errors.New("invalid token: token is missing the scope '" + scope + "'")
+	}
+	return claims, nil
+}
+
+func (validator *ValidationService) parseAccessToken(tokenString string) (*UserClaims, error) {
+
+	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, validator.keyFunc)
 
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to parse claims")
@@ -78,9 +358,72 @@ func (validator *ValidationService) ValidateAccessToken(tokenString string) (*Us
 	if !ok || !token.Valid || claims.UserID == "" {
 		return nil, errors.New("invalid token: authentication failed")
 	}
+	if validator.isRevoked(claims) {
+		return nil, errors.New("invalid token: token was revoked")
+	}
 	return claims, nil
 }
 
+// ValidateServiceToken parses and validates the given service token, it is rejected if it wasn't issued
+// with the given scope. Service tokens are short-lived and can't be revoked, disabled service keys get no new ones.
+func (validator *ValidationService) ValidateServiceToken(tokenString string, scope string) (*ServiceClaims, error) {
+
+	token, err := jwt.ParseWithClaims(tokenString, &ServiceClaims{}, validator.keyFunc, jwt.WithExpirationRequired())
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to parse service claims")
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*ServiceClaims)
+	if !ok || !token.Valid || !strings.HasPrefix(claims.Subject, ServiceTokenSubjectPrefix) {
+		return nil, errNoServiceToken
+	}
+	if scope != "" && !claims.HasScope(scope) {
+		return nil, errors.New("invalid token: service token is missing the scope '" + scope + "'")
+	}
+	return claims, nil
+}
+
+// keyFunc returns the key the JWT was signed with, the key ID and algorithm need to match a known signing key.
+func (validator *ValidationService) keyFunc(token *jwt.Token) (interface{}, error) {
+
+	algorithm := token.Method.Alg()
+	if !ValidAlgorithm(algorithm) || algorithm != validator.acceptedAlgorithm() {
+		log.Error().Msg("Unexpected signing method in auth token")
+		return nil, errors.New("unexpected signing method in auth token")
+	}
+	key := validator.Key
+	if validator.Keys != nil {
+		keyID, _ := token.Header["kid"].(string)
+		var err error
+		key, err = validator.Keys.Get(keyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// a key may only be used with the algorithm it was published for
+	if key == nil || key.Algorithm != algorithm {
+		log.Error().Msg("Signing method in auth token does not match the signing key")
+		return nil, errors.New("signing method in auth token does not match the signing key")
+	}
+	return key.Key, nil
+}
+
+// acceptedAlgorithm returns the configured algorithm or the algorithm of the loaded keys if none was configured.
+func (validator *ValidationService) acceptedAlgorithm() string {
+
+	if validator.Algorithm != "" {
+		return validator.Algorithm
+	}
+	if validator.Keys != nil {
+		return validator.Keys.Algorithm()
+	}
+	if validator.Key != nil {
+		return validator.Key.Algorithm
+	}
+	return ""
+}
+
 func validationClient(clientCert string, clientKey string, serverCA string) (*http.Client, error) {
 
 	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
@@ -116,113 +459,93 @@ func validationClient(clientCert string, clientKey string, serverCA string) (*ht
 	return client, nil
 }
 
-func loadValidationKey(endpoint string, serviceKey string, client *http.Client) (*rsa.PublicKey, error) {
-
-	request, err := http.NewRequest(http.MethodGet, endpoint+"/validation-key", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	request.Header.Set("Content-Type", "application/x-x509-user-cert; charset=utf-8")
-	request.Header.Set("X-Request-ID", uuid.New().String())
-	request.Header.Set("Service-Key", serviceKey)
-
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	resBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to retrieve validation key from identity service with error response: " + string(resBody))
-	}
-
-	return jwt.ParseRSAPublicKeyFromPEM(resBody)
-}
-
-func loadAPIKeys(endpoint string, serviceKey string, client *http.Client) ([]string, error) {
+// fetch requests the given resource from the identity service. If the resource did not change
+// since it was last loaded, fetch returns a nil body.
+func (validator *ValidationService) fetch(path string) ([]byte, string, error) {
 
-	request, err := http.NewRequest(http.MethodGet, endpoint+"/api-keys", nil)
+	request, err := http.NewRequest(http.MethodGet, validator.Endpoint+path, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	request.Header.Set("Content-Type", "application/json; charset=utf-8")
 	request.Header.Set("X-Request-ID", uuid.New().String())
-	request.Header.Set("Service-Key", serviceKey)
+	request.Header.Set("Service-Key", validator.serviceKey)
+	if etag := validator.getETag(path); etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
 
-	resp, err := client.Do(request)
+	resp, err := validator.Client.Do(request)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	resBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to retrieve api keys from identity service with error response: " + string(resBody))
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", nil
 	}
-
-	var data map[string][]APIKey
-	err = json.Unmarshal(resBody, &data)
-	if err != nil {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("identity service responded with error response: " + string(resBody))
 	}
+	return resBody, resp.Header.Get("ETag"), nil
+}
 
-	key_map := data["data"]
-
-	var rawKeys = []string{}
-	for _, key := range key_map {
-		rawKeys = append(rawKeys, key.Key)
-	}
-	return rawKeys, nil
+func (validator *ValidationService) getETag(path string) string {
+	validator.etagLock.Lock()
+	defer validator.etagLock.Unlock()
+	return validator.etags[path]
 }
 
-func loadServiceKeys(endpoint string, serviceKey string, client *http.Client) ([]string, error) {
+func (validator *ValidationService) setETag(path string, etag string) {
+	validator.etagLock.Lock()
+	defer validator.etagLock.Unlock()
+	validator.etags[path] = etag
+}
 
-	request, err := http.NewRequest(http.MethodGet, endpoint+"/service-keys", nil)
-	if err != nil {
-		return nil, err
-	}
+func parseData[T any](body []byte) (T, error) {
+	var data map[string]T
+	err := json.Unmarshal(body, &data)
+	return data["data"], err
+}
 
-	request.Header.Set("Content-Type", "application/json; charset=utf-8")
-	request.Header.Set("X-Request-ID", uuid.New().String())
-	request.Header.Set("Service-Key", serviceKey)
+func parseValidationKey(body []byte) (*ValidationKey, error) {
 
-	resp, err := client.Do(request)
+	publicKey, err := parsePublicKeyPEM(body)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	resBody, err := io.ReadAll(resp.Body)
+	algorithm, err := algorithmForKey(publicKey)
 	if err != nil {
 		return nil, err
 	}
+	return &ValidationKey{Algorithm: algorithm, Key: publicKey}, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to retrieve service keys from identity service with error response: " + string(resBody))
-	}
+func parseJSONWebKeySet(body []byte) (map[string]ValidationKey, error) {
 
-	var data map[string][]ServiceKey
-	err = json.Unmarshal(resBody, &data)
+	var keySet JSONWebKeySet
+	err := json.Unmarshal(body, &keySet)
 	if err != nil {
 		return nil, err
 	}
 
-	key_map := data["data"]
-
-	var rawKeys = []string{}
-	for _, key := range key_map {
-		rawKeys = append(rawKeys, key.Key)
+	keys := map[string]ValidationKey{}
+	for _, jwk := range keySet.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Error().Err(err).Str("kid", jwk.KeyID).Msg("Skipping invalid JSON web key.")
+			continue
+		}
+		if !keyMatchesAlgorithm(key, jwk.Algorithm) {
+			log.Error().Str("kid", jwk.KeyID).Msg("Skipping JSON web key with unsupported algorithm.")
+			continue
+		}
+		keys[jwk.KeyID] = ValidationKey{Algorithm: jwk.Algorithm, Key: key}
 	}
-	return rawKeys, nil
+	return keys, nil
 }
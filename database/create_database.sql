@@ -17,10 +17,16 @@ CREATE TABLE IF NOT EXISTS `users` (
 
 	`user_id` 			    int unsigned 	 	NOT NULL AUTO_INCREMENT 											    COMMENT 'The id of the user.',
 	`user_email` 		    varchar(255)		NOT NULL													            COMMENT 'The email of the user. The email needs to be unique.',
-	`user_password` 	    varchar(225) 	  	NOT NULL 												                COMMENT 'The password hash of the users password.',
+	`user_password` 	    varchar(225) 	  	NOT NULL 												                COMMENT 'The password hash of the users password, an argon2id hash in the PHC string format or a bcrypt hash.',
 	`user_createdat` 		timestamp 			NOT NULL DEFAULT current_timestamp()					      		    COMMENT 'The date and time the user was created.',
 	`user_updatedat` 		timestamp 			NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp()	    COMMENT 'The date and time the user data was last updated.',
     `user_role` 	  	    tinyint 		    NOT NULL DEFAULT 0											            COMMENT 'The role of the user.',
+    `user_suspended` 	    tinyint(1) 		    NOT NULL DEFAULT 0											            COMMENT 'Whether the user is suspended. Suspended users can not obtain tokens.',
+    `user_suspended_reason` varchar(255) 		NOT NULL DEFAULT ''											            COMMENT 'The reason the user was suspended.',
+    `user_suspended_by` 	int unsigned 		DEFAULT NULL											                COMMENT 'The id of the admin that suspended the user.',
+    `user_suspended_at` 	timestamp 			NULL DEFAULT NULL										                COMMENT 'The date and time the user was suspended.',
+    `user_verified` 	    tinyint(1) 		    NOT NULL DEFAULT 0											            COMMENT 'Whether the user verified the email. Unverified users can not obtain tokens.',
+    `user_verified_at` 	    timestamp 			NULL DEFAULT NULL										                COMMENT 'The date and time the user verified the email.',
 
 PRIMARY 	KEY (`user_id`),
 UNIQUE 	    KEY (`user_email`)
@@ -31,11 +37,19 @@ UNIQUE 	    KEY (`user_email`)
 CREATE TABLE IF NOT EXISTS `service_keys` (
 
 	`service_key_id` 	    int unsigned 	 	NOT NULL AUTO_INCREMENT 									    COMMENT 'The id of the key.',
-	`service_key` 	  	    varchar(225) 		NOT NULL 												        COMMENT 'The service key.',
+	`service_key_prefix` 	varchar(32) 		NOT NULL DEFAULT ''									        COMMENT 'The visible prefix of the service key.',
+	`service_key_hash` 	    varchar(225) 		NOT NULL 												        COMMENT 'The SHA-256 hash of the service key.',
     `service_key_comment` 	varchar(225) 		NOT NULL 												        COMMENT 'A comment about the service key.',
+	`service_key_enabled` 	    tinyint(1) 			NOT NULL DEFAULT 1										COMMENT 'Whether the service key can be used.',
+	`service_key_createdat` 	    timestamp 			NOT NULL DEFAULT current_timestamp()						COMMENT 'The date and time the service key was created.',
+	`service_key_createdby` 	    int unsigned 		DEFAULT NULL											COMMENT 'The id of the admin that created the service key.',
+	`service_key_lastusedat` 	    timestamp 			NULL DEFAULT NULL										COMMENT 'The date and time the service key was last used.',
+	`service_key_expiresat` 	    timestamp 			NULL DEFAULT NULL										COMMENT 'The date and time the service key expires, keys without a date do not expire.',
+	`service_key_scopes` 	        varchar(255) 		NOT NULL DEFAULT 'keys:read,users:entities'				COMMENT 'The comma separated scopes of the service key.',
 
 PRIMARY 	KEY (`service_key_id`),
-UNIQUE 	  	KEY (`service_key`)
+UNIQUE 	  	KEY (`service_key_hash`),
+FOREIGN 	KEY (`service_key_createdby`)             REFERENCES users (user_id) ON DELETE SET NULL
 
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains all service node keys.';
 
@@ -43,14 +57,337 @@ UNIQUE 	  	KEY (`service_key`)
 CREATE TABLE IF NOT EXISTS `api_keys` (
 
 	`api_key_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the key.',
-	`api_key` 	  	        varchar(225) 		NOT NULL 												            COMMENT 'The api key.',
+	`api_key_prefix` 		varchar(32) 		NOT NULL DEFAULT ''									            COMMENT 'The visible prefix of the api key.',
+	`api_key_hash` 	        varchar(225) 		NOT NULL 												            COMMENT 'The SHA-256 hash of the api key.',
     `api_key_comment` 	  	varchar(225) 		NOT NULL 												            COMMENT 'A comment about the api key.',
+	`api_key_enabled` 	    tinyint(1) 			NOT NULL DEFAULT 1										COMMENT 'Whether the api key can be used.',
+	`api_key_createdat` 	    timestamp 			NOT NULL DEFAULT current_timestamp()						COMMENT 'The date and time the api key was created.',
+	`api_key_createdby` 	    int unsigned 		DEFAULT NULL											COMMENT 'The id of the admin that created the api key.',
+	`api_key_lastusedat` 	    timestamp 			NULL DEFAULT NULL										COMMENT 'The date and time the api key was last used.',
+	`api_key_expiresat` 	    timestamp 			NULL DEFAULT NULL										COMMENT 'The date and time the api key expires, keys without a date do not expire.',
+	`api_key_scopes` 	        varchar(255) 		NOT NULL DEFAULT 'signup,login,read'						COMMENT 'The comma separated scopes of the api key.',
+	`api_key_origin` 	        varchar(255) 		NOT NULL DEFAULT ''										COMMENT 'The origin or app identifier the api key is restricted to, empty if the key is not restricted.',
+	`api_key_ratelimit` 	    int unsigned 		NOT NULL DEFAULT 0										COMMENT 'The number of requests per minute allowed with the api key, 0 if the key is not limited.',
 
 PRIMARY 	KEY (`api_key_id`),
-UNIQUE 	  	KEY (`api_key`)
+UNIQUE 	  	KEY (`api_key_hash`),
+FOREIGN 	KEY (`api_key_createdby`)             REFERENCES users (user_id) ON DELETE SET NULL
 
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains all api keys.';
 
+-- Create the refresh token table
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+
+	`refresh_token_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the refresh token.',
+	`refresh_token_hash` 	  	char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the refresh token.',
+	`refresh_token_family` 	  	char(36) 			NOT NULL 												            COMMENT 'The family of the refresh token. All tokens rotated from the same login share a family.',
+	`refresh_token_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user the refresh token was issued to.',
+	`refresh_token_device` 	  	varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The device the refresh token was issued to.',
+	`refresh_token_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the refresh token was issued.',
+	`refresh_token_expiresat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the refresh token expires.',
+	`refresh_token_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the refresh token was rotated.',
+	`refresh_token_revoked` 	tinyint(1) 			NOT NULL DEFAULT 0										            COMMENT 'Whether the refresh token was revoked.',
+	`refresh_token_client` 		int unsigned 		NULL DEFAULT NULL										            COMMENT 'The API key id of the OAuth client the refresh token was issued to or NULL for logins.',
+	`refresh_token_scopes` 		varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The space separated scopes the OAuth client was granted.',
+
+PRIMARY 	KEY (`refresh_token_id`),
+UNIQUE 	  	KEY (`refresh_token_hash`),
+			KEY (`refresh_token_family`),
+FOREIGN 	KEY (`refresh_token_user`)             REFERENCES users (user_id) ON DELETE CASCADE,
+FOREIGN 	KEY (`refresh_token_client`)           REFERENCES api_keys (api_key_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the hashes of all issued refresh tokens.';
+
+-- Create the password reset token table
+CREATE TABLE IF NOT EXISTS `password_reset_tokens` (
+
+	`password_reset_token_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the password reset token.',
+	`password_reset_token_hash` 	  	char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the password reset token.',
+	`password_reset_token_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user the password reset token was issued to.',
+	`password_reset_token_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the password reset token was issued.',
+	`password_reset_token_expiresat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the password reset token expires.',
+	`password_reset_token_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the password reset token was used.',
+
+PRIMARY 	KEY (`password_reset_token_id`),
+UNIQUE 	  	KEY (`password_reset_token_hash`),
+FOREIGN 	KEY (`password_reset_token_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the hashes of all issued password reset tokens.';
+
+-- Create the email verification token table
+CREATE TABLE IF NOT EXISTS `email_verification_tokens` (
+
+	`email_verification_token_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the email verification token.',
+	`email_verification_token_hash` 	  	char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the email verification token.',
+	`email_verification_token_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user the email verification token was issued to.',
+	`email_verification_token_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the email verification token was issued.',
+	`email_verification_token_expiresat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the email verification token expires.',
+	`email_verification_token_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the email verification token was used.',
+
+PRIMARY 	KEY (`email_verification_token_id`),
+UNIQUE 	  	KEY (`email_verification_token_hash`),
+FOREIGN 	KEY (`email_verification_token_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the hashes of all issued email verification tokens.';
+
+-- Create the TOTP table
+CREATE TABLE IF NOT EXISTS `totp_secrets` (
+
+	`totp_user` 	  		int unsigned 		NOT NULL 												            COMMENT 'The id of the user the TOTP secret belongs to.',
+	`totp_secret` 	  		varchar(64) 		NOT NULL 												            COMMENT 'The base32 encoded TOTP secret.',
+	`totp_last_step` 		bigint unsigned 	NOT NULL DEFAULT 0										            COMMENT 'The last time step a code was accepted for, so codes can only be used once.',
+	`totp_createdat` 		timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the user enrolled.',
+	`totp_confirmedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the user confirmed the enrollment with a code.',
+
+PRIMARY 	KEY (`totp_user`),
+FOREIGN 	KEY (`totp_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the TOTP secrets of the users that enrolled into two-factor authentication.';
+
+-- Create the recovery codes table
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+
+	`recovery_code_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the recovery code.',
+	`recovery_code_hash` 	  	char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the recovery code.',
+	`recovery_code_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user the recovery code belongs to.',
+	`recovery_code_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the recovery code was generated.',
+	`recovery_code_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the recovery code was used.',
+
+PRIMARY 	KEY (`recovery_code_id`),
+UNIQUE 	  	KEY (`recovery_code_user`, `recovery_code_hash`),
+FOREIGN 	KEY (`recovery_code_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the hashes of the two-factor authentication recovery codes.';
+
+-- Create the MFA challenge table
+CREATE TABLE IF NOT EXISTS `mfa_challenges` (
+
+	`mfa_challenge_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the MFA challenge.',
+	`mfa_challenge_hash` 	  	char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the MFA challenge token.',
+	`mfa_challenge_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user that needs to provide the second factor.',
+	`mfa_challenge_device` 	  	varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The name of the device the login was started on.',
+	`mfa_challenge_attempts` 	int unsigned 		NOT NULL DEFAULT 0										            COMMENT 'The number of wrong codes that were provided for the challenge.',
+	`mfa_challenge_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the password was verified.',
+	`mfa_challenge_expiresat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the MFA challenge expires.',
+	`mfa_challenge_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the MFA challenge was completed.',
+
+PRIMARY 	KEY (`mfa_challenge_id`),
+UNIQUE 	  	KEY (`mfa_challenge_hash`),
+FOREIGN 	KEY (`mfa_challenge_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the hashes of the challenge tokens of logins waiting for the second factor.';
+
+-- Create the passkeys table
+CREATE TABLE IF NOT EXISTS `passkeys` (
+
+	`passkey_id` 					int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the passkey.',
+	`passkey_user` 	  				int unsigned 		NOT NULL 												            COMMENT 'The id of the user the passkey belongs to.',
+	`passkey_credential_id` 		varbinary(1023) 	NOT NULL 												            COMMENT 'The WebAuthn credential id of the passkey.',
+	`passkey_public_key` 			blob 				NOT NULL 												            COMMENT 'The COSE encoded public key of the passkey.',
+	`passkey_attestation_type` 		varchar(32) 		NOT NULL DEFAULT ''										            COMMENT 'The attestation type of the registration.',
+	`passkey_aaguid` 				varbinary(16) 		NOT NULL 												            COMMENT 'The AAGUID of the authenticator model.',
+	`passkey_sign_count` 			int unsigned 		NOT NULL DEFAULT 0										            COMMENT 'The last signature counter of the authenticator, used to detect cloned authenticators.',
+	`passkey_transports` 			varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The comma separated transports the authenticator supports.',
+	`passkey_backup_eligible` 		tinyint(1) 			NOT NULL DEFAULT 0										            COMMENT 'Whether the passkey can be synced to other devices.',
+	`passkey_backup_state` 			tinyint(1) 			NOT NULL DEFAULT 0										            COMMENT 'Whether the passkey is currently synced to other devices.',
+	`passkey_name` 	  				varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The name the user gave the passkey.',
+	`passkey_createdat` 			timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the passkey was registered.',
+	`passkey_lastusedat` 			timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the passkey was last used to login.',
+
+PRIMARY 	KEY (`passkey_id`),
+UNIQUE 	  	KEY (`passkey_credential_id`),
+FOREIGN 	KEY (`passkey_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the WebAuthn credentials users registered to login with passkeys.';
+
+-- Create the passkey challenge table
+CREATE TABLE IF NOT EXISTS `passkey_challenges` (
+
+	`passkey_challenge_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the passkey challenge.',
+	`passkey_challenge_hash` 	  	char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the passkey challenge token.',
+	`passkey_challenge_user` 	  	int unsigned 		NULL DEFAULT NULL										            COMMENT 'The id of the user registering a passkey or NULL for logins.',
+	`passkey_challenge_session` 	text 				NOT NULL 												            COMMENT 'The JSON encoded WebAuthn session of the ceremony.',
+	`passkey_challenge_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the ceremony was started.',
+	`passkey_challenge_expiresat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the passkey challenge expires.',
+	`passkey_challenge_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the ceremony was finished.',
+
+PRIMARY 	KEY (`passkey_challenge_id`),
+UNIQUE 	  	KEY (`passkey_challenge_hash`),
+FOREIGN 	KEY (`passkey_challenge_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the sessions of started passkey registrations and logins.';
+
+-- Create the OAuth client table
+CREATE TABLE IF NOT EXISTS `oauth_clients` (
+
+	`oauth_client_api_key` 			int unsigned 	 	NOT NULL 															COMMENT 'The id of the API key that is used as the credentials of the client.',
+	`oauth_client_name` 	  		varchar(255) 		NOT NULL 												            COMMENT 'The name of the client that is shown to users.',
+	`oauth_client_redirect_uris` 	text 				NOT NULL 												            COMMENT 'The space separated redirect URIs of the client.',
+	`oauth_client_confidential` 	tinyint(1) 			NOT NULL DEFAULT 1										            COMMENT 'Whether the client needs to authenticate with its secret.',
+	`oauth_client_trusted` 			tinyint(1) 			NOT NULL DEFAULT 0										            COMMENT 'Whether the client is authorized without the consent of the user.',
+	`oauth_client_createdat` 		timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the client was registered.',
+	`oauth_client_audiences` 		varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The space separated audiences of the access tokens issued to the client.',
+
+PRIMARY 	KEY (`oauth_client_api_key`),
+FOREIGN 	KEY (`oauth_client_api_key`)           REFERENCES api_keys (api_key_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the OAuth clients that can get tokens of users.';
+
+-- Create the OAuth consent table
+CREATE TABLE IF NOT EXISTS `oauth_consents` (
+
+	`oauth_consent_user` 			int unsigned 	 	NOT NULL 															COMMENT 'The id of the user that consented.',
+	`oauth_consent_client` 	  		int unsigned 		NOT NULL 												            COMMENT 'The API key id of the client the user consented to.',
+	`oauth_consent_scopes` 			varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The space separated scopes the user granted the client.',
+	`oauth_consent_createdat` 		timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the user consented first.',
+	`oauth_consent_updatedat` 		timestamp 			NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp()	COMMENT 'The date and time the consent was last changed.',
+
+PRIMARY 	KEY (`oauth_consent_user`, `oauth_consent_client`),
+FOREIGN 	KEY (`oauth_consent_user`)             REFERENCES users (user_id) ON DELETE CASCADE,
+FOREIGN 	KEY (`oauth_consent_client`)           REFERENCES api_keys (api_key_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the scopes users granted OAuth clients.';
+
+-- Create the OAuth authorization code table
+CREATE TABLE IF NOT EXISTS `oauth_codes` (
+
+	`oauth_code_id` 				int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the authorization code.',
+	`oauth_code_hash` 	  			char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the authorization code.',
+	`oauth_code_client` 	  		int unsigned 		NOT NULL 												            COMMENT 'The API key id of the client the code was issued to.',
+	`oauth_code_user` 	  			int unsigned 		NOT NULL 												            COMMENT 'The id of the user that authorized the client.',
+	`oauth_code_redirect_uri` 		varchar(2048) 		NOT NULL 												            COMMENT 'The redirect URI of the authorization request.',
+	`oauth_code_scopes` 			varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The space separated scopes that were granted.',
+	`oauth_code_challenge` 			varchar(128) 		NOT NULL 												            COMMENT 'The S256 PKCE code challenge of the authorization request.',
+	`oauth_code_family` 			char(36) 			NULL DEFAULT NULL										            COMMENT 'The refresh token family that was started when the code was exchanged.',
+	`oauth_code_nonce` 				varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The OpenID Connect nonce of the authorization request.',
+	`oauth_code_createdat` 			timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the code was issued.',
+	`oauth_code_expiresat` 			timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the code expires.',
+	`oauth_code_usedat` 			timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the code was exchanged.',
+
+PRIMARY 	KEY (`oauth_code_id`),
+UNIQUE 	  	KEY (`oauth_code_hash`),
+FOREIGN 	KEY (`oauth_code_client`)              REFERENCES api_keys (api_key_id) ON DELETE CASCADE,
+FOREIGN 	KEY (`oauth_code_user`)                REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the hashes of issued OAuth authorization codes.';
+
+-- Create the linked identity table
+CREATE TABLE IF NOT EXISTS `linked_identities` (
+
+	`linked_identity_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the linked identity.',
+	`linked_identity_user` 	  		int unsigned 		NOT NULL 												            COMMENT 'The id of the user the identity is linked to.',
+	`linked_identity_provider` 	  	varchar(64) 		NOT NULL 												            COMMENT 'The id of the external OpenID Connect provider as configured.',
+	`linked_identity_subject` 		varchar(255) 		NOT NULL 												            COMMENT 'The subject of the user at the provider.',
+	`linked_identity_email` 		varchar(255) 		NOT NULL DEFAULT ''										            COMMENT 'The email of the user at the provider as of the last login.',
+	`linked_identity_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the identity was linked.',
+	`linked_identity_lastusedat` 	timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time of the last login with the identity.',
+
+PRIMARY 	KEY (`linked_identity_id`),
+UNIQUE 	  	KEY (`linked_identity_provider`, `linked_identity_subject`),
+FOREIGN 	KEY (`linked_identity_user`)           REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table links the identities of users at external OpenID Connect providers to the users.';
+
+-- Create the federated login table
+CREATE TABLE IF NOT EXISTS `federated_logins` (
+
+	`federated_login_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the federated login.',
+	`federated_login_hash` 	  		char(64) 			NOT NULL 												            COMMENT 'The SHA-256 hash of the state passed to the provider.',
+	`federated_login_provider` 	  	varchar(64) 		NOT NULL 												            COMMENT 'The id of the external OpenID Connect provider.',
+	`federated_login_user` 	  		int unsigned 		NULL DEFAULT NULL										            COMMENT 'The id of the user linking an identity or NULL for logins.',
+	`federated_login_nonce` 		varchar(64) 		NOT NULL 												            COMMENT 'The nonce the ID token of the provider needs to contain.',
+	`federated_login_code_verifier` varchar(128) 		NOT NULL 												            COMMENT 'The PKCE code verifier sent to the token endpoint of the provider.',
+	`federated_login_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the login was started.',
+	`federated_login_expiresat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the login expires.',
+	`federated_login_usedat` 		timestamp 			NULL DEFAULT NULL										            COMMENT 'The date and time the login was finished.',
+
+PRIMARY 	KEY (`federated_login_id`),
+UNIQUE 	  	KEY (`federated_login_hash`),
+FOREIGN 	KEY (`federated_login_user`)           REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the started logins with external OpenID Connect providers.';
+
+-- Create the login attempts table
+CREATE TABLE IF NOT EXISTS `login_attempts` (
+
+	`attempt_id` 			int unsigned 	 	NOT NULL AUTO_INCREMENT 											COMMENT 'The id of the login attempt.',
+	`attempt_email` 	  	varchar(255) 		NOT NULL 												            COMMENT 'The email the login was attempted with.',
+	`attempt_user` 	  		int unsigned 		NULL DEFAULT NULL										            COMMENT 'The id of the user with the email or NULL if there is no such user.',
+	`attempt_ip` 	  		varchar(45) 		NOT NULL 												            COMMENT 'The IP address the login was attempted from.',
+	`attempt_peer` 	  		varchar(255) 		NOT NULL DEFAULT ''									            COMMENT 'The client certificate of the service that sent the login.',
+	`attempt_success` 		tinyint(1) 			NOT NULL DEFAULT 0										            COMMENT 'Whether the login was successful.',
+	`attempt_cleared` 		tinyint(1) 			NOT NULL DEFAULT 0										            COMMENT 'Whether the failed login was cleared by a successful login or an admin and no longer counts towards the lockout.',
+	`attempt_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time of the login attempt.',
+
+PRIMARY 	KEY (`attempt_id`),
+			KEY (`attempt_email`, `attempt_createdat`),
+			KEY (`attempt_ip`, `attempt_createdat`),
+FOREIGN 	KEY (`attempt_user`)             REFERENCES users (user_id) ON DELETE SET NULL
+
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the login attempts used for the brute-force protection.';
+
+-- Create the revoked token table
+CREATE TABLE IF NOT EXISTS `revoked_tokens` (
+
+	`revoked_token_jti` 		char(36) 			NOT NULL 												            COMMENT 'The id (jti) of the revoked access token.',
+	`revoked_token_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user the access token was issued to.',
+	`revoked_token_expiresat` 	bigint unsigned 	NOT NULL 												            COMMENT 'The unix time the access token expires, afterwards the entry can be removed.',
+	`revoked_token_revokedat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the access token was revoked.',
+
+PRIMARY 	KEY (`revoked_token_jti`),
+FOREIGN 	KEY (`revoked_token_user`)             REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains all access tokens that were revoked before they expired.';
+
+-- Create the revoked sessions table
+CREATE TABLE IF NOT EXISTS `revoked_sessions` (
+
+	`revoked_session_user` 	  	int unsigned 		NOT NULL 												            COMMENT 'The id of the user whose sessions were revoked.',
+	`revoked_session_before` 	bigint unsigned 	NOT NULL 												            COMMENT 'The unix time before which all access tokens of the user are revoked.',
+
+PRIMARY 	KEY (`revoked_session_user`),
+FOREIGN 	KEY (`revoked_session_user`)           REFERENCES users (user_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the users whose access tokens were revoked all at once.';
+
+-- Create the signing key table
+CREATE TABLE IF NOT EXISTS `signing_keys` (
+
+	`signing_key_id` 			varchar(64) 		NOT NULL 												            COMMENT 'The id (kid) of the key, the RFC 7638 thumbprint of the public key.',
+	`signing_key_algorithm` 	varchar(16) 		NOT NULL 												            COMMENT 'The JWT signing algorithm of the key.',
+	`signing_key_state` 		varchar(16) 		NOT NULL 												            COMMENT 'The state of the key, one of next, active, previous or retired.',
+	`signing_key_private` 		text 				NOT NULL 												            COMMENT 'The PEM encoded private key.',
+	`signing_key_public` 		text 				NOT NULL 												            COMMENT 'The PEM encoded public key.',
+	`signing_key_createdat` 	timestamp 			NOT NULL DEFAULT current_timestamp()					      		COMMENT 'The date and time the key was created.',
+	`signing_key_updatedat` 	timestamp 			NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp()	COMMENT 'The date and time the key was last updated.',
+
+PRIMARY 	KEY (`signing_key_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the key ring used to sign JWTs.';
+
+-- Create the role table
+CREATE TABLE IF NOT EXISTS `roles` (
+
+	`role_id` 					tinyint 			NOT NULL 												            COMMENT 'The id of the role, it is stored as the role of users.',
+	`role_name` 				varchar(32) 		NOT NULL 												            COMMENT 'The name of the role.',
+	`role_updatedat` 			timestamp 			NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp()	COMMENT 'The date and time the permissions of the role were last changed.',
+
+PRIMARY 	KEY (`role_id`),
+UNIQUE 	  	KEY (`role_name`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table contains the roles of users.';
+
+-- Create the role permission table
+CREATE TABLE IF NOT EXISTS `role_permissions` (
+
+	`role_permission_role` 		tinyint 			NOT NULL 												            COMMENT 'The id of the role.',
+	`role_permission_name` 		varchar(64) 		NOT NULL 												            COMMENT 'The name of the permission the role grants.',
+
+PRIMARY 	KEY (`role_permission_role`, `role_permission_name`),
+FOREIGN 	KEY (`role_permission_role`)           REFERENCES roles (role_id) ON DELETE CASCADE
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='This table maps the roles to the permissions they grant.';
+
 /**
 Create the mapping tables to associate entities
 */
@@ -159,12 +496,19 @@ FOREIGN 	KEY (`associated_user`)                 REFERENCES users (user_id)
 
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='This table maps tags to users.';
 
+/**
+Insert the default roles, admins have every permission.
+*/
+
+INSERT INTO `roles`(`role_id`, `role_name`) VALUES (42, 'ADMIN'), (1, 'CREATOR'), (2, 'COORDINATOR');
+INSERT INTO `role_permissions`(`role_permission_role`, `role_permission_name`) VALUES (42, 'server:read'), (42, 'server:update'), (42, 'logs:read'), (42, 'users:read'), (42, 'users:write'), (42, 'users:entities'), (42, 'entities:write'), (42, 'keys:read'), (42, 'keys:write'), (42, 'roles:read'), (42, 'roles:write');
+
 /**
 Insert default users (default password: we4711), api key and service key.
 */
 
-INSERT INTO `users`(`user_id`, `user_email`, `user_password`, `user_role`) VALUES (0, 'admin@email.com', '$2a$12$YbAhewILx82tGkLtEZWiKOfYzBt85RSQtGXhxlQX2hV7qiP51xPES', 42);
-INSERT INTO `users`(`user_id`, `user_email`, `user_password`, `user_role`) VALUES (0, 'user@email.com', '$2a$12$YbAhewILx82tGkLtEZWiKOfYzBt85RSQtGXhxlQX2hV7qiP51xPES', 1);
-INSERT INTO `users`(`user_id`, `user_email`, `user_password`, `user_role`) VALUES (0, 'coordinator@email.com', '$2a$12$YbAhewILx82tGkLtEZWiKOfYzBt85RSQtGXhxlQX2hV7qiP51xPES',2);
-INSERT INTO `api_keys`(`api_key`, `api_key_comment`)                       VALUES ('TEST_API_KEY_001', "DEVELOPMENT API KEY");
-INSERT INTO `service_keys`(`service_key`, `service_key_comment`)           VALUES ('TEST_SERVICE_KEY_001', "DEVELOPMENT SERVICE KEY");
\ No newline at end of file
+INSERT INTO `users`(`user_id`, `user_email`, `user_password`, `user_role`, `user_verified`) VALUES (0, 'admin@email.com', '$2a$12$YbAhewILx82tGkLtEZWiKOfYzBt85RSQtGXhxlQX2hV7qiP51xPES', 42, 1);
+INSERT INTO `users`(`user_id`, `user_email`, `user_password`, `user_role`, `user_verified`) VALUES (0, 'user@email.com', '$2a$12$YbAhewILx82tGkLtEZWiKOfYzBt85RSQtGXhxlQX2hV7qiP51xPES', 1, 1);
+INSERT INTO `users`(`user_id`, `user_email`, `user_password`, `user_role`, `user_verified`) VALUES (0, 'coordinator@email.com', '$2a$12$YbAhewILx82tGkLtEZWiKOfYzBt85RSQtGXhxlQX2hV7qiP51xPES', 2, 1);
+INSERT INTO `api_keys`(`api_key_prefix`, `api_key_hash`, `api_key_comment`)                   VALUES ('TEST', SHA2('TEST_API_KEY_001', 256), "DEVELOPMENT API KEY");
+INSERT INTO `service_keys`(`service_key_prefix`, `service_key_hash`, `service_key_comment`)   VALUES ('TEST', SHA2('TEST_SERVICE_KEY_001', 256), "DEVELOPMENT SERVICE KEY");
\ No newline at end of file
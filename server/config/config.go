@@ -1,6 +1,8 @@
 package config
 
 import (
+	"strings"
+
 	servertools "github.com/Festivals-App/festivals-server-tools"
 	"github.com/pelletier/go-toml"
 
@@ -17,11 +19,111 @@ type Config struct {
 	LoversEar                 string
 	Interval                  int
 	JwtExpiration             int
+	RefreshExpiration         int
+	ServiceTokenExpiration    int
+	JwtAlgorithm              string
 	AccessTokenPrivateKeyPath string
 	AccessTokenPublicKeyPath  string
 	InfoLog                   string
 	TraceLog                  string
+	PasswordResetURL          string
+	PasswordResetExpiration   int
+	VerificationURL           string
+	VerificationExpiration    int
+	EmailDomainBlocklist      string
 	DB                        *DBConfig
+	Mail                      *MailConfig
+	Password                  *PasswordConfig
+	PasswordHashing           *PasswordHashingConfig
+	LoginProtection           *LoginProtectionConfig
+	MFA                       *MFAConfig
+	WebAuthn                  *WebAuthnConfig
+	OAuth                     *OAuthConfig
+	OIDC                      *OIDCConfig
+	Federation                *FederationConfig
+	ServiceIdentities         []ServiceIdentityConfig
+}
+
+type ServiceIdentityConfig struct {
+	Name     string
+	Subjects []string
+	Scopes   []string
+}
+
+type FederationConfig struct {
+	RedirectURL         string
+	ChallengeExpiration int
+	Providers           []FederationProviderConfig
+}
+
+type FederationProviderConfig struct {
+	ID           string
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+type OIDCConfig struct {
+	Issuer string
+}
+
+type OAuthConfig struct {
+	AuthorizeURL   string
+	CodeExpiration int
+}
+
+type WebAuthnConfig struct {
+	RPID                string
+	RPName              string
+	Origins             []string
+	ChallengeExpiration int
+}
+
+type MFAConfig struct {
+	Issuer              string
+	RequireForAdmin     bool
+	ChallengeExpiration int
+}
+
+type LoginProtectionConfig struct {
+	AccountThreshold int
+	IPThreshold      int
+	Lockout          int
+	MaxLockout       int
+	Window           int
+	Retention        int
+	TrustedProxies   []string
+	ClientIPHeader   string
+}
+
+type PasswordHashingConfig struct {
+	Algorithm         string
+	Argon2Memory      int
+	Argon2Time        int
+	Argon2Parallelism int
+	BcryptCost        int
+}
+
+type PasswordConfig struct {
+	MinLength         int
+	MaxLength         int
+	RequireLowercase  bool
+	RequireUppercase  bool
+	RequireDigit      bool
+	RequireSymbol     bool
+	MinStrength       int
+	BreachedPasswords string
+}
+
+type MailConfig struct {
+	Mailer       string
+	From         string
+	File         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
 }
 
 type DBConfig struct {
@@ -53,6 +155,9 @@ func ParseConfig(cfgFile string) *Config {
 	interval := content.Get("heartbeat.interval").(int64)
 
 	jwtExpiration := content.Get("jwt.expiration").(int64)
+	refreshExpiration := content.GetDefault("jwt.refresh-expiration", int64(43200)).(int64)
+	serviceTokenExpiration := content.GetDefault("jwt.service-expiration", int64(5)).(int64)
+	jwtAlgorithm := content.GetDefault("jwt.algorithm", "RS256").(string)
 	accessTokenPrivateKeyPath := content.Get("jwt.accessprivatekeypath").(string)
 	accessTokenPublicKeyPath := content.Get("jwt.accesspublickeypath").(string)
 
@@ -61,6 +166,66 @@ func ParseConfig(cfgFile string) *Config {
 
 	dbPassword := content.Get("database.password").(string)
 
+	mailer := content.GetDefault("mail.mailer", "file").(string)
+	mailFrom := content.GetDefault("mail.from", "noreply@festivalsapp.org").(string)
+	mailFile := content.GetDefault("mail.file", "").(string)
+	smtpHost := content.GetDefault("mail.smtp-host", "").(string)
+	smtpPort := content.GetDefault("mail.smtp-port", int64(587)).(int64)
+	smtpUsername := content.GetDefault("mail.smtp-username", "").(string)
+	smtpPassword := content.GetDefault("mail.smtp-password", "").(string)
+
+	passwordResetURL := content.GetDefault("password-reset.url", "https://festivalsapp.org/reset-password?token=").(string)
+	passwordResetExpiration := content.GetDefault("password-reset.expiration", int64(30)).(int64)
+
+	verificationURL := content.GetDefault("email-verification.url", "https://festivalsapp.org/verify-email?token=").(string)
+	verificationExpiration := content.GetDefault("email-verification.expiration", int64(1440)).(int64)
+
+	emailDomainBlocklist := content.GetDefault("email.domain-blocklist", "").(string)
+
+	passwordMinLength := content.GetDefault("password.min-length", int64(8)).(int64)
+	passwordMaxLength := content.GetDefault("password.max-length", int64(128)).(int64)
+	passwordRequireLowercase := content.GetDefault("password.require-lowercase", false).(bool)
+	passwordRequireUppercase := content.GetDefault("password.require-uppercase", false).(bool)
+	passwordRequireDigit := content.GetDefault("password.require-digit", false).(bool)
+	passwordRequireSymbol := content.GetDefault("password.require-symbol", false).(bool)
+	passwordMinStrength := content.GetDefault("password.min-strength", int64(0)).(int64)
+	breachedPasswords := content.GetDefault("password.breached-passwords", "").(string)
+
+	passwordHashAlgorithm := content.GetDefault("password-hashing.algorithm", "argon2id").(string)
+	argon2Memory := content.GetDefault("password-hashing.argon2-memory", int64(65536)).(int64)
+	argon2Time := content.GetDefault("password-hashing.argon2-time", int64(3)).(int64)
+	argon2Parallelism := content.GetDefault("password-hashing.argon2-parallelism", int64(2)).(int64)
+	bcryptCost := content.GetDefault("password-hashing.bcrypt-cost", int64(12)).(int64)
+
+	loginAccountThreshold := content.GetDefault("login-protection.account-threshold", int64(5)).(int64)
+	loginIPThreshold := content.GetDefault("login-protection.ip-threshold", int64(50)).(int64)
+	loginLockout := content.GetDefault("login-protection.lockout", int64(30)).(int64)
+	loginMaxLockout := content.GetDefault("login-protection.max-lockout", int64(3600)).(int64)
+	loginWindow := content.GetDefault("login-protection.window", int64(1440)).(int64)
+	loginRetention := content.GetDefault("login-protection.retention", int64(30)).(int64)
+	loginTrustedProxies := stringArray(content.GetDefault("login-protection.trusted-proxies", []interface{}{}))
+	loginClientIPHeader := content.GetDefault("login-protection.client-ip-header", "X-Forwarded-For").(string)
+
+	mfaIssuer := content.GetDefault("mfa.issuer", "Festivals App").(string)
+	mfaRequireForAdmin := content.GetDefault("mfa.require-for-admin", false).(bool)
+	mfaChallengeExpiration := content.GetDefault("mfa.challenge-expiration", int64(5)).(int64)
+
+	webauthnRPID := content.GetDefault("webauthn.rp-id", "festivalsapp.org").(string)
+	webauthnRPName := content.GetDefault("webauthn.rp-name", "Festivals App").(string)
+	webauthnOrigins := stringArray(content.GetDefault("webauthn.origins", []interface{}{"https://festivalsapp.org"}))
+	webauthnChallengeExpiration := content.GetDefault("webauthn.challenge-expiration", int64(5)).(int64)
+
+	oauthAuthorizeURL := content.GetDefault("oauth.authorize-url", "https://festivalsapp.org/authorize").(string)
+	oauthCodeExpiration := content.GetDefault("oauth.code-expiration", int64(1)).(int64)
+
+	oidcIssuer := strings.TrimSuffix(content.GetDefault("oidc.issuer", "https://identity.festivalsapp.org").(string), "/")
+
+	federationRedirectURL := content.GetDefault("federation.redirect-url", "https://festivalsapp.org/login/federated").(string)
+	federationChallengeExpiration := content.GetDefault("federation.challenge-expiration", int64(10)).(int64)
+	federationProviders := federationProviderArray(content.Get("federation.providers"))
+
+	serviceIdentities := serviceIdentityArray(content.Get("tls.services"))
+
 	tlsrootcert = servertools.ExpandTilde(tlsrootcert)
 	tlscert = servertools.ExpandTilde(tlscert)
 	tlskey = servertools.ExpandTilde(tlskey)
@@ -68,6 +233,9 @@ func ParseConfig(cfgFile string) *Config {
 	accessTokenPrivateKeyPath = servertools.ExpandTilde(accessTokenPrivateKeyPath)
 	infoLogPath = servertools.ExpandTilde(infoLogPath)
 	traceLogPath = servertools.ExpandTilde(traceLogPath)
+	mailFile = servertools.ExpandTilde(mailFile)
+	emailDomainBlocklist = servertools.ExpandTilde(emailDomainBlocklist)
+	breachedPasswords = servertools.ExpandTilde(breachedPasswords)
 
 	return &Config{
 		ServiceBindHost:           serviceBindHost,
@@ -79,10 +247,18 @@ func ParseConfig(cfgFile string) *Config {
 		LoversEar:                 loversear,
 		Interval:                  int(interval),
 		JwtExpiration:             int(jwtExpiration),
+		RefreshExpiration:         int(refreshExpiration),
+		ServiceTokenExpiration:    int(serviceTokenExpiration),
+		JwtAlgorithm:              jwtAlgorithm,
 		AccessTokenPublicKeyPath:  accessTokenPublicKeyPath,
 		AccessTokenPrivateKeyPath: accessTokenPrivateKeyPath,
 		InfoLog:                   infoLogPath,
 		TraceLog:                  traceLogPath,
+		PasswordResetURL:          passwordResetURL,
+		PasswordResetExpiration:   int(passwordResetExpiration),
+		VerificationURL:           verificationURL,
+		VerificationExpiration:    int(verificationExpiration),
+		EmailDomainBlocklist:      emailDomainBlocklist,
 		DB: &DBConfig{
 			Dialect:  "mysql",
 			Host:     "localhost",
@@ -92,5 +268,110 @@ func ParseConfig(cfgFile string) *Config {
 			Name:     "festivals_identity_database",
 			Charset:  "utf8",
 		},
+		Mail: &MailConfig{
+			Mailer:       mailer,
+			From:         mailFrom,
+			File:         mailFile,
+			SMTPHost:     smtpHost,
+			SMTPPort:     int(smtpPort),
+			SMTPUsername: smtpUsername,
+			SMTPPassword: smtpPassword,
+		},
+		Password: &PasswordConfig{
+			MinLength:         int(passwordMinLength),
+			MaxLength:         int(passwordMaxLength),
+			RequireLowercase:  passwordRequireLowercase,
+			RequireUppercase:  passwordRequireUppercase,
+			RequireDigit:      passwordRequireDigit,
+			RequireSymbol:     passwordRequireSymbol,
+			MinStrength:       int(passwordMinStrength),
+			BreachedPasswords: breachedPasswords,
+		},
+		PasswordHashing: &PasswordHashingConfig{
+			Algorithm:         passwordHashAlgorithm,
+			Argon2Memory:      int(argon2Memory),
+			Argon2Time:        int(argon2Time),
+			Argon2Parallelism: int(argon2Parallelism),
+			BcryptCost:        int(bcryptCost),
+		},
+		LoginProtection: &LoginProtectionConfig{
+			AccountThreshold: int(loginAccountThreshold),
+			IPThreshold:      int(loginIPThreshold),
+			Lockout:          int(loginLockout),
+			MaxLockout:       int(loginMaxLockout),
+			Window:           int(loginWindow),
+			Retention:        int(loginRetention),
+			TrustedProxies:   loginTrustedProxies,
+			ClientIPHeader:   loginClientIPHeader,
+		},
+		MFA: &MFAConfig{
+			Issuer:              mfaIssuer,
+			RequireForAdmin:     mfaRequireForAdmin,
+			ChallengeExpiration: int(mfaChallengeExpiration),
+		},
+		WebAuthn: &WebAuthnConfig{
+			RPID:                webauthnRPID,
+			RPName:              webauthnRPName,
+			Origins:             webauthnOrigins,
+			ChallengeExpiration: int(webauthnChallengeExpiration),
+		},
+		OAuth: &OAuthConfig{
+			AuthorizeURL:   oauthAuthorizeURL,
+			CodeExpiration: int(oauthCodeExpiration),
+		},
+		OIDC: &OIDCConfig{
+			Issuer: oidcIssuer,
+		},
+		Federation: &FederationConfig{
+			RedirectURL:         federationRedirectURL,
+			ChallengeExpiration: int(federationChallengeExpiration),
+			Providers:           federationProviders,
+		},
+		ServiceIdentities: serviceIdentities,
+	}
+}
+
+// stringArray converts a TOML array of strings, values that are no strings are skipped.
+func stringArray(value interface{}) []string {
+
+	values, _ := value.([]interface{})
+	strings := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strings = append(strings, s)
+		}
+	}
+	return strings
+}
+
+// federationProviderArray converts the TOML array of federation provider tables.
+func federationProviderArray(value interface{}) []FederationProviderConfig {
+
+	trees, _ := value.([]*toml.Tree)
+	providers := make([]FederationProviderConfig, 0, len(trees))
+	for _, tree := range trees {
+		providers = append(providers, FederationProviderConfig{
+			ID:           tree.GetDefault("id", "").(string),
+			Name:         tree.GetDefault("name", "").(string),
+			Issuer:       tree.GetDefault("issuer", "").(string),
+			ClientID:     tree.GetDefault("client-id", "").(string),
+			ClientSecret: tree.GetDefault("client-secret", "").(string),
+		})
+	}
+	return providers
+}
+
+// serviceIdentityArray converts the TOML array of service identity tables.
+func serviceIdentityArray(value interface{}) []ServiceIdentityConfig {
+
+	trees, _ := value.([]*toml.Tree)
+	identities := make([]ServiceIdentityConfig, 0, len(trees))
+	for _, tree := range trees {
+		identities = append(identities, ServiceIdentityConfig{
+			Name:     tree.GetDefault("name", "").(string),
+			Subjects: stringArray(tree.Get("subjects")),
+			Scopes:   stringArray(tree.Get("scopes")),
+		})
 	}
+	return identities
 }
@@ -8,9 +8,16 @@ import (
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
+// GenerateClientAccessToken creates an access token of the user for the OAuth client. Clients only get the scopes
+// they were granted, so the token carries neither the role nor the entities or permissions of the user.
+func GenerateClientAccessToken(user *token.User, client *token.OAuthClient, scopes []string, auth *token.AuthService) (string, error) {
+	return auth.Sign(token.NewClientClaims(auth.Issuer, fmt.Sprint(user.ID), client, scopes, auth.TokenLifetime))
+}
+
 func GenerateAccessToken(user *token.User, db *sql.DB, auth *token.AuthService) (string, error) {
 
 	userID := fmt.Sprint(user.ID)
@@ -55,26 +62,33 @@ func GenerateAccessToken(user *token.User, db *sql.DB, auth *token.AuthService)
 		log.Error().Err(err).Msg("Unable to fetch tags for user.")
 		return "", errors.New("could not generate access token. please try again later")
 	}
+	userPermissions, err := GetPermissionsForRole(db, userRole)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to fetch permissions for user.")
+		return "", errors.New("could not generate access token. please try again later")
+	}
 
 	claims := token.UserClaims{
-		UserID:        userID,
-		UserRole:      userRole,
-		UserFestivals: userFestivals,
-		UserArtists:   userArtists,
-		UserLocations: userLocations,
-		UserEvents:    userEvents,
-		UserLinks:     userLinks,
-		UserImages:    userImages,
-		UserPlaces:    userPlaces,
-		UserTags:      userTags,
+		UserID:          userID,
+		UserRole:        userRole,
+		UserFestivals:   userFestivals,
+		UserArtists:     userArtists,
+		UserLocations:   userLocations,
+		UserEvents:      userEvents,
+		UserLinks:       userLinks,
+		UserImages:      userImages,
+		UserPlaces:      userPlaces,
+		UserTags:        userTags,
+		UserPermissions: userPermissions,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(auth.TokenLifetime)),
 			Issuer:    auth.Issuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(auth.SigningKey)
+	return auth.Sign(claims)
 }
 
 func RegenerateAccessToken(user *token.User, oldClaims *token.UserClaims, db *sql.DB, auth *token.AuthService) (string, error) {
@@ -121,24 +135,31 @@ func RegenerateAccessToken(user *token.User, oldClaims *token.UserClaims, db *sq
 		log.Error().Err(err).Msg("Unable to fetch tags for user.")
 		return "", errors.New("could not generate access token. please try again later")
 	}
+	userPermissions, err := GetPermissionsForRole(db, userRole)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to fetch permissions for user.")
+		return "", errors.New("could not generate access token. please try again later")
+	}
 
 	claims := token.UserClaims{
-		UserID:        userID,
-		UserRole:      userRole,
-		UserFestivals: userFestivals,
-		UserArtists:   userArtists,
-		UserLocations: userLocations,
-		UserEvents:    userEvents,
-		UserLinks:     userLinks,
-		UserImages:    userImages,
-		UserPlaces:    userPlaces,
-		UserTags:      userTags,
+		UserID:          userID,
+		UserRole:        userRole,
+		UserFestivals:   userFestivals,
+		UserArtists:     userArtists,
+		UserLocations:   userLocations,
+		UserEvents:      userEvents,
+		UserLinks:       userLinks,
+		UserImages:      userImages,
+		UserPlaces:      userPlaces,
+		UserTags:        userTags,
+		UserPermissions: userPermissions,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: oldClaims.ExpiresAt,
 			Issuer:    auth.Issuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(auth.SigningKey)
+	return auth.Sign(claims)
 }
@@ -3,13 +3,14 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 )
 
 func GetAllAPIKeys(db *sql.DB) ([]token.APIKey, error) {
 
-	query := "SELECT * FROM api_keys;"
+	query := "SELECT *, `api_key_expiresat` IS NOT NULL AND `api_key_expiresat` <= current_timestamp() FROM api_keys;"
 	vars := []interface{}{}
 
 	rows, err := executeRowQuery(db, query, vars)
@@ -27,42 +28,70 @@ func GetAllAPIKeys(db *sql.DB) ([]token.APIKey, error) {
 	return keys, nil
 }
 
-func AddAPIKey(db *sql.DB, key token.APIKey) error {
+func GetAPIKey(db *sql.DB, keyID string) (*token.APIKey, error) {
 
-	query := "INSERT INTO api_keys(`api_key`, `api_key_comment`) VALUES (?, ?);"
-	vars := []interface{}{key.Key, key.Comment}
+	query := "SELECT *, `api_key_expiresat` IS NOT NULL AND `api_key_expiresat` <= current_timestamp() FROM api_keys WHERE `api_key_id`=?;"
+	vars := []interface{}{keyID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	key, err := apiKeyScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// AddAPIKey stores the given API key and returns its ID.
+func AddAPIKey(db *sql.DB, key token.APIKey) (int, error) {
+
+	query := "INSERT INTO api_keys(`api_key_prefix`, `api_key_hash`, `api_key_comment`, `api_key_enabled`, `api_key_createdby`, `api_key_expiresat`, `api_key_scopes`, `api_key_origin`, `api_key_ratelimit`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	vars := []interface{}{key.Prefix, key.Hash, key.Comment, key.Enabled, key.CreatedBy, key.ExpiresAt, strings.Join(key.Scopes, ","), key.Origin, key.RateLimit}
 
 	result, err := executeQuery(db, query, vars)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	insertID, err := result.LastInsertId()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if insertID == 0 {
-		return errors.New("failed to insert new API key without mysql error")
+		return 0, errors.New("failed to insert new API key without mysql error")
 	}
-	return nil
+	return int(insertID), nil
 }
 
+// UpdateAPIKey updates everything but the key and its creation metadata of the given API key.
 func UpdateAPIKey(db *sql.DB, key token.APIKey) error {
 
-	query := "UPDATE api_keys SET `api_key`=?, `api_key_comment`=? WHERE `api_key_id`=?;"
-	vars := []interface{}{key.Key, key.Comment, key.ID}
+	query := "UPDATE api_keys SET `api_key_comment`=?, `api_key_enabled`=?, `api_key_expiresat`=?, `api_key_scopes`=?, `api_key_origin`=?, `api_key_ratelimit`=? WHERE `api_key_id`=?;"
+	vars := []interface{}{key.Comment, key.Enabled, key.ExpiresAt, strings.Join(key.Scopes, ","), key.Origin, key.RateLimit, key.ID}
 
-	result, err := executeQuery(db, query, vars)
-	if err != nil {
-		return err
-	}
-	numOfAffectedRows, err := result.RowsAffected()
-	if err != nil {
-		return err
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// SetAPIKeysUsed sets the last used date of the given API keys to now.
+func SetAPIKeysUsed(db *sql.DB, keyIDs []int) error {
+
+	if len(keyIDs) == 0 {
+		return nil
 	}
-	if numOfAffectedRows != 1 {
-		return errors.New("failed to update API key without mysql error")
+	query := "UPDATE api_keys SET `api_key_lastusedat`=current_timestamp() WHERE `api_key_id` IN (?" + strings.Repeat(", ?", len(keyIDs)-1) + ");"
+	vars := []interface{}{}
+	for _, keyID := range keyIDs {
+		vars = append(vars, keyID)
 	}
-	return nil
+
+	_, err := executeQuery(db, query, vars)
+	return err
 }
 
 func RemoveAPIKey(db *sql.DB, keyID string) error {
@@ -83,3 +112,34 @@ func RemoveAPIKey(db *sql.DB, keyID string) error {
 	}
 	return nil
 }
+
+// HashPlaintextAPIKeys replaces the API keys that are still stored in plaintext with their hash.
+// Plaintext keys are the ones without a prefix, their first characters are kept as the prefix.
+func HashPlaintextAPIKeys(db *sql.DB) (int, error) {
+
+	query := "SELECT *, false FROM api_keys WHERE `api_key_prefix`='';"
+	vars := []interface{}{}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return 0, err
+	}
+	keys := []token.APIKey{}
+	for rows.Next() {
+		key, err := apiKeyScan(rows)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		query = "UPDATE api_keys SET `api_key_prefix`=?, `api_key_hash`=? WHERE `api_key_id`=? AND `api_key_prefix`='';"
+		vars = []interface{}{token.LegacyKeyPrefix(key.Hash), token.HashKey(key.Hash), key.ID}
+		_, err = executeQuery(db, query, vars)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
@@ -2,21 +2,22 @@ package database
 
 import (
 	"database/sql"
+	"strings"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 )
 
-type Entity string
+type Entity = token.Entity
 
 const (
-	Festival Entity = "festival"
-	Artist   Entity = "artist"
-	Location Entity = "location"
-	Event    Entity = "event"
-	Link     Entity = "link"
-	Image    Entity = "image"
-	Place    Entity = "place"
-	Tag      Entity = "tag"
+	Festival = token.Festival
+	Artist   = token.Artist
+	Location = token.Location
+	Event    = token.Event
+	Link     = token.Link
+	Image    = token.Image
+	Place    = token.Place
+	Tag      = token.Tag
 )
 
 func executeRowQuery(db *sql.DB, query string, args []interface{}) (*sql.Rows, error) {
@@ -50,20 +51,104 @@ func executeQuery(db *sql.DB, query string, args []interface{}) (sql.Result, err
 
 func userScan(rs *sql.Rows) (token.User, error) {
 	var u token.User
-	return u, rs.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreateDate, &u.UpdateDate, &u.Role)
+	return u, rs.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreateDate, &u.UpdateDate, &u.Role, &u.Suspended, &u.SuspendedReason, &u.SuspendedBy, &u.SuspendedAt, &u.Verified, &u.VerifiedAt)
 }
 
 func userSummaryScan(rs *sql.Rows) (token.UserSummary, error) {
 	var u token.UserSummary
-	return u, rs.Scan(&u.ID, &u.Email, &u.CreateDate, &u.UpdateDate, &u.Role)
+	return u, rs.Scan(&u.ID, &u.Email, &u.CreateDate, &u.UpdateDate, &u.Role, &u.Suspended, &u.SuspendedReason, &u.SuspendedBy, &u.SuspendedAt, &u.Verified, &u.VerifiedAt)
 }
 
 func apiKeyScan(rs *sql.Rows) (token.APIKey, error) {
 	var u token.APIKey
-	return u, rs.Scan(&u.ID, &u.Key, &u.Comment)
+	var scopes string
+	err := rs.Scan(&u.ID, &u.Prefix, &u.Hash, &u.Comment, &u.Enabled, &u.CreateDate, &u.CreatedBy, &u.LastUsedAt, &u.ExpiresAt, &scopes, &u.Origin, &u.RateLimit, &u.Expired)
+	u.Scopes = splitScopes(scopes)
+	return u, err
+}
+
+// splitScopes splits the comma separated scopes stored in the database.
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
+
+func roleScan(rs *sql.Rows) (token.Role, error) {
+	var u token.Role
+	var permissions string
+	err := rs.Scan(&u.ID, &u.Name, &u.UpdateDate, &permissions)
+	u.Permissions = splitScopes(permissions)
+	return u, err
 }
 
 func serviceKeyScan(rs *sql.Rows) (token.ServiceKey, error) {
 	var u token.ServiceKey
-	return u, rs.Scan(&u.ID, &u.Key, &u.Comment)
+	var scopes string
+	err := rs.Scan(&u.ID, &u.Prefix, &u.Hash, &u.Comment, &u.Enabled, &u.CreateDate, &u.CreatedBy, &u.LastUsedAt, &u.ExpiresAt, &scopes, &u.Expired)
+	u.Scopes = splitScopes(scopes)
+	return u, err
+}
+
+func refreshTokenScan(rs *sql.Rows) (token.RefreshToken, error) {
+	var u token.RefreshToken
+	var scopes string
+	err := rs.Scan(&u.ID, &u.Hash, &u.Family, &u.UserID, &u.Device, &u.CreateDate, &u.ExpiresAt, &u.UsedAt, &u.Revoked, &u.ClientID, &scopes, &u.Expired)
+	u.Scopes = strings.Fields(scopes)
+	return u, err
+}
+
+func totpScan(rs *sql.Rows) (token.TOTP, error) {
+	var u token.TOTP
+	return u, rs.Scan(&u.UserID, &u.Secret, &u.LastStep, &u.CreateDate, &u.ConfirmedAt)
+}
+
+func passkeyScan(rs *sql.Rows) (token.Passkey, error) {
+	var u token.Passkey
+	var transports string
+	err := rs.Scan(&u.ID, &u.UserID, &u.CredentialID, &u.PublicKey, &u.AttestationType, &u.AAGUID, &u.SignCount, &transports, &u.BackupEligible, &u.BackupState, &u.Name, &u.CreateDate, &u.LastUsedAt)
+	u.Transports = splitScopes(transports)
+	return u, err
+}
+
+func oauthClientScan(rs *sql.Rows) (token.OAuthClient, error) {
+	var u token.OAuthClient
+	var redirectURIs string
+	var audiences string
+	err := rs.Scan(&u.APIKeyID, &u.Name, &redirectURIs, &u.Confidential, &u.Trusted, &u.CreateDate, &audiences, &u.ClientID, &u.SecretHash, &u.Usable)
+	u.RedirectURIs = strings.Fields(redirectURIs)
+	u.Audiences = strings.Fields(audiences)
+	return u, err
+}
+
+func oauthConsentScan(rs *sql.Rows) (token.OAuthConsent, error) {
+	var u token.OAuthConsent
+	var scopes string
+	err := rs.Scan(&u.UserID, &u.ClientID, &scopes, &u.CreateDate, &u.UpdateDate, &u.ClientName)
+	u.Scopes = strings.Fields(scopes)
+	return u, err
+}
+
+func oauthCodeScan(rs *sql.Rows) (token.OAuthCode, error) {
+	var u token.OAuthCode
+	var scopes string
+	err := rs.Scan(&u.ID, &u.Hash, &u.ClientID, &u.UserID, &u.RedirectURI, &scopes, &u.CodeChallenge, &u.Family, &u.Nonce, &u.CreateDate, &u.ExpiresAt, &u.UsedAt, &u.Expired)
+	u.Scopes = strings.Fields(scopes)
+	return u, err
+}
+
+func linkedIdentityScan(rs *sql.Rows) (token.LinkedIdentity, error) {
+	var u token.LinkedIdentity
+	return u, rs.Scan(&u.ID, &u.UserID, &u.Provider, &u.Subject, &u.Email, &u.CreateDate, &u.LastUsedAt)
+}
+
+func loginAttemptScan(rs *sql.Rows) (token.LoginAttempt, error) {
+	var u token.LoginAttempt
+	return u, rs.Scan(&u.ID, &u.Email, &u.User, &u.IP, &u.Peer, &u.Success, &u.Cleared, &u.CreateDate)
+}
+
+func signingKeyScan(rs *sql.Rows) (token.SigningKey, error) {
+	var u token.SigningKey
+	return u, rs.Scan(&u.ID, &u.Algorithm, &u.State, &u.PrivateKey, &u.PublicKey, &u.CreateDate, &u.UpdateDate)
 }
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// GenerateEmailVerificationToken creates a new email verification token for the given user and stores its hash.
+// Email verification tokens that were requested before for the user are removed.
+func GenerateEmailVerificationToken(db *sql.DB, user *token.User, lifetime time.Duration) (string, error) {
+
+	resetToken, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := "DELETE FROM email_verification_tokens WHERE `email_verification_token_user`=?;"
+	vars := []interface{}{user.ID}
+	_, err = executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+
+	query = "INSERT INTO email_verification_tokens(`email_verification_token_hash`, `email_verification_token_user`, `email_verification_token_expiresat`) VALUES (?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars = []interface{}{token.HashOpaqueToken(resetToken), user.ID, int(lifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new email verification token without mysql error")
+	}
+	return resetToken, nil
+}
+
+// UseEmailVerificationToken marks the given email verification token as used and returns the ID of its user.
+// It returns sql.ErrNoRows if the token is unknown, expired or was already used.
+func UseEmailVerificationToken(db *sql.DB, resetToken string) (string, error) {
+
+	query := "SELECT `email_verification_token_id`, `email_verification_token_user` FROM email_verification_tokens WHERE `email_verification_token_hash`=? AND `email_verification_token_usedat` IS NULL AND `email_verification_token_expiresat` > current_timestamp();"
+	vars := []interface{}{token.HashOpaqueToken(resetToken)}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", sql.ErrNoRows
+	}
+	var tokenID int
+	var userID string
+	err = rows.Scan(&tokenID, &userID)
+	if err != nil {
+		return "", err
+	}
+
+	// the update only succeeds once, so concurrent requests can not use the same token
+	query = "UPDATE email_verification_tokens SET `email_verification_token_usedat`=current_timestamp() WHERE `email_verification_token_id`=? AND `email_verification_token_usedat` IS NULL;"
+	vars = []interface{}{tokenID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if numOfAffectedRows != 1 {
+		return "", sql.ErrNoRows
+	}
+	return userID, nil
+}
+
+// RemoveExpiredEmailVerificationTokens deletes all expired email verification tokens.
+func RemoveExpiredEmailVerificationTokens(db *sql.DB) error {
+
+	query := "DELETE FROM email_verification_tokens WHERE `email_verification_token_expiresat` <= current_timestamp();"
+	vars := []interface{}{}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// ErrEmailExists is returned if a user can't be provisioned because another user already has the email.
+var ErrEmailExists = errors.New("a user with the email already exists")
+
+// GenerateFederatedLogin stores a started login with an external provider and returns the state the provider passes
+// back. Logins that link the external identity to an existing user belong to the given user, logins have no user.
+func GenerateFederatedLogin(db *sql.DB, provider string, userID *int, nonce string, codeVerifier string, lifetime time.Duration) (string, error) {
+
+	state, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO federated_logins(`federated_login_hash`, `federated_login_provider`, `federated_login_user`, `federated_login_nonce`, `federated_login_code_verifier`, `federated_login_expiresat`) VALUES (?, ?, ?, ?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars := []interface{}{token.HashOpaqueToken(state), provider, userID, nonce, codeVerifier, int(lifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new federated login without mysql error")
+	}
+	return state, nil
+}
+
+// UseFederatedLogin marks the started login as used and returns it. Logins that link an identity are only returned
+// for the user that started them, other logins only if userID is empty. It returns sql.ErrNoRows if the state is
+// unknown, expired or was already used.
+func UseFederatedLogin(db *sql.DB, state string, userID string) (*token.FederatedLogin, error) {
+
+	query := "SELECT `federated_login_id`, `federated_login_provider`, `federated_login_user`, `federated_login_nonce`, `federated_login_code_verifier` FROM federated_logins WHERE `federated_login_hash`=? AND `federated_login_user` IS NULL AND `federated_login_usedat` IS NULL AND `federated_login_expiresat` > current_timestamp();"
+	vars := []interface{}{token.HashOpaqueToken(state)}
+	if userID != "" {
+		query = "SELECT `federated_login_id`, `federated_login_provider`, `federated_login_user`, `federated_login_nonce`, `federated_login_code_verifier` FROM federated_logins WHERE `federated_login_hash`=? AND `federated_login_user`=? AND `federated_login_usedat` IS NULL AND `federated_login_expiresat` > current_timestamp();"
+		vars = []interface{}{token.HashOpaqueToken(state), userID}
+	}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	var login token.FederatedLogin
+	err = rows.Scan(&login.ID, &login.Provider, &login.UserID, &login.Nonce, &login.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// the update only succeeds once, so a state can only finish one login
+	query = "UPDATE federated_logins SET `federated_login_usedat`=current_timestamp() WHERE `federated_login_id`=? AND `federated_login_usedat` IS NULL;"
+	vars = []interface{}{login.ID}
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if numOfAffectedRows != 1 {
+		return nil, sql.ErrNoRows
+	}
+	return &login, nil
+}
+
+// RemoveExpiredFederatedLogins deletes all expired federated logins.
+func RemoveExpiredFederatedLogins(db *sql.DB) error {
+
+	query := "DELETE FROM federated_logins WHERE `federated_login_expiresat` <= current_timestamp();"
+	vars := []interface{}{}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// GetLinkedIdentity returns the linked identity with the given subject at the provider or sql.ErrNoRows if the
+// identity isn't linked to any user.
+func GetLinkedIdentity(db *sql.DB, provider string, subject string) (*token.LinkedIdentity, error) {
+
+	query := "SELECT * FROM linked_identities WHERE `linked_identity_provider`=? AND `linked_identity_subject`=?;"
+	vars := []interface{}{provider, subject}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	identity, err := linkedIdentityScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetLinkedIdentitiesForUser returns the identities at external providers that are linked to the given user.
+func GetLinkedIdentitiesForUser(db *sql.DB, userID string) ([]token.LinkedIdentity, error) {
+
+	query := "SELECT * FROM linked_identities WHERE `linked_identity_user`=? ORDER BY `linked_identity_id`;"
+	vars := []interface{}{userID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []token.LinkedIdentity{}
+	for rows.Next() {
+		identity, err := linkedIdentityScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// AddLinkedIdentity links the external identity to the user and returns the ID of the linked identity.
+// It fails if the identity is already linked to a user.
+func AddLinkedIdentity(db *sql.DB, userID int, identity *token.FederatedIdentity) (int, error) {
+
+	query := "INSERT INTO linked_identities(`linked_identity_user`, `linked_identity_provider`, `linked_identity_subject`, `linked_identity_email`) VALUES (?, ?, ?, ?);"
+	vars := []interface{}{userID, identity.Provider, identity.Subject, identity.Email}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if insertID == 0 {
+		return 0, errors.New("failed to insert new linked identity without mysql error")
+	}
+	return int(insertID), nil
+}
+
+// UseLinkedIdentity records a login with the linked identity, the email is updated in case it changed at the provider.
+func UseLinkedIdentity(db *sql.DB, identityID int, email string) error {
+
+	query := "UPDATE linked_identities SET `linked_identity_email`=?, `linked_identity_lastusedat`=current_timestamp() WHERE `linked_identity_id`=?;"
+	vars := []interface{}{email, identityID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// DeleteLinkedIdentity unlinks the identity from the given user, it returns sql.ErrNoRows if the user has no such identity.
+func DeleteLinkedIdentity(db *sql.DB, userID string, identityID string) error {
+
+	query := "DELETE FROM linked_identities WHERE `linked_identity_id`=? AND `linked_identity_user`=?;"
+	vars := []interface{}{identityID, userID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateFederatedUser provisions a verified creator for the external identity and links the identity to the new user.
+// It returns the ID of the new user or ErrEmailExists if another user already has the email.
+func CreateFederatedUser(db *sql.DB, email string, passwordhash string, identity *token.FederatedIdentity) (int, error) {
+
+	email, err := token.NormalizeEmail(email)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE `user_email`=? FOR UPDATE;", email).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrEmailExists
+	}
+
+	// the provider verified the email, so the user doesn't need to verify it again
+	result, err := tx.Exec("INSERT INTO `users`(`user_email`, `user_password`, `user_role`, `user_verified`, `user_verified_at`) VALUES (?, ?, ?, 1, current_timestamp());",
+		email, passwordhash, token.CREATOR)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if insertID == 0 {
+		return 0, errors.New("failed to insert new user without mysql error")
+	}
+
+	_, err = tx.Exec("INSERT INTO linked_identities(`linked_identity_user`, `linked_identity_provider`, `linked_identity_subject`, `linked_identity_email`) VALUES (?, ?, ?, ?);",
+		insertID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), tx.Commit()
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// AddLoginAttempt stores a login attempt together with the client certificate of the peer that sent the login,
+// a successful login clears the failed attempts of the email and of the IP address.
+func AddLoginAttempt(db *sql.DB, email string, userID *int, ip string, peer string, success bool) error {
+
+	query := "INSERT INTO login_attempts(`attempt_email`, `attempt_user`, `attempt_ip`, `attempt_peer`, `attempt_success`, `attempt_cleared`) VALUES (?, ?, ?, ?, ?, ?);"
+	vars := []interface{}{email, userID, ip, peer, success, success}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if insertID == 0 {
+		return errors.New("failed to insert login attempt without mysql error")
+	}
+	// failed attempts of the IP address only expire, so logins to an own account can't reset the lockout of the IP address
+	if success {
+		return ClearFailedLoginAttempts(db, email)
+	}
+	return nil
+}
+
+// ClearFailedLoginAttempts clears the failed login attempts of the email, so they no longer count towards the lockout.
+func ClearFailedLoginAttempts(db *sql.DB, email string) error {
+
+	query := "UPDATE login_attempts SET `attempt_cleared`=1 WHERE `attempt_email`=? AND `attempt_success`=0 AND `attempt_cleared`=0;"
+	vars := []interface{}{email}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// GetFailedLoginAttemptsForEmail returns the number of failed login attempts of the email within the given window
+// that were not cleared and how long ago the last one was made.
+func GetFailedLoginAttemptsForEmail(db *sql.DB, email string, window time.Duration) (int, time.Duration, error) {
+	return getFailedLoginAttempts(db, "SELECT COUNT(*), COALESCE(TIMESTAMPDIFF(SECOND, MAX(`attempt_createdat`), current_timestamp()), 0) FROM login_attempts WHERE `attempt_email`=? AND `attempt_success`=0 AND `attempt_cleared`=0 AND `attempt_createdat` > DATE_SUB(current_timestamp(), INTERVAL ? SECOND);", email, window)
+}
+
+// GetFailedLoginAttemptsForIP returns the number of failed login attempts from the IP address within the given window
+// that were not cleared and how long ago the last one was made.
+func GetFailedLoginAttemptsForIP(db *sql.DB, ip string, window time.Duration) (int, time.Duration, error) {
+	return getFailedLoginAttempts(db, "SELECT COUNT(*), COALESCE(TIMESTAMPDIFF(SECOND, MAX(`attempt_createdat`), current_timestamp()), 0) FROM login_attempts WHERE `attempt_ip`=? AND `attempt_success`=0 AND `attempt_cleared`=0 AND `attempt_createdat` > DATE_SUB(current_timestamp(), INTERVAL ? SECOND);", ip, window)
+}
+
+func getFailedLoginAttempts(db *sql.DB, query string, value string, window time.Duration) (int, time.Duration, error) {
+
+	vars := []interface{}{value, int(window.Seconds())}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, 0, sql.ErrNoRows
+	}
+	var failures, sinceLastFailure int
+	err = rows.Scan(&failures, &sinceLastFailure)
+	if err != nil {
+		return 0, 0, err
+	}
+	return failures, time.Duration(sinceLastFailure) * time.Second, nil
+}
+
+// GetLoginAttempts returns the latest login attempts, newest first. If email or ip are not empty
+// only the attempts with the given email or from the given IP address are returned.
+func GetLoginAttempts(db *sql.DB, email string, ip string, limit int) ([]token.LoginAttempt, error) {
+
+	query := "SELECT * FROM login_attempts WHERE (?='' OR `attempt_email`=?) AND (?='' OR `attempt_ip`=?) ORDER BY `attempt_id` DESC LIMIT ?;"
+	vars := []interface{}{email, email, ip, ip, limit}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attempts := []token.LoginAttempt{}
+	for rows.Next() {
+		attempt, err := loginAttemptScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+// RemoveExpiredLoginAttempts removes the login attempts that are older than the given retention.
+func RemoveExpiredLoginAttempts(db *sql.DB, retention time.Duration) error {
+
+	query := "DELETE FROM login_attempts WHERE `attempt_createdat` < DATE_SUB(current_timestamp(), INTERVAL ? SECOND);"
+	vars := []interface{}{int(retention.Seconds())}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// maxMFAChallengeAttempts is the number of wrong codes after which an MFA challenge can no longer be used.
+const maxMFAChallengeAttempts = 5
+
+// GetTOTP returns the TOTP enrollment of the given user or sql.ErrNoRows if the user didn't enroll.
+func GetTOTP(db *sql.DB, userID string) (*token.TOTP, error) {
+
+	query := "SELECT * FROM totp_secrets WHERE `totp_user`=?;"
+	vars := []interface{}{userID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	totp, err := totpScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// SetTOTPSecret stores a new unconfirmed TOTP secret for the given user, a previous unconfirmed secret is replaced.
+// It returns sql.ErrNoRows if the user already confirmed an enrollment.
+func SetTOTPSecret(db *sql.DB, userID string, secret string) error {
+
+	query := "DELETE FROM totp_secrets WHERE `totp_user`=? AND `totp_confirmedat` IS NULL;"
+	vars := []interface{}{userID}
+	_, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT IGNORE INTO totp_secrets(`totp_user`, `totp_secret`) VALUES (?, ?);"
+	vars = []interface{}{userID, secret}
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ConfirmTOTP confirms the TOTP enrollment of the given user, afterwards the user needs a code to login.
+func ConfirmTOTP(db *sql.DB, userID string) error {
+
+	query := "UPDATE totp_secrets SET `totp_confirmedat`=current_timestamp() WHERE `totp_user`=? AND `totp_confirmedat` IS NULL;"
+	vars := []interface{}{userID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// UseTOTPStep marks the time step as used, it returns false if a code of the same or a later time step was used before.
+func UseTOTPStep(db *sql.DB, userID string, step int64) (bool, error) {
+
+	// the update only succeeds once per time step, so codes can not be replayed
+	query := "UPDATE totp_secrets SET `totp_last_step`=? WHERE `totp_user`=? AND `totp_last_step` < ?;"
+	vars := []interface{}{step, userID, step}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return numOfAffectedRows == 1, nil
+}
+
+// DeleteTOTP removes the TOTP enrollment and the recovery codes of the given user.
+func DeleteTOTP(db *sql.DB, userID string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM totp_secrets WHERE `totp_user`=?;", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE `recovery_code_user`=?;", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of the given user with new ones and returns them.
+func ReplaceRecoveryCodes(db *sql.DB, userID string) ([]string, error) {
+
+	codes, err := token.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE `recovery_code_user`=?;", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err = tx.Exec("INSERT INTO recovery_codes(`recovery_code_hash`, `recovery_code_user`) VALUES (?, ?);", token.HashRecoveryCode(code), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode marks the recovery code of the given user as used, it returns false if the code is unknown or was already used.
+func UseRecoveryCode(db *sql.DB, userID string, code string) (bool, error) {
+
+	query := "UPDATE recovery_codes SET `recovery_code_usedat`=current_timestamp() WHERE `recovery_code_user`=? AND `recovery_code_hash`=? AND `recovery_code_usedat` IS NULL;"
+	vars := []interface{}{userID, token.HashRecoveryCode(code)}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return numOfAffectedRows == 1, nil
+}
+
+// GenerateMFAChallenge creates a new MFA challenge for the given user and stores its hash.
+func GenerateMFAChallenge(db *sql.DB, user *token.User, device string, lifetime time.Duration) (string, error) {
+
+	challenge, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO mfa_challenges(`mfa_challenge_hash`, `mfa_challenge_user`, `mfa_challenge_device`, `mfa_challenge_expiresat`) VALUES (?, ?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars := []interface{}{token.HashOpaqueToken(challenge), user.ID, device, int(lifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new mfa challenge without mysql error")
+	}
+	return challenge, nil
+}
+
+// GetMFAChallenge returns the ID of the challenge, the ID of the user and the device of the given MFA challenge.
+// It returns sql.ErrNoRows if the challenge is unknown, expired, was already completed or had too many wrong codes.
+func GetMFAChallenge(db *sql.DB, challenge string) (int, string, string, error) {
+
+	query := "SELECT `mfa_challenge_id`, `mfa_challenge_user`, `mfa_challenge_device` FROM mfa_challenges WHERE `mfa_challenge_hash`=? AND `mfa_challenge_usedat` IS NULL AND `mfa_challenge_attempts` < ? AND `mfa_challenge_expiresat` > current_timestamp();"
+	vars := []interface{}{token.HashOpaqueToken(challenge), maxMFAChallengeAttempts}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, "", "", sql.ErrNoRows
+	}
+	var challengeID int
+	var userID, device string
+	err = rows.Scan(&challengeID, &userID, &device)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return challengeID, userID, device, nil
+}
+
+// FailMFAChallenge counts a wrong code for the MFA challenge.
+func FailMFAChallenge(db *sql.DB, challengeID int) error {
+
+	query := "UPDATE mfa_challenges SET `mfa_challenge_attempts`=`mfa_challenge_attempts`+1 WHERE `mfa_challenge_id`=?;"
+	vars := []interface{}{challengeID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// UseMFAChallenge marks the MFA challenge as completed, it returns sql.ErrNoRows if it was completed before.
+func UseMFAChallenge(db *sql.DB, challengeID int) error {
+
+	// the update only succeeds once, so concurrent requests can not complete the same challenge
+	query := "UPDATE mfa_challenges SET `mfa_challenge_usedat`=current_timestamp() WHERE `mfa_challenge_id`=? AND `mfa_challenge_usedat` IS NULL;"
+	vars := []interface{}{challengeID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RemoveExpiredMFAChallenges deletes all expired MFA challenges.
+func RemoveExpiredMFAChallenges(db *sql.DB) error {
+
+	query := "DELETE FROM mfa_challenges WHERE `mfa_challenge_expiresat` <= current_timestamp();"
+	vars := []interface{}{}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// oauthClientQuery selects the OAuth clients together with the credentials of their API keys.
+const oauthClientQuery = "SELECT oauth_clients.*, `api_key_prefix`, `api_key_hash`, `api_key_enabled` AND (`api_key_expiresat` IS NULL OR `api_key_expiresat` > current_timestamp()) FROM oauth_clients JOIN api_keys ON `api_key_id`=`oauth_client_api_key`"
+
+func GetAllOAuthClients(db *sql.DB) ([]token.OAuthClient, error) {
+
+	query := oauthClientQuery + ";"
+	vars := []interface{}{}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []token.OAuthClient{}
+	for rows.Next() {
+		client, err := oauthClientScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// GetOAuthClient returns the OAuth client with the given API key ID or sql.ErrNoRows if there is no such client.
+func GetOAuthClient(db *sql.DB, apiKeyID string) (*token.OAuthClient, error) {
+	return getOAuthClient(db, oauthClientQuery+" WHERE `oauth_client_api_key`=?;", apiKeyID)
+}
+
+// GetOAuthClientByClientID returns the OAuth client with the given client ID or sql.ErrNoRows if there is no such client.
+func GetOAuthClientByClientID(db *sql.DB, clientID string) (*token.OAuthClient, error) {
+	return getOAuthClient(db, oauthClientQuery+" WHERE `api_key_prefix`=?;", clientID)
+}
+
+func getOAuthClient(db *sql.DB, query string, value string) (*token.OAuthClient, error) {
+
+	vars := []interface{}{value}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	client, err := oauthClientScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// AddOAuthClient stores the API key of the client together with the client and returns the ID of the API key.
+func AddOAuthClient(db *sql.DB, key token.APIKey, client token.OAuthClient) (int, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO api_keys(`api_key_prefix`, `api_key_hash`, `api_key_comment`, `api_key_enabled`, `api_key_createdby`, `api_key_expiresat`, `api_key_scopes`, `api_key_origin`, `api_key_ratelimit`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		key.Prefix, key.Hash, key.Comment, key.Enabled, key.CreatedBy, key.ExpiresAt, strings.Join(key.Scopes, ","), key.Origin, key.RateLimit)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if insertID == 0 {
+		return 0, errors.New("failed to insert new API key without mysql error")
+	}
+
+	_, err = tx.Exec("INSERT INTO oauth_clients(`oauth_client_api_key`, `oauth_client_name`, `oauth_client_redirect_uris`, `oauth_client_confidential`, `oauth_client_trusted`, `oauth_client_audiences`) VALUES (?, ?, ?, ?, ?, ?);",
+		insertID, client.Name, strings.Join(client.RedirectURIs, " "), client.Confidential, client.Trusted, strings.Join(client.Audiences, " "))
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), tx.Commit()
+}
+
+// GetOAuthConsent returns the consent the user gave the client or sql.ErrNoRows if the user didn't consent yet.
+func GetOAuthConsent(db *sql.DB, userID string, clientID int) (*token.OAuthConsent, error) {
+
+	query := "SELECT oauth_consents.*, `oauth_client_name` FROM oauth_consents JOIN oauth_clients ON `oauth_client_api_key`=`oauth_consent_client` WHERE `oauth_consent_user`=? AND `oauth_consent_client`=?;"
+	vars := []interface{}{userID, clientID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	consent, err := oauthConsentScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// GetOAuthConsentsForUser returns the consents the user gave to clients.
+func GetOAuthConsentsForUser(db *sql.DB, userID string) ([]token.OAuthConsent, error) {
+
+	query := "SELECT oauth_consents.*, `oauth_client_name` FROM oauth_consents JOIN oauth_clients ON `oauth_client_api_key`=`oauth_consent_client` WHERE `oauth_consent_user`=?;"
+	vars := []interface{}{userID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	consents := []token.OAuthConsent{}
+	for rows.Next() {
+		consent, err := oauthConsentScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, nil
+}
+
+// SetOAuthConsent stores the scopes the user consented to for the client, previously granted scopes are replaced.
+func SetOAuthConsent(db *sql.DB, userID int, clientID int, scopes []string) error {
+
+	query := "INSERT INTO oauth_consents(`oauth_consent_user`, `oauth_consent_client`, `oauth_consent_scopes`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `oauth_consent_scopes`=VALUES(`oauth_consent_scopes`);"
+	vars := []interface{}{userID, clientID, strings.Join(scopes, " ")}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// RemoveOAuthConsent removes the consent the user gave the client, it returns sql.ErrNoRows if there is no such consent.
+func RemoveOAuthConsent(db *sql.DB, userID string, clientID string) error {
+
+	query := "DELETE FROM oauth_consents WHERE `oauth_consent_user`=? AND `oauth_consent_client`=?;"
+	vars := []interface{}{userID, clientID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GenerateOAuthCode creates a new authorization code for the authorization request the client can exchange for
+// tokens of the user and stores its hash.
+func GenerateOAuthCode(db *sql.DB, client *token.OAuthClient, user *token.User, request *token.OAuthAuthorizationRequest, scopes []string, lifetime time.Duration) (string, error) {
+
+	code, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO oauth_codes(`oauth_code_hash`, `oauth_code_client`, `oauth_code_user`, `oauth_code_redirect_uri`, `oauth_code_scopes`, `oauth_code_challenge`, `oauth_code_nonce`, `oauth_code_expiresat`) VALUES (?, ?, ?, ?, ?, ?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars := []interface{}{token.HashOpaqueToken(code), client.APIKeyID, user.ID, request.RedirectURI, strings.Join(scopes, " "), request.CodeChallenge, request.Nonce, int(lifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new authorization code without mysql error")
+	}
+	return code, nil
+}
+
+// GetOAuthCode returns the stored authorization code for the given raw code.
+func GetOAuthCode(db *sql.DB, code string) (*token.OAuthCode, error) {
+
+	query := "SELECT *, `oauth_code_expiresat` <= current_timestamp() FROM oauth_codes WHERE `oauth_code_hash`=?;"
+	vars := []interface{}{token.HashOpaqueToken(code)}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	oauthCode, err := oauthCodeScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &oauthCode, nil
+}
+
+// UseOAuthCode marks the authorization code as exchanged for the tokens of the given refresh token family.
+// It returns false if the code was exchanged before.
+func UseOAuthCode(db *sql.DB, codeID int, family string) (bool, error) {
+
+	// the update only succeeds once, so a code can not be exchanged twice
+	query := "UPDATE oauth_codes SET `oauth_code_usedat`=current_timestamp(), `oauth_code_family`=? WHERE `oauth_code_id`=? AND `oauth_code_usedat` IS NULL;"
+	vars := []interface{}{family, codeID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return numOfAffectedRows == 1, nil
+}
+
+// RemoveExpiredOAuthCodes deletes all expired authorization codes.
+func RemoveExpiredOAuthCodes(db *sql.DB) error {
+
+	query := "DELETE FROM oauth_codes WHERE `oauth_code_expiresat` <= current_timestamp();"
+	vars := []interface{}{}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// GetPasskeysForUser returns the passkeys of the given user.
+func GetPasskeysForUser(db *sql.DB, userID string) ([]token.Passkey, error) {
+
+	query := "SELECT * FROM passkeys WHERE `passkey_user`=? ORDER BY `passkey_id`;"
+	vars := []interface{}{userID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	passkeys := []token.Passkey{}
+	for rows.Next() {
+		passkey, err := passkeyScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+// AddPasskey stores the passkey and returns its ID. It fails if the credential was registered before.
+func AddPasskey(db *sql.DB, passkey *token.Passkey) (int, error) {
+
+	query := "INSERT INTO passkeys(`passkey_user`, `passkey_credential_id`, `passkey_public_key`, `passkey_attestation_type`, `passkey_aaguid`, `passkey_sign_count`, `passkey_transports`, `passkey_backup_eligible`, `passkey_backup_state`, `passkey_name`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	vars := []interface{}{passkey.UserID, passkey.CredentialID, passkey.PublicKey, passkey.AttestationType, passkey.AAGUID, passkey.SignCount, strings.Join(passkey.Transports, ","), passkey.BackupEligible, passkey.BackupState, passkey.Name}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if insertID == 0 {
+		return 0, errors.New("failed to insert new passkey without mysql error")
+	}
+	return int(insertID), nil
+}
+
+// UsePasskey stores the signature counter and backup state of a passkey after a login. It returns false if the
+// counter isn't greater than the stored one, because a cloned authenticator used the passkey in the meantime.
+// Authenticators that don't implement a counter always report zero.
+func UsePasskey(db *sql.DB, passkeyID int, signCount uint32, backupState bool) (bool, error) {
+
+	// the update only succeeds if the counter increased, so concurrent logins can not both pass the check
+	query := "UPDATE passkeys SET `passkey_sign_count`=?, `passkey_backup_state`=?, `passkey_lastusedat`=current_timestamp() WHERE `passkey_id`=? AND (`passkey_sign_count` < ? OR (`passkey_sign_count` = 0 AND ? = 0));"
+	vars := []interface{}{signCount, backupState, passkeyID, signCount, signCount}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return numOfAffectedRows == 1, nil
+}
+
+// DeletePasskey removes the passkey of the given user, it returns sql.ErrNoRows if the user has no such passkey.
+func DeletePasskey(db *sql.DB, userID string, passkeyID string) error {
+
+	query := "DELETE FROM passkeys WHERE `passkey_id`=? AND `passkey_user`=?;"
+	vars := []interface{}{passkeyID, userID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GeneratePasskeyChallenge stores the session of a started WebAuthn ceremony and returns the challenge token
+// the client sends back to finish it. Registrations belong to the given user, logins have no user.
+func GeneratePasskeyChallenge(db *sql.DB, userID *int, session *webauthn.SessionData, lifetime time.Duration) (string, error) {
+
+	challenge, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO passkey_challenges(`passkey_challenge_hash`, `passkey_challenge_user`, `passkey_challenge_session`, `passkey_challenge_expiresat`) VALUES (?, ?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars := []interface{}{token.HashOpaqueToken(challenge), userID, sessionData, int(lifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new passkey challenge without mysql error")
+	}
+	return challenge, nil
+}
+
+// UsePasskeyChallenge marks the challenge as used and returns the session of the ceremony. Registration challenges
+// are only returned for the user that started them, login challenges only if userID is empty. It returns
+// sql.ErrNoRows if the challenge is unknown, expired or was already used.
+func UsePasskeyChallenge(db *sql.DB, challenge string, userID string) (*webauthn.SessionData, error) {
+
+	query := "SELECT `passkey_challenge_id`, `passkey_challenge_session` FROM passkey_challenges WHERE `passkey_challenge_hash`=? AND `passkey_challenge_user` IS NULL AND `passkey_challenge_usedat` IS NULL AND `passkey_challenge_expiresat` > current_timestamp();"
+	vars := []interface{}{token.HashOpaqueToken(challenge)}
+	if userID != "" {
+		query = "SELECT `passkey_challenge_id`, `passkey_challenge_session` FROM passkey_challenges WHERE `passkey_challenge_hash`=? AND `passkey_challenge_user`=? AND `passkey_challenge_usedat` IS NULL AND `passkey_challenge_expiresat` > current_timestamp();"
+		vars = []interface{}{token.HashOpaqueToken(challenge), userID}
+	}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	var challengeID int
+	var sessionData []byte
+	err = rows.Scan(&challengeID, &sessionData)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// the update only succeeds once, so a challenge can only finish one ceremony
+	query = "UPDATE passkey_challenges SET `passkey_challenge_usedat`=current_timestamp() WHERE `passkey_challenge_id`=? AND `passkey_challenge_usedat` IS NULL;"
+	vars = []interface{}{challengeID}
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if numOfAffectedRows != 1 {
+		return nil, sql.ErrNoRows
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(sessionData, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RemoveExpiredPasskeyChallenges deletes all expired passkey challenges.
+func RemoveExpiredPasskeyChallenges(db *sql.DB) error {
+
+	query := "DELETE FROM passkey_challenges WHERE `passkey_challenge_expiresat` <= current_timestamp();"
+	vars := []interface{}{}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// GeneratePasswordResetToken creates a new password reset token for the given user and stores its hash.
+// Password reset tokens that were requested before for the user are removed.
+func GeneratePasswordResetToken(db *sql.DB, user *token.User, lifetime time.Duration) (string, error) {
+
+	resetToken, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := "DELETE FROM password_reset_tokens WHERE `password_reset_token_user`=?;"
+	vars := []interface{}{user.ID}
+	_, err = executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+
+	query = "INSERT INTO password_reset_tokens(`password_reset_token_hash`, `password_reset_token_user`, `password_reset_token_expiresat`) VALUES (?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars = []interface{}{token.HashOpaqueToken(resetToken), user.ID, int(lifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new password reset token without mysql error")
+	}
+	return resetToken, nil
+}
+
+// UsePasswordResetToken marks the given password reset token as used and returns the ID of its user.
+// It returns sql.ErrNoRows if the token is unknown, expired or was already used.
+func UsePasswordResetToken(db *sql.DB, resetToken string) (string, error) {
+
+	query := "SELECT `password_reset_token_id`, `password_reset_token_user` FROM password_reset_tokens WHERE `password_reset_token_hash`=? AND `password_reset_token_usedat` IS NULL AND `password_reset_token_expiresat` > current_timestamp();"
+	vars := []interface{}{token.HashOpaqueToken(resetToken)}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", sql.ErrNoRows
+	}
+	var tokenID int
+	var userID string
+	err = rows.Scan(&tokenID, &userID)
+	if err != nil {
+		return "", err
+	}
+
+	// the update only succeeds once, so concurrent requests can not use the same token
+	query = "UPDATE password_reset_tokens SET `password_reset_token_usedat`=current_timestamp() WHERE `password_reset_token_id`=? AND `password_reset_token_usedat` IS NULL;"
+	vars = []interface{}{tokenID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if numOfAffectedRows != 1 {
+		return "", sql.ErrNoRows
+	}
+	return userID, nil
+}
+
+// RemoveExpiredPasswordResetTokens deletes all expired password reset tokens.
+func RemoveExpiredPasswordResetTokens(db *sql.DB) error {
+
+	query := "DELETE FROM password_reset_tokens WHERE `password_reset_token_expiresat` <= current_timestamp();"
+	vars := []interface{}{}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
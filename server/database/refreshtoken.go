@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/google/uuid"
+)
+
+// GenerateRefreshToken creates a new refresh token for the given user and stores its hash.
+// If family is empty a new token family is started. Tokens of OAuth clients have the API key ID of the client
+// and the scopes the client was granted.
+func GenerateRefreshToken(user *token.User, family string, device string, clientID *int, scopes []string, db *sql.DB, auth *token.AuthService) (string, error) {
+
+	refreshToken, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	if family == "" {
+		family = uuid.New().String()
+	}
+
+	query := "INSERT INTO refresh_tokens(`refresh_token_hash`, `refresh_token_family`, `refresh_token_user`, `refresh_token_device`, `refresh_token_client`, `refresh_token_scopes`, `refresh_token_expiresat`) VALUES (?, ?, ?, ?, ?, ?, DATE_ADD(current_timestamp(), INTERVAL ? SECOND));"
+	vars := []interface{}{token.HashOpaqueToken(refreshToken), family, user.ID, device, clientID, strings.Join(scopes, " "), int(auth.RefreshLifetime.Seconds())}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return "", err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if insertID == 0 {
+		return "", errors.New("failed to insert new refresh token without mysql error")
+	}
+	return refreshToken, nil
+}
+
+// GetRefreshToken returns the stored refresh token for the given raw token.
+func GetRefreshToken(db *sql.DB, refreshToken string) (*token.RefreshToken, error) {
+
+	query := "SELECT *, `refresh_token_expiresat` <= current_timestamp() FROM refresh_tokens WHERE `refresh_token_hash`=?;"
+	vars := []interface{}{token.HashOpaqueToken(refreshToken)}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	key, err := refreshTokenScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// UseRefreshToken marks the given refresh token as rotated. It returns false if the token was already used,
+// which means the token was replayed.
+func UseRefreshToken(db *sql.DB, refreshTokenID int) (bool, error) {
+
+	query := "UPDATE refresh_tokens SET `refresh_token_usedat`=current_timestamp() WHERE `refresh_token_id`=? AND `refresh_token_usedat` IS NULL AND `refresh_token_revoked`=0;"
+	vars := []interface{}{refreshTokenID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return numOfAffectedRows == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes all refresh tokens that were rotated from the same login.
+func RevokeRefreshTokenFamily(db *sql.DB, family string) error {
+
+	query := "UPDATE refresh_tokens SET `refresh_token_revoked`=1 WHERE `refresh_token_family`=?;"
+	vars := []interface{}{family}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// RevokeRefreshTokensForUser revokes all refresh tokens of the given user.
+func RevokeRefreshTokensForUser(db *sql.DB, userID string) error {
+
+	query := "UPDATE refresh_tokens SET `refresh_token_revoked`=1 WHERE `refresh_token_user`=?;"
+	vars := []interface{}{userID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// RevokeRefreshTokensForClient revokes all refresh tokens the given user granted the OAuth client.
+func RevokeRefreshTokensForClient(db *sql.DB, userID string, clientID string) error {
+
+	query := "UPDATE refresh_tokens SET `refresh_token_revoked`=1 WHERE `refresh_token_user`=? AND `refresh_token_client`=?;"
+	vars := []interface{}{userID, clientID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// RemoveExpiredRefreshTokensForUser deletes the expired refresh tokens of the given user.
+func RemoveExpiredRefreshTokensForUser(db *sql.DB, user *token.User) error {
+
+	query := "DELETE FROM refresh_tokens WHERE `refresh_token_user`=? AND `refresh_token_expiresat` <= current_timestamp();"
+	vars := []interface{}{fmt.Sprint(user.ID)}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
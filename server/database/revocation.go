@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// RevokeAccessToken revokes the access token with the given claims until it expires.
+func RevokeAccessToken(db *sql.DB, claims *token.UserClaims) error {
+
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("failed to revoke access token: the token has no id or expiration date")
+	}
+
+	query := "INSERT IGNORE INTO revoked_tokens(`revoked_token_jti`, `revoked_token_user`, `revoked_token_expiresat`) VALUES (?, ?, ?);"
+	vars := []interface{}{claims.ID, claims.UserID, claims.ExpiresAt.Unix()}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// revokedSessionsBefore returns the cutoff of a session revocation, access tokens only carry their issue date in seconds
+// so the cutoff is the next second to also revoke tokens that were issued in the current second.
+func revokedSessionsBefore() int64 {
+	return time.Now().Unix() + 1
+}
+
+// RevokeSessionsForUser revokes all access tokens that were issued to the given user until now.
+func RevokeSessionsForUser(db *sql.DB, userID string) error {
+
+	query := "INSERT INTO revoked_sessions(`revoked_session_user`, `revoked_session_before`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `revoked_session_before`=VALUES(`revoked_session_before`);"
+	vars := []interface{}{userID, revokedSessionsBefore()}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// RevokeSessionsForRole revokes all access tokens that were issued to users with the given role until now.
+func RevokeSessionsForRole(db *sql.DB, roleID int) error {
+
+	query := "INSERT INTO revoked_sessions(`revoked_session_user`, `revoked_session_before`) SELECT `user_id`, ? FROM users WHERE `user_role`=? ON DUPLICATE KEY UPDATE `revoked_session_before`=VALUES(`revoked_session_before`);"
+	vars := []interface{}{revokedSessionsBefore(), roleID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// GetRevocationList returns all revocations that still affect unexpired access tokens.
+func GetRevocationList(db *sql.DB, auth *token.AuthService) (*token.RevocationList, error) {
+
+	now := time.Now()
+	list := token.NewRevocationList()
+
+	query := "SELECT `revoked_token_jti`, `revoked_token_expiresat` FROM revoked_tokens WHERE `revoked_token_expiresat` > ?;"
+	vars := []interface{}{now.Unix()}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jti string
+		var expiresAt int64
+		err = rows.Scan(&jti, &expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		list.Tokens[jti] = expiresAt
+	}
+
+	// access tokens issued before now minus their lifetime are expired anyway
+	query = "SELECT `revoked_session_user`, `revoked_session_before` FROM revoked_sessions WHERE `revoked_session_before` > ?;"
+	vars = []interface{}{now.Add(-auth.TokenLifetime).Unix()}
+
+	sessionRows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer sessionRows.Close()
+	for sessionRows.Next() {
+		var userID string
+		var before int64
+		err = sessionRows.Scan(&userID, &before)
+		if err != nil {
+			return nil, err
+		}
+		list.Sessions[userID] = before
+	}
+	return list, nil
+}
+
+// RemoveExpiredRevocations deletes all revocations of access tokens that are expired anyway.
+func RemoveExpiredRevocations(db *sql.DB) error {
+
+	query := "DELETE FROM revoked_tokens WHERE `revoked_token_expiresat` <= ?;"
+	vars := []interface{}{time.Now().Unix()}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
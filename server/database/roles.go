@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+// roleQuery selects the roles together with their comma separated permissions.
+const roleQuery = "SELECT roles.*, COALESCE(GROUP_CONCAT(`role_permission_name` ORDER BY `role_permission_name`), '') FROM roles LEFT JOIN role_permissions ON `role_permission_role`=`role_id`"
+
+func GetAllRoles(db *sql.DB) ([]token.Role, error) {
+
+	query := roleQuery + " GROUP BY `role_id` ORDER BY `role_id`;"
+	vars := []interface{}{}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []token.Role{}
+	for rows.Next() {
+		role, err := roleScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// GetRole returns the role with the given ID or sql.ErrNoRows if there is no such role.
+func GetRole(db *sql.DB, roleID string) (*token.Role, error) {
+
+	query := roleQuery + " WHERE `role_id`=? GROUP BY `role_id`;"
+	vars := []interface{}{roleID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	role, err := roleScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetPermissionsForRole returns the permissions of the role, unknown roles have no permissions.
+func GetPermissionsForRole(db *sql.DB, roleID int) ([]string, error) {
+
+	query := "SELECT `role_permission_name` FROM role_permissions WHERE `role_permission_role`=? ORDER BY `role_permission_name`;"
+	vars := []interface{}{roleID}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// SetRolePermissions replaces the permissions of the role with the given permissions.
+func SetRolePermissions(db *sql.DB, roleID int, permissions []string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE roles SET `role_updatedat`=current_timestamp() WHERE `role_id`=?;", roleID)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return errors.New("failed to update role without mysql error")
+	}
+
+	_, err = tx.Exec("DELETE FROM role_permissions WHERE `role_permission_role`=?;", roleID)
+	if err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		_, err = tx.Exec("INSERT IGNORE INTO role_permissions(`role_permission_role`, `role_permission_name`) VALUES (?, ?);", roleID, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
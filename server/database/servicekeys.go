@@ -3,13 +3,14 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 )
 
 func GetAllServiceKeys(db *sql.DB) ([]token.ServiceKey, error) {
 
-	query := "SELECT * FROM service_keys;"
+	query := "SELECT *, `service_key_expiresat` IS NOT NULL AND `service_key_expiresat` <= current_timestamp() FROM service_keys;"
 	vars := []interface{}{}
 
 	rows, err := executeRowQuery(db, query, vars)
@@ -27,43 +28,91 @@ func GetAllServiceKeys(db *sql.DB) ([]token.ServiceKey, error) {
 	return keys, nil
 }
 
-func AddServiceKey(db *sql.DB, key token.ServiceKey) error {
+func GetServiceKey(db *sql.DB, keyID string) (*token.ServiceKey, error) {
 
-	query := "INSERT INTO service_keys(`service_key`, `service_key_comment`) VALUES (?, ?);"
-	vars := []interface{}{key.Key, key.Comment}
+	query := "SELECT *, `service_key_expiresat` IS NOT NULL AND `service_key_expiresat` <= current_timestamp() FROM service_keys WHERE `service_key_id`=?;"
+	vars := []interface{}{keyID}
 
-	result, err := executeQuery(db, query, vars)
+	rows, err := executeRowQuery(db, query, vars)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	insertID, err := result.LastInsertId()
-	if err != nil {
-		return err
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
 	}
-	if insertID == 0 {
-		return errors.New("failed to insert new service key without mysql error")
+	key, err := serviceKeyScan(rows)
+	if err != nil {
+		return nil, err
 	}
+	return &key, nil
+}
 
-	return nil
+// GetServiceKeyByHash returns the service key with the given hash or sql.ErrNoRows if there is no such key.
+func GetServiceKeyByHash(db *sql.DB, hash string) (*token.ServiceKey, error) {
+
+	query := "SELECT *, `service_key_expiresat` IS NOT NULL AND `service_key_expiresat` <= current_timestamp() FROM service_keys WHERE `service_key_hash`=?;"
+	vars := []interface{}{hash}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	key, err := serviceKeyScan(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
 }
 
-func UpdateServiceKey(db *sql.DB, key token.ServiceKey) error {
+// AddServiceKey stores the given service key and returns its ID.
+func AddServiceKey(db *sql.DB, key token.ServiceKey) (int, error) {
 
-	query := "UPDATE service_keys SET `service_key`=?, `service_key_comment`=? WHERE `service_key_id`=?;"
-	vars := []interface{}{key.Key, key.Comment, key.ID}
+	query := "INSERT INTO service_keys(`service_key_prefix`, `service_key_hash`, `service_key_comment`, `service_key_enabled`, `service_key_createdby`, `service_key_expiresat`, `service_key_scopes`) VALUES (?, ?, ?, ?, ?, ?, ?);"
+	vars := []interface{}{key.Prefix, key.Hash, key.Comment, key.Enabled, key.CreatedBy, key.ExpiresAt, strings.Join(key.Scopes, ",")}
 
 	result, err := executeQuery(db, query, vars)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	numOfAffectedRows, err := result.RowsAffected()
+	insertID, err := result.LastInsertId()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if numOfAffectedRows != 1 {
-		return errors.New("failed to update service key without mysql error")
+	if insertID == 0 {
+		return 0, errors.New("failed to insert new service key without mysql error")
 	}
-	return nil
+	return int(insertID), nil
+}
+
+// UpdateServiceKey updates the comment, the enabled flag, the expiry date and the scopes of the given service key.
+func UpdateServiceKey(db *sql.DB, key token.ServiceKey) error {
+
+	query := "UPDATE service_keys SET `service_key_comment`=?, `service_key_enabled`=?, `service_key_expiresat`=?, `service_key_scopes`=? WHERE `service_key_id`=?;"
+	vars := []interface{}{key.Comment, key.Enabled, key.ExpiresAt, strings.Join(key.Scopes, ","), key.ID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
+// SetServiceKeysUsed sets the last used date of the given service keys to now.
+func SetServiceKeysUsed(db *sql.DB, keyIDs []int) error {
+
+	if len(keyIDs) == 0 {
+		return nil
+	}
+	query := "UPDATE service_keys SET `service_key_lastusedat`=current_timestamp() WHERE `service_key_id` IN (?" + strings.Repeat(", ?", len(keyIDs)-1) + ");"
+	vars := []interface{}{}
+	for _, keyID := range keyIDs {
+		vars = append(vars, keyID)
+	}
+
+	_, err := executeQuery(db, query, vars)
+	return err
 }
 
 func RemoveServiceKey(db *sql.DB, keyID string) error {
@@ -80,7 +129,38 @@ func RemoveServiceKey(db *sql.DB, keyID string) error {
 		return err
 	}
 	if numOfAffectedRows != 1 {
-		return errors.New("failed to delete service key without mysql error")
+		return errors.New("failed to remove service key without mysql error")
 	}
 	return nil
 }
+
+// HashPlaintextServiceKeys replaces the service keys that are still stored in plaintext with their hash.
+// Plaintext keys are the ones without a prefix, their first characters are kept as the prefix.
+func HashPlaintextServiceKeys(db *sql.DB) (int, error) {
+
+	query := "SELECT *, false FROM service_keys WHERE `service_key_prefix`='';"
+	vars := []interface{}{}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return 0, err
+	}
+	keys := []token.ServiceKey{}
+	for rows.Next() {
+		key, err := serviceKeyScan(rows)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		query = "UPDATE service_keys SET `service_key_prefix`=?, `service_key_hash`=? WHERE `service_key_id`=? AND `service_key_prefix`='';"
+		vars = []interface{}{token.LegacyKeyPrefix(key.Hash), token.HashKey(key.Hash), key.ID}
+		_, err = executeQuery(db, query, vars)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
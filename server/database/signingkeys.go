@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+func GetAllSigningKeys(db *sql.DB) ([]token.SigningKey, error) {
+
+	query := "SELECT * FROM signing_keys;"
+	vars := []interface{}{}
+
+	rows, err := executeRowQuery(db, query, vars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []token.SigningKey{}
+	for rows.Next() {
+		key, err := signingKeyScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func AddSigningKey(db *sql.DB, key *token.SigningKey) error {
+
+	query := "INSERT INTO signing_keys(`signing_key_id`, `signing_key_algorithm`, `signing_key_state`, `signing_key_private`, `signing_key_public`) VALUES (?, ?, ?, ?, ?);"
+	vars := []interface{}{key.ID, key.Algorithm, key.State, key.PrivateKey, key.PublicKey}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return errors.New("failed to insert new signing key without mysql error")
+	}
+	return nil
+}
+
+// ErrSigningKeyNotPublished is returned when a next key is promoted before it was published long enough.
+var ErrSigningKeyNotPublished = errors.New("the signing key was not published long enough to be promoted")
+
+// PromoteSigningKey makes the given key the active signing key, the currently active key becomes a previous key.
+// Next keys need to exist for the given publication time, so every cached key set contains them before they sign tokens.
+func PromoteSigningKey(db *sql.DB, keyID string, publication time.Duration) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE signing_keys SET `signing_key_state`=? WHERE `signing_key_state`=?;", token.KeyStatePrevious, token.KeyStateActive)
+	if err != nil {
+		return err
+	}
+	_, err = result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	result, err = tx.Exec("UPDATE signing_keys SET `signing_key_state`=? WHERE `signing_key_id`=? AND (`signing_key_state`=? OR (`signing_key_state`=? AND `signing_key_createdat` <= DATE_SUB(current_timestamp(), INTERVAL ? SECOND)));",
+		token.KeyStateActive, keyID, token.KeyStatePrevious, token.KeyStateNext, int(publication.Seconds()))
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		var unpublished int
+		err = tx.QueryRow("SELECT COUNT(*) FROM signing_keys WHERE `signing_key_id`=? AND `signing_key_state`=?;", keyID, token.KeyStateNext).Scan(&unpublished)
+		if err != nil {
+			return err
+		}
+		if unpublished > 0 {
+			return ErrSigningKeyNotPublished
+		}
+		return errors.New("failed to promote signing key: the key does not exist or is retired")
+	}
+	return tx.Commit()
+}
+
+// RetireSigningKey retires the given key, tokens signed with a retired key are no longer valid.
+func RetireSigningKey(db *sql.DB, keyID string) error {
+
+	query := "UPDATE signing_keys SET `signing_key_state`=? WHERE `signing_key_id`=? AND `signing_key_state` IN (?, ?);"
+	vars := []interface{}{token.KeyStateRetired, keyID, token.KeyStateNext, token.KeyStatePrevious}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numOfAffectedRows != 1 {
+		return errors.New("failed to retire signing key: the key does not exist, is active or already retired")
+	}
+	return nil
+}
@@ -2,13 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 )
 
 func GetAllUserSummaries(db *sql.DB) ([]*token.UserSummary, error) {
 
-	query := "SELECT user_id, user_email, user_createdat, user_updatedat, user_role FROM users;"
+	query := "SELECT user_id, user_email, user_createdat, user_updatedat, user_role, user_suspended, user_suspended_reason, user_suspended_by, user_suspended_at, user_verified, user_verified_at FROM users;"
 	vars := []any{}
 
 	rows, err := executeRowQuery(db, query, vars)
@@ -27,8 +28,14 @@ func GetAllUserSummaries(db *sql.DB) ([]*token.UserSummary, error) {
 	return keys, nil
 }
 
+// GetUserByEmail returns the user with the given email, the email is normalized so the lookup is case-insensitive.
 func GetUserByEmail(db *sql.DB, email string) (*token.User, error) {
 
+	email, err := token.NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
 	query := "SELECT * FROM users WHERE `user_email`=?;"
 	vars := []interface{}{email}
 
@@ -63,6 +70,11 @@ func GetUserByID(db *sql.DB, userID string) (*token.User, error) {
 
 func CreateUserWithEmailAndPasswordHash(db *sql.DB, email string, passwordhash string) (bool, error) {
 
+	email, err := token.NormalizeEmail(email)
+	if err != nil {
+		return false, err
+	}
+
 	query := "INSERT INTO `users`(`user_email`, `user_password`, `user_role`) VALUES (?, ?, ?);"
 	vars := []interface{}{email, passwordhash, token.CREATOR}
 
@@ -98,6 +110,17 @@ func SetPasswordForUser(db *sql.DB, userID string, newpasswordhash string) (bool
 	return true, nil
 }
 
+// UpgradePasswordHash replaces the password hash of the user with a hash of the same password made with the
+// current algorithm or parameters. The hash is only replaced if the password wasn't changed in the meantime.
+func UpgradePasswordHash(db *sql.DB, userID string, oldpasswordhash string, newpasswordhash string) error {
+
+	query := "UPDATE `users` SET `user_password`=? WHERE `user_id`=? AND `user_password`=?;"
+	vars := []interface{}{newpasswordhash, userID, oldpasswordhash}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
 func SetRoleForUser(db *sql.DB, userID string, newUserRole int) (bool, error) {
 
 	query := "UPDATE `users` SET `user_role`=? WHERE `user_id`=?;"
@@ -117,6 +140,54 @@ func SetRoleForUser(db *sql.DB, userID string, newUserRole int) (bool, error) {
 	return true, nil
 }
 
+func SuspendUser(db *sql.DB, userID string, reason string, suspendedBy string) (bool, error) {
+
+	query := "UPDATE `users` SET `user_suspended`=1, `user_suspended_reason`=?, `user_suspended_by`=?, `user_suspended_at`=current_timestamp() WHERE `user_id`=?;"
+	vars := []interface{}{reason, suspendedBy, userID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if numOfAffectedRows != 1 {
+		return false, errors.New("failed to suspend user without mysql error")
+	}
+	return true, nil
+}
+
+func UnsuspendUser(db *sql.DB, userID string) (bool, error) {
+
+	query := "UPDATE `users` SET `user_suspended`=0, `user_suspended_reason`='', `user_suspended_by`=NULL, `user_suspended_at`=NULL WHERE `user_id`=?;"
+	vars := []interface{}{userID}
+
+	result, err := executeQuery(db, query, vars)
+	if err != nil {
+		return false, err
+	}
+	numOfAffectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if numOfAffectedRows != 1 {
+		return false, errors.New("failed to unsuspend user without mysql error")
+	}
+	return true, nil
+}
+
+// VerifyUser marks the email of the given user as verified, users that are already verified keep their verification date.
+func VerifyUser(db *sql.DB, userID string) error {
+
+	query := "UPDATE `users` SET `user_verified`=1, `user_verified_at`=current_timestamp() WHERE `user_id`=? AND `user_verified`=0;"
+	vars := []interface{}{userID}
+
+	_, err := executeQuery(db, query, vars)
+	return err
+}
+
 func GetEntitiesForUser(entity Entity, db *sql.DB, userID string) ([]int, error) {
 
 	query := "SELECT `associated_" + string(entity) + "` FROM map_" + string(entity) + "_user WHERE `associated_user`=?;"
@@ -2,10 +2,10 @@ package handler
 
 import (
 	"database/sql"
-	"encoding/json"
-	"io"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 	"github.com/Festivals-App/festivals-identity-server/server/database"
@@ -13,6 +13,20 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// apiKeyChanges are the fields of an API key that can be set by admins.
+type apiKeyChanges struct {
+	Comment   string     `json:"api_key_comment"`
+	Enabled   bool       `json:"api_key_enabled"`
+	ExpiresAt *time.Time `json:"api_key_expiresat"`
+	Scopes    []string   `json:"api_key_scopes"`
+	Origin    string     `json:"api_key_origin"`
+	RateLimit int        `json:"api_key_ratelimit"`
+}
+
+func (changes *apiKeyChanges) valid() bool {
+	return changes.Comment != "" && changes.Scopes != nil && token.ValidAPIKeyScopes(changes.Scopes) && len(changes.Origin) <= 255 && changes.RateLimit >= 0
+}
+
 func GetAPIKeys(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	keys, err := database.GetAllAPIKeys(db)
 	if err != nil {
@@ -20,131 +34,159 @@ func GetAPIKeys(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *h
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
-	servertools.RespondJSON(w, http.StatusOK, keys)
+	respondJSONWithETag(w, r, keys)
 }
 
-func AddAPIKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func GetAPIKey(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to create API keys.")
-		servertools.UnauthorizedResponse(w)
+	keyID, err := objectID(r)
+	if err != nil || keyID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to read request body.")
-		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	key, err := database.GetAPIKey(db, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 		return
 	}
-	var apikeyValue map[string]string
-	err = json.Unmarshal(body, &apikeyValue)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		log.Error().Err(err).Msg("Failed to fetch API key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
+	servertools.RespondJSON(w, http.StatusOK, key)
+}
 
-	newAPIKey := apikeyValue["api_key"]
-	if newAPIKey == "" {
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+func AddAPIKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to create API keys.")
+		servertools.UnauthorizedResponse(w)
 		return
 	}
 
-	newAPIKeyComment := apikeyValue["api_key_comment"]
-	if newAPIKeyComment == "" {
+	changes := apiKeyChanges{Enabled: true, Scopes: token.APIKeyScopes}
+	err := decodeChanges(r, &changes)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
-
-	apiKey := token.APIKey{
-		ID:      0,
-		Key:     newAPIKey,
-		Comment: newAPIKeyComment,
+	if !changes.valid() {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
 	}
 
-	err = database.AddAPIKey(db, apiKey)
+	creatorID, err := strconv.Atoi(claims.UserID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to add api key.")
+		log.Error().Err(err).Msg("Failed to read user ID from claims.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	servertools.RespondCode(w, http.StatusCreated)
-}
-
-func UpdateAPIKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
-
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to update API keys.")
-		servertools.UnauthorizedResponse(w)
+	apiKey, err := token.NewAPIKey(changes.Comment)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate api key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
+	apiKey.Enabled = changes.Enabled
+	apiKey.ExpiresAt = changes.ExpiresAt
+	apiKey.Scopes = changes.Scopes
+	apiKey.Origin = changes.Origin
+	apiKey.RateLimit = changes.RateLimit
+	apiKey.CreatedBy = &creatorID
 
-	body, err := io.ReadAll(r.Body)
+	apiKeyID, err := database.AddAPIKey(db, *apiKey)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to read request body.")
+		log.Error().Err(err).Msg("Failed to add api key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
-	var apikeyValue map[string]string
-	err = json.Unmarshal(body, &apikeyValue)
+
+	createdKey, err := database.GetAPIKey(db, strconv.Itoa(apiKeyID))
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		log.Error().Err(err).Msg("Failed to fetch created api key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	newAPIKey := apikeyValue["api_key"]
-	if newAPIKey == "" {
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	// the key is only returned once, afterwards only its prefix and hash are known
+	createdKey.Key = apiKey.Key
+	servertools.RespondJSON(w, http.StatusCreated, createdKey)
+}
+
+func UpdateAPIKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to update API keys.")
+		servertools.UnauthorizedResponse(w)
 		return
 	}
 
-	newAPIKeyComment := apikeyValue["api_key_comment"]
-	if newAPIKeyComment == "" {
+	keyID, err := objectID(r)
+	if err != nil || keyID == "" {
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
 
-	keyIDString, err := objectID(r)
+	apiKey, err := database.GetAPIKey(db, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
 	if err != nil {
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		log.Error().Err(err).Msg("Failed to fetch API key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	id, err := strconv.Atoi(keyIDString)
+	// fields missing in the request body keep their current value
+	changes := apiKeyChanges{Comment: apiKey.Comment, Enabled: apiKey.Enabled, ExpiresAt: apiKey.ExpiresAt, Scopes: apiKey.Scopes, Origin: apiKey.Origin, RateLimit: apiKey.RateLimit}
+	err = decodeChanges(r, &changes)
 	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
-
-	apiKey := token.APIKey{
-		ID:      id,
-		Key:     newAPIKey,
-		Comment: newAPIKeyComment,
+	if !changes.valid() {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
 	}
+	apiKey.Comment = changes.Comment
+	apiKey.Enabled = changes.Enabled
+	apiKey.ExpiresAt = changes.ExpiresAt
+	apiKey.Scopes = changes.Scopes
+	apiKey.Origin = changes.Origin
+	apiKey.RateLimit = changes.RateLimit
 
-	err = database.UpdateAPIKey(db, apiKey)
+	err = database.UpdateAPIKey(db, *apiKey)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to update api key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	servertools.RespondCode(w, http.StatusOK)
+	updatedKey, err := database.GetAPIKey(db, keyID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch updated api key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, updatedKey)
 }
 
 func DeleteAPIKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionKeysWrite) {
 		log.Error().Msg("User is not authorized to delete API keys.")
 		servertools.UnauthorizedResponse(w)
 		return
 	}
 
 	keyID, err := objectID(r)
-	if err != nil {
+	if err != nil || keyID == "" {
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
@@ -1,27 +1,78 @@
 package handler
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	servertools "github.com/Festivals-App/festivals-server-tools"
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
 )
 
-func validEmail(email string) bool {
+// ErrorAccountSuspended is returned to clients of suspended accounts so they can distinguish
+// a suspension from wrong credentials.
+const ErrorAccountSuspended = "account suspended"
 
-	if len(email) < 6 {
+// ErrorEmailNotVerified is returned to clients of users that did not verify their email yet.
+const ErrorEmailNotVerified = "email not verified"
+
+// ErrorInvalidEmail is returned if the email is not a valid email address.
+const ErrorInvalidEmail = "invalid email"
+
+// ErrorEmailDomainNotAllowed is returned if the domain of the email is on the email domain blocklist.
+const ErrorEmailDomainNotAllowed = "email domain not allowed"
+
+// ErrorPasswordPolicy is returned together with the violated rules if a password violates the password policy.
+const ErrorPasswordPolicy = "password policy violated"
+
+// checkPassword checks the password against the password policy and responds with the violated rules if the
+// password is rejected. The user inputs make the password weaker if the password contains them.
+func checkPassword(w http.ResponseWriter, policy *token.PasswordPolicy, password string, userInputs ...string) bool {
+
+	err := policy.Check(password, userInputs...)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *token.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		log.Error().Strs("rules", policyErr.Rules).Msg("Password violates the password policy.")
+		passwordPolicyResponse(w, policyErr.Rules)
 		return false
 	}
+	log.Error().Err(err).Msg("Failed to check password against the password policy.")
+	servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	return false
+}
 
-	return true
+// passwordUserInputs returns the parts of the email that make a password of the user easy to guess.
+func passwordUserInputs(email string) []string {
+	local, _, _ := strings.Cut(email, "@")
+	return []string{email, local}
 }
 
-func validPassword(password string) bool {
+// passwordPolicyResponse responds like servertools.RespondError but adds the rules the password violates.
+func passwordPolicyResponse(w http.ResponseWriter, rules []string) {
 
-	if len(password) < 8 {
-		return false
+	response, err := json.Marshal(map[string]interface{}{"error": ErrorPasswordPolicy, "rules": rules})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal payload")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, err = w.Write(response)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write response")
 	}
-
-	return true
 }
 
 func objectID(r *http.Request) (string, error) {
@@ -31,3 +82,65 @@ func objectID(r *http.Request) (string, error) {
 func resourceID(r *http.Request) (string, error) {
 	return chi.URLParam(r, "resourceID"), nil
 }
+
+func suspendedResponse(w http.ResponseWriter) {
+	servertools.RespondError(w, http.StatusForbidden, ErrorAccountSuspended)
+}
+
+func unverifiedResponse(w http.ResponseWriter) {
+	servertools.RespondError(w, http.StatusForbidden, ErrorEmailNotVerified)
+}
+
+// respondJSONWithETag makes the same response as servertools.RespondJSON but adds an ETag header
+// and answers with 304 Not Modified if the client already has the current payload.
+func respondJSONWithETag(w http.ResponseWriter, r *http.Request, payload interface{}) {
+
+	response, err := json.Marshal(map[string]interface{}{"data": payload})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal payload")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	respondWithETag(w, r, "application/json", response)
+}
+
+func respondWithETag(w http.ResponseWriter, r *http.Request, contentType string, response []byte) {
+
+	sum := sha256.Sum256(response)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(response)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeChanges decodes the request body into the given changes, unknown fields like the key itself are rejected.
+func decodeChanges(r *http.Request, changes interface{}) error {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(changes)
+}
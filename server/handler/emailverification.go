@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	"github.com/Festivals-App/festivals-identity-server/server/mail"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+func sendEmailVerification(db *sql.DB, mailer mail.Mailer, email string, verificationURL string, lifetime time.Duration) {
+
+	err := database.RemoveExpiredEmailVerificationTokens(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired email verification tokens.")
+	}
+
+	user, err := database.GetUserByEmail(db, email)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user to verify.")
+		return
+	}
+	if user.Verified {
+		return
+	}
+
+	verificationToken, err := database.GenerateEmailVerificationToken(db, user, lifetime)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate email verification token.")
+		return
+	}
+
+	err = mailer.Send(&mail.Message{
+		To:      user.Email,
+		Subject: "Verify your FestivalsApp email",
+		Body: "Hello,\n\nplease verify the email of your new FestivalsApp account with the following link,\n" +
+			"it is valid for " + lifetime.String() + ":\n\n" +
+			verificationURL + verificationToken + "\n\n" +
+			"If you did not sign up for FestivalsApp you can ignore this email.\n",
+	})
+	if err != nil {
+		log.Error().Err(err).Int("user", user.ID).Msg("Failed to send email verification email.")
+		return
+	}
+	log.Info().Int("user", user.ID).Msg("Sent email verification email.")
+}
+
+// VerifyEmail verifies the email of the user the email verification token was issued to.
+func VerifyEmail(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read request body.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	var verificationVars map[string]string
+	err = json.Unmarshal(body, &verificationVars)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	verificationToken := verificationVars["token"]
+	if verificationToken == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	userID, err := database.UseEmailVerificationToken(db, verificationToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Error().Msg("Email verification token is invalid, expired or was already used.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to use email verification token.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	err = database.VerifyUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to verify user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Msg("User verified email.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+// ResendEmailVerification returns a handler that lets admins send a new verification email to an unverified user.
+func ResendEmailVerification(mailer mail.Mailer, verificationURL string, lifetime time.Duration) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		if !claims.HasPermission(token.PermissionUsersWrite) {
+			log.Error().Msg("User is not authorized to resend verification emails.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+
+		userID, err := objectID(r)
+		if err != nil || userID == "" {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		requestedUser, err := database.GetUserByID(db, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch user.")
+			servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+		if requestedUser.Verified {
+			servertools.RespondError(w, http.StatusBadRequest, "email already verified")
+			return
+		}
+
+		go sendEmailVerification(db, mailer, requestedUser.Email, verificationURL, lifetime)
+
+		servertools.RespondCode(w, http.StatusAccepted)
+	}
+}
+
+// ForceVerifyEmail lets admins verify the email of a user without a verification token.
+func ForceVerifyEmail(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to verify users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	_, err = database.GetUserByID(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	err = database.VerifyUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to verify user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("admin", claims.UserID).Msg("Admin verified email of user.")
+	servertools.RespondCode(w, http.StatusOK)
+}
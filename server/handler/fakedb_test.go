@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDatabase answers the queries of the handlers under test without a MySQL server. Tests register an answer for
+// the beginning of every query they expect, queries without an answer fail the test.
+type fakeDatabase struct {
+	t       *testing.T
+	mutex   sync.Mutex
+	answers []fakeAnswer
+	queries []string
+}
+
+type fakeAnswer struct {
+	prefix  string
+	respond func(args []driver.Value) (fakeResult, error)
+}
+
+// fakeResult holds the rows of a query or the result of a command.
+type fakeResult struct {
+	rows         [][]driver.Value
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func newFakeDatabase(t *testing.T) *fakeDatabase {
+	return &fakeDatabase{t: t}
+}
+
+// on answers every query that starts with the given prefix.
+func (db *fakeDatabase) on(prefix string, respond func(args []driver.Value) (fakeResult, error)) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.answers = append(db.answers, fakeAnswer{prefix: prefix, respond: respond})
+}
+
+// open returns a database handle that sends all queries to the fake database.
+func (db *fakeDatabase) open() *sql.DB {
+	handle := sql.OpenDB(fakeConnector{db: db})
+	db.t.Cleanup(func() { handle.Close() })
+	return handle
+}
+
+// executed returns true if a query that starts with the given prefix was executed.
+func (db *fakeDatabase) executed(prefix string) bool {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for _, query := range db.queries {
+		if strings.HasPrefix(query, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *fakeDatabase) answer(query string, args []driver.Value) (fakeResult, error) {
+	db.mutex.Lock()
+	db.queries = append(db.queries, query)
+	var respond func(args []driver.Value) (fakeResult, error)
+	for _, answer := range db.answers {
+		if strings.HasPrefix(query, answer.prefix) {
+			respond = answer.respond
+			break
+		}
+	}
+	db.mutex.Unlock()
+
+	if respond == nil {
+		db.t.Errorf("unexpected query: %s", query)
+		return fakeResult{}, errors.New("unexpected query")
+	}
+	return respond(args)
+}
+
+type fakeConnector struct {
+	db *fakeDatabase
+}
+
+func (connector fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return fakeConn{db: connector.db}, nil
+}
+
+func (connector fakeConnector) Driver() driver.Driver {
+	return fakeDriver{db: connector.db}
+}
+
+type fakeDriver struct {
+	db *fakeDatabase
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{db: d.db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDatabase
+}
+
+func (conn fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: conn.db, query: query}, nil
+}
+
+func (conn fakeConn) Close() error {
+	return nil
+}
+
+func (conn fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error {
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDatabase
+	query string
+}
+
+func (stmt fakeStmt) Close() error {
+	return nil
+}
+
+func (stmt fakeStmt) NumInput() int {
+	return -1
+}
+
+func (stmt fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result, err := stmt.db.answer(stmt.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return fakeExecResult(result), nil
+}
+
+func (stmt fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	result, err := stmt.db.answer(stmt.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: result.rows}, nil
+}
+
+type fakeExecResult fakeResult
+
+func (result fakeExecResult) LastInsertId() (int64, error) {
+	return result.lastInsertID, nil
+}
+
+func (result fakeExecResult) RowsAffected() (int64, error) {
+	return result.rowsAffected, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+// Columns only returns the number of columns, the queries of the database package scan them by position.
+func (rows *fakeRows) Columns() []string {
+	if len(rows.rows) == 0 {
+		return []string{}
+	}
+	return make([]string, len(rows.rows[0]))
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if rows.next >= len(rows.rows) {
+		return io.EOF
+	}
+	copy(dest, rows.rows[rows.next])
+	rows.next++
+	return nil
+}
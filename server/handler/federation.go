@@ -0,0 +1,396 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrorAccountExists is returned if a user logs in with an external provider for the first time and another user
+// already has the email, the user needs to login and link the provider to the account instead.
+const ErrorAccountExists = "account exists"
+
+// federatedLoginRequest is the body clients send to start a login with an external provider and, after the provider
+// redirected the user back, to finish it with the state and the code the provider returned.
+type federatedLoginRequest struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Code     string `json:"code"`
+}
+
+func readFederatedLoginRequest(r *http.Request) (*federatedLoginRequest, error) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var request federatedLoginRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// GetFederationProviders returns a handler that responds with the external providers users can login with.
+func GetFederationProviders(federation *token.Federation) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		servertools.RespondJSON(w, http.StatusOK, federation.Providers)
+	}
+}
+
+// startFederatedLogin responds with the authorization URL of the provider for a new login,
+// logins that link the identity to an existing user belong to the given user.
+func startFederatedLogin(db *sql.DB, federation *token.Federation, w http.ResponseWriter, r *http.Request, userID *int) {
+
+	request, err := readFederatedLoginRequest(r)
+	if err != nil {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	provider := federation.Provider(request.Provider)
+	if provider == nil {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	err = database.RemoveExpiredFederatedLogins(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired federated logins.")
+	}
+
+	nonce, err := token.NewOpaqueToken()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate nonce.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	codeVerifier, codeChallenge, err := token.NewCodeVerifier()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate code verifier.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	state, err := database.GenerateFederatedLogin(db, provider.ID, userID, nonce, codeVerifier, federation.ChallengeLifetime)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate federated login.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	authorizationURL, err := provider.AuthorizationURL(federation.RedirectURL, state, nonce, codeChallenge)
+	if err != nil {
+		log.Error().Err(err).Str("provider", provider.ID).Msg("Failed to discover federation provider.")
+		servertools.RespondError(w, http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, token.FederatedLoginChallenge{State: state, AuthorizationURL: authorizationURL})
+}
+
+// finishFederatedLogin exchanges the code of the started login for the identity of the user at the provider.
+// It responds with an error and returns nil if the login can't be finished.
+func finishFederatedLogin(db *sql.DB, federation *token.Federation, w http.ResponseWriter, request *federatedLoginRequest, userID string) *token.FederatedIdentity {
+
+	if request.State == "" || request.Code == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return nil
+	}
+
+	login, err := database.UseFederatedLogin(db, request.State, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Error().Msg("Federated login is invalid, expired or was already used.")
+		servertools.UnauthorizedResponse(w)
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch federated login.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return nil
+	}
+	provider := federation.Provider(login.Provider)
+	if provider == nil {
+		log.Error().Str("provider", login.Provider).Msg("Federation provider of the login is no longer configured.")
+		servertools.UnauthorizedResponse(w)
+		return nil
+	}
+
+	identity, err := provider.Exchange(request.Code, federation.RedirectURL, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Error().Err(err).Str("provider", provider.ID).Msg("Failed to verify federated login.")
+		servertools.UnauthorizedResponse(w)
+		return nil
+	}
+	return identity
+}
+
+// StartFederatedLogin returns a handler that starts a login with an external provider.
+func StartFederatedLogin(federation *token.Federation) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		startFederatedLogin(db, federation, w, r, nil)
+	}
+}
+
+// FinishFederatedLogin returns a handler that finishes a login with an external provider and responds with the same
+// tokens as a password login. Users that login for the first time are provisioned as creators if the provider
+// verified their email, unless another user already has the email. Providers don't replace the second factor.
+func FinishFederatedLogin(federation *token.Federation, blocklist *token.EmailDomainBlocklist, throttle *token.LoginThrottle, mfa *token.MFAPolicy) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		request, err := readFederatedLoginRequest(r)
+		if err != nil {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		// codes of the providers can't be guessed, so only the lockout of the IP address applies
+		ip := throttle.ClientIP(r)
+		peer := token.GetPeerName(r)
+		delay, err := ipLoginDelay(db, throttle, ip)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch failed login attempts.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if delay > 0 {
+			log.Error().Str("ip", ip).Msg("Login is locked after too many failed attempts.")
+			tooManyLoginAttemptsResponse(w, delay)
+			return
+		}
+
+		identity := finishFederatedLogin(db, federation, w, request, "")
+		if identity == nil {
+			recordLoginAttempt(db, "", nil, ip, peer, false)
+			return
+		}
+
+		requestedUser, err := getFederatedUser(auth, db, blocklist, w, identity)
+		if requestedUser == nil {
+			if err != nil {
+				log.Error().Err(err).Str("provider", identity.Provider).Msg("Failed to fetch or provision user of federated login.")
+				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			}
+			return
+		}
+
+		if requestedUser.Suspended {
+			recordLoginAttempt(db, requestedUser.Email, requestedUser, ip, peer, true)
+			log.Error().Str("user", fmt.Sprint(requestedUser.ID)).Msg("Suspended user tried to login.")
+			suspendedResponse(w)
+			return
+		}
+		if !requestedUser.Verified {
+			recordLoginAttempt(db, requestedUser.Email, requestedUser, ip, peer, true)
+			log.Error().Str("user", fmt.Sprint(requestedUser.ID)).Msg("Unverified user tried to login.")
+			unverifiedResponse(w)
+			return
+		}
+		totp, err := getTOTP(db, fmt.Sprint(requestedUser.ID))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch TOTP enrollment of user.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		enrolled := totp != nil && totp.IsConfirmed()
+		if mfa.Required(requestedUser, enrolled) {
+			startMFAChallenge(db, mfa, w, requestedUser, !enrolled, token.GetDeviceName(r))
+			return
+		}
+		recordLoginAttempt(db, requestedUser.Email, requestedUser, ip, peer, true)
+		issueSession(auth, db, w, requestedUser, token.GetDeviceName(r))
+	}
+}
+
+// getFederatedUser returns the user the external identity is linked to or provisions a new user for it. It returns
+// nil and no error if it already responded because no user can be provisioned.
+func getFederatedUser(auth *token.AuthService, db *sql.DB, blocklist *token.EmailDomainBlocklist, w http.ResponseWriter, identity *token.FederatedIdentity) (*token.User, error) {
+
+	linkedIdentity, err := database.GetLinkedIdentity(db, identity.Provider, identity.Subject)
+	if err == nil {
+		err = database.UseLinkedIdentity(db, linkedIdentity.ID, identity.Email)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to update linked identity.")
+		}
+		return database.GetUserByID(db, strconv.Itoa(linkedIdentity.UserID))
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// only emails the provider verified can be used, otherwise anyone could take over the email
+	email, err := token.NormalizeEmail(identity.Email)
+	if err != nil || !identity.EmailVerified {
+		log.Error().Str("provider", identity.Provider).Msg("Federated login without a verified email can't provision a user.")
+		unverifiedResponse(w)
+		return nil, nil
+	}
+	if blocklist.Blocks(email) {
+		log.Error().Str("domain", token.EmailDomain(email)).Msg("Federated login with blocked email domain can't provision a user.")
+		servertools.RespondError(w, http.StatusBadRequest, ErrorEmailDomainNotAllowed)
+		return nil, nil
+	}
+
+	// users of federated logins have no password until they reset it
+	password, err := token.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := auth.PasswordHasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := database.CreateFederatedUser(db, email, passwordHash, identity)
+	if errors.Is(err, database.ErrEmailExists) {
+		log.Error().Str("provider", identity.Provider).Msg("Federated login with the email of an existing user that didn't link the provider.")
+		servertools.RespondError(w, http.StatusConflict, ErrorAccountExists)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Int("user", userID).Str("provider", identity.Provider).Msg("Provisioned user of federated login.")
+	return database.GetUserByID(db, strconv.Itoa(userID))
+}
+
+// StartIdentityLinking returns a handler that starts a login with an external provider to link the identity to the user.
+func StartIdentityLinking(federation *token.Federation) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		userID, err := objectID(r)
+		if err != nil || userID != claims.UserID {
+			log.Error().Msg("User is not authorized to link identities to other users.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		startFederatedLogin(db, federation, w, r, &id)
+	}
+}
+
+// FinishIdentityLinking returns a handler that finishes the login with an external provider and links the identity to the user.
+func FinishIdentityLinking(federation *token.Federation) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		userID, err := objectID(r)
+		if err != nil || userID != claims.UserID {
+			log.Error().Msg("User is not authorized to link identities to other users.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		request, err := readFederatedLoginRequest(r)
+		if err != nil {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		identity := finishFederatedLogin(db, federation, w, request, userID)
+		if identity == nil {
+			return
+		}
+
+		_, err = database.GetLinkedIdentity(db, identity.Provider, identity.Subject)
+		if err == nil {
+			log.Error().Str("user", userID).Str("provider", identity.Provider).Msg("Identity is already linked to a user.")
+			servertools.RespondError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("Failed to fetch linked identity.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		linkedIdentity := token.LinkedIdentity{UserID: id, Provider: identity.Provider, Subject: identity.Subject, Email: identity.Email}
+		linkedIdentity.ID, err = database.AddLinkedIdentity(db, id, identity)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to store linked identity.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		linkedIdentity.CreateDate = time.Now()
+
+		log.Info().Str("user", userID).Str("provider", identity.Provider).Msg("User linked an identity.")
+		servertools.RespondJSON(w, http.StatusCreated, linkedIdentity)
+	}
+}
+
+// GetLinkedIdentities returns the identities at external providers linked to the user, admins can get the linked
+// identities of every user.
+func GetLinkedIdentities(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersRead) {
+		log.Error().Msg("User is not authorized to get the linked identities of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	identities, err := database.GetLinkedIdentitiesForUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch linked identities.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, identities)
+}
+
+// DeleteLinkedIdentity unlinks an identity from the user, admins can unlink the identities of every user.
+// Users without a password can still login after unlinking their last identity by resetting their password.
+func DeleteLinkedIdentity(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to unlink the identities of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	identityID, err := resourceID(r)
+	if err != nil || identityID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	err = database.DeleteLinkedIdentity(db, userID, identityID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unlink identity.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("identity", identityID).Str("by", claims.UserID).Msg("Identity was unlinked.")
+	servertools.RespondCode(w, http.StatusOK)
+}
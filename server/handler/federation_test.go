@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testFederationClientID = "festivals-identity"
+	testFederationNonce    = "nonce-1"
+	testFederationVerifier = "verifier-1"
+	testFederationEmail    = "federated@festivalsapp.org"
+)
+
+// newFakeIdentityProvider starts an external OpenID Connect provider that answers every code with an ID token with the
+// given claims, it returns the provider configured as the only provider of the federation.
+func newFakeIdentityProvider(t *testing.T, claims func(issuer string) jwt.MapClaims) *token.Federation {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc(token.OpenIDConfigurationPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := token.NewJSONWebKey("key-1", token.AlgorithmES256, &key.PublicKey)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(token.JSONWebKeySet{Keys: []token.JSONWebKey{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code_verifier") != testFederationVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": token.OAuthErrorInvalidGrant})
+			return
+		}
+		idToken := jwt.NewWithClaims(jwt.SigningMethodES256, claims(issuer))
+		idToken.Header["kid"] = "key-1"
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer = server.URL
+
+	provider, err := token.NewFederationProvider("provider", "", issuer, testFederationClientID, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	federation, err := token.NewFederation("https://festivalsapp.org/login/federated", time.Minute, []*token.FederationProvider{provider})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return federation
+}
+
+// federatedIDTokenClaims returns the claims of a valid ID token of a user that never logged in before.
+func federatedIDTokenClaims(issuer string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            "subject-1",
+		"aud":            testFederationClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          testFederationNonce,
+		"email":          testFederationEmail,
+		"email_verified": true,
+	}
+}
+
+// federationTestDatabase answers the queries of a federated login of a user that isn't linked yet, the given number
+// of users already has the email of the user.
+func federationTestDatabase(t *testing.T, usersWithEmail int64) *fakeDatabase {
+
+	db := newFakeDatabase(t)
+	used := false
+	provisioned := false
+
+	db.on("SELECT COUNT(*), COALESCE", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{{int64(0), int64(0)}}}, nil
+	})
+	db.on("SELECT `federated_login_id`", func(args []driver.Value) (fakeResult, error) {
+		if used {
+			return fakeResult{}, nil
+		}
+		return fakeResult{rows: [][]driver.Value{{int64(1), "provider", nil, testFederationNonce, testFederationVerifier}}}, nil
+	})
+	db.on("UPDATE federated_logins", func(args []driver.Value) (fakeResult, error) {
+		used = true
+		return fakeResult{rowsAffected: 1}, nil
+	})
+	db.on("SELECT * FROM linked_identities", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("SELECT COUNT(*) FROM users", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{{usersWithEmail}}}, nil
+	})
+	db.on("INSERT INTO `users`", func(args []driver.Value) (fakeResult, error) {
+		provisioned = true
+		return fakeResult{lastInsertID: 8, rowsAffected: 1}, nil
+	})
+	db.on("INSERT INTO linked_identities", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	db.on("SELECT * FROM users WHERE `user_id`=?", func(args []driver.Value) (fakeResult, error) {
+		if !provisioned {
+			return fakeResult{}, nil
+		}
+		return fakeResult{rows: [][]driver.Value{testUserRow(8, testFederationEmail)}}, nil
+	})
+	db.on("SELECT * FROM totp_secrets", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("INSERT INTO login_attempts", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	db.on("UPDATE login_attempts", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("SELECT `associated_", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("SELECT `role_permission_name`", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("DELETE FROM refresh_tokens", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("INSERT INTO refresh_tokens", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	return db
+}
+
+func TestFinishFederatedLogin(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		claims         func(claims jwt.MapClaims)
+		usersWithEmail int64
+		status         int
+		message        string
+		provisioned    bool
+	}{
+		{name: "provisions user", claims: func(claims jwt.MapClaims) {}, status: http.StatusOK, provisioned: true},
+		{name: "wrong nonce", claims: func(claims jwt.MapClaims) { claims["nonce"] = "nonce-2" }, status: http.StatusUnauthorized},
+		{name: "wrong audience", claims: func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, status: http.StatusUnauthorized},
+		{name: "wrong authorized party", claims: func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testFederationClientID, "other-client"}
+			claims["azp"] = "other-client"
+		}, status: http.StatusUnauthorized},
+		{name: "unverified email", claims: func(claims jwt.MapClaims) { claims["email_verified"] = false }, status: http.StatusForbidden, message: ErrorEmailNotVerified},
+		{name: "account exists", claims: func(claims jwt.MapClaims) {}, usersWithEmail: 1, status: http.StatusConflict, message: ErrorAccountExists},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			federation := newFakeIdentityProvider(t, func(issuer string) jwt.MapClaims {
+				claims := federatedIDTokenClaims(issuer)
+				test.claims(claims)
+				return claims
+			})
+			fakeDB := federationTestDatabase(t, test.usersWithEmail)
+			throttle, err := token.NewLoginThrottle(5, 20, time.Second, time.Minute, time.Minute, nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := json.Marshal(federatedLoginRequest{State: "state-1", Code: "code-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			FinishFederatedLogin(federation, nil, throttle, &token.MFAPolicy{})(newTestAuthService(t), fakeDB.open(), w, httptest.NewRequest(http.MethodPost, "/users/login/federated", bytes.NewReader(body)))
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, w.Code, w.Body.String())
+			}
+			if test.message != "" && !strings.Contains(w.Body.String(), test.message) {
+				t.Fatalf("expected the error %q, got %s", test.message, w.Body.String())
+			}
+			if fakeDB.executed("INSERT INTO `users`") != test.provisioned {
+				t.Fatalf("expected the user to be provisioned: %t", test.provisioned)
+			}
+			if test.provisioned && w.Header().Get("Refresh-Token") == "" {
+				t.Fatal("expected a session for the provisioned user")
+			}
+		})
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 func GetLog(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionLogsRead) {
 		log.Error().Msg("User is not authorized to get log file")
 		servertools.UnauthorizedResponse(w)
 		return
@@ -30,7 +30,7 @@ func GetLog(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w htt
 
 func GetTraceLog(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionLogsRead) {
 		log.Error().Msg("User is not authorized to get trace log file")
 		servertools.UnauthorizedResponse(w)
 		return
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrorTooManyLoginAttempts is returned if the email or the IP address is locked after too many failed login attempts.
+const ErrorTooManyLoginAttempts = "too many login attempts"
+
+const (
+	defaultLoginAttemptsLimit = 100
+	maxLoginAttemptsLimit     = 1000
+)
+
+// loginDelay returns how long logins of the email from the IP address are still locked.
+func loginDelay(db *sql.DB, throttle *token.LoginThrottle, email string, ip string) (time.Duration, error) {
+
+	failures, sinceLastFailure, err := database.GetFailedLoginAttemptsForEmail(db, email, throttle.Window)
+	if err != nil {
+		return 0, err
+	}
+	delay := throttle.AccountDelay(failures, sinceLastFailure)
+
+	ipDelay, err := ipLoginDelay(db, throttle, ip)
+	if err != nil {
+		return 0, err
+	}
+	if ipDelay > delay {
+		delay = ipDelay
+	}
+	return delay, nil
+}
+
+// ipLoginDelay returns how long logins from the IP address are still locked.
+func ipLoginDelay(db *sql.DB, throttle *token.LoginThrottle, ip string) (time.Duration, error) {
+
+	failures, sinceLastFailure, err := database.GetFailedLoginAttemptsForIP(db, ip, throttle.Window)
+	if err != nil {
+		return 0, err
+	}
+	return throttle.IPDelay(failures, sinceLastFailure), nil
+}
+
+// recordLoginAttempt stores the login attempt, failures are only logged.
+func recordLoginAttempt(db *sql.DB, email string, user *token.User, ip string, peer string, success bool) {
+
+	var userID *int
+	if user != nil {
+		userID = &user.ID
+	}
+	email = token.TruncateString(email, 255)
+	err := database.AddLoginAttempt(db, email, userID, ip, peer, success)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store login attempt.")
+	}
+}
+
+func tooManyLoginAttemptsResponse(w http.ResponseWriter, delay time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	servertools.RespondError(w, http.StatusTooManyRequests, ErrorTooManyLoginAttempts)
+}
+
+// GetLoginAttempts returns the latest login attempts, they can be filtered by email and IP address.
+func GetLoginAttempts(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionUsersRead) {
+		log.Error().Msg("User is not authorized to get login attempts.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if email != "" {
+		normalized, err := token.NormalizeEmail(email)
+		if err == nil {
+			email = normalized
+		}
+	}
+	limit := defaultLoginAttemptsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLoginAttemptsLimit {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		limit = parsed
+	}
+
+	attempts, err := database.GetLoginAttempts(db, email, r.URL.Query().Get("ip"), limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch login attempts.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, attempts)
+}
+
+// UnlockUser clears the failed login attempts of the given user, so the user can login again right away.
+func UnlockUser(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to unlock users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	requestedUser, err := database.GetUserByID(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	err = database.ClearFailedLoginAttempts(db, requestedUser.Email)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to clear failed login attempts.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("admin", claims.UserID).Msg("User was unlocked.")
+	servertools.RespondCode(w, http.StatusOK)
+}
@@ -0,0 +1,436 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrorMFAAlreadyEnabled is returned if a user that already confirmed a TOTP enrollment tries to enroll again.
+const ErrorMFAAlreadyEnabled = "mfa already enabled"
+
+// ErrorMFANotEnabled is returned if a user without a TOTP enrollment tries to manage it.
+const ErrorMFANotEnabled = "mfa not enabled"
+
+// getTOTP returns the TOTP enrollment of the user or nil if the user didn't enroll.
+func getTOTP(db *sql.DB, userID string) (*token.TOTP, error) {
+
+	totp, err := database.GetTOTP(db, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return totp, err
+}
+
+// verifyTOTPCode returns true if the code is a valid one-time password of the enrollment that wasn't used before.
+func verifyTOTPCode(db *sql.DB, totp *token.TOTP, code string) (bool, error) {
+
+	step, err := token.ValidateTOTP(totp.Secret, code, time.Now())
+	if err != nil {
+		return false, nil
+	}
+	return database.UseTOTPStep(db, fmt.Sprint(totp.UserID), step)
+}
+
+// verifyMFACode returns true if the code is a valid one-time password or an unused recovery code of the user.
+// Recovery codes are only accepted for confirmed enrollments.
+func verifyMFACode(db *sql.DB, totp *token.TOTP, code string) (bool, error) {
+
+	if token.IsTOTPCode(code) {
+		return verifyTOTPCode(db, totp, code)
+	}
+	if !totp.IsConfirmed() {
+		return false, nil
+	}
+	return database.UseRecoveryCode(db, fmt.Sprint(totp.UserID), code)
+}
+
+// confirmTOTPEnrollment confirms the TOTP enrollment of the user and returns the first recovery codes.
+func confirmTOTPEnrollment(db *sql.DB, userID string) ([]string, error) {
+
+	err := database.ConfirmTOTP(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to confirm TOTP enrollment.")
+		return nil, err
+	}
+	codes, err := database.ReplaceRecoveryCodes(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate recovery codes.")
+		return nil, err
+	}
+	return codes, nil
+}
+
+func readStringVars(r *http.Request) (map[string]string, error) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var vars map[string]string
+	err = json.Unmarshal(body, &vars)
+	return vars, err
+}
+
+// startMFAChallenge responds with a new MFA challenge for the user that verified the password.
+func startMFAChallenge(db *sql.DB, mfa *token.MFAPolicy, w http.ResponseWriter, user *token.User, enrollmentRequired bool, device string) {
+
+	err := database.RemoveExpiredMFAChallenges(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired mfa challenges.")
+	}
+
+	challenge, err := database.GenerateMFAChallenge(db, user, device, mfa.ChallengeLifetime)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate mfa challenge.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusAccepted, token.MFAChallenge{Challenge: challenge, EnrollmentRequired: enrollmentRequired})
+}
+
+// CompleteMFALogin returns a handler that exchanges an MFA challenge and a one-time password or a recovery code
+// for the tokens. If the user enrolled during the login the code confirms the enrollment.
+func CompleteMFALogin(throttle *token.LoginThrottle) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		vars, err := readStringVars(r)
+		if err != nil || vars["challenge"] == "" || vars["code"] == "" {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		challengeID, userID, device, err := database.GetMFAChallenge(db, vars["challenge"])
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Error().Msg("MFA challenge is invalid, expired or was already used.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch mfa challenge.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		requestedUser, err := database.GetUserByID(db, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch user.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+
+		ip := throttle.ClientIP(r)
+		peer := token.GetPeerName(r)
+		delay, err := loginDelay(db, throttle, requestedUser.Email, ip)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch failed login attempts.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if delay > 0 {
+			log.Error().Str("ip", ip).Msg("Login is locked after too many failed attempts.")
+			tooManyLoginAttemptsResponse(w, delay)
+			return
+		}
+
+		totp, err := getTOTP(db, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch TOTP enrollment of user.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if totp == nil {
+			servertools.RespondError(w, http.StatusBadRequest, ErrorMFANotEnabled)
+			return
+		}
+
+		valid, err := verifyMFACode(db, totp, vars["code"])
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to verify mfa code.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if !valid {
+			log.Error().Str("user", userID).Msg("The mfa code provided was wrong.")
+			err = database.FailMFAChallenge(db, challengeID)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to count wrong code for mfa challenge.")
+			}
+			recordLoginAttempt(db, requestedUser.Email, requestedUser, ip, peer, false)
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+
+		err = database.UseMFAChallenge(db, challengeID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to use mfa challenge.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		var codes []string
+		if !totp.IsConfirmed() {
+			codes, err = confirmTOTPEnrollment(db, userID)
+			if err != nil {
+				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			log.Info().Str("user", userID).Msg("User enrolled into two-factor authentication during login.")
+		}
+		recordLoginAttempt(db, requestedUser.Email, requestedUser, ip, peer, true)
+
+		if requestedUser.Suspended {
+			log.Error().Str("user", userID).Msg("Suspended user tried to login.")
+			suspendedResponse(w)
+			return
+		}
+		if codes != nil {
+			w.Header().Set("Recovery-Codes", strings.Join(codes, ","))
+		}
+		issueSession(auth, db, w, requestedUser, device)
+	}
+}
+
+// EnrollMFAAtLogin returns a handler that enrolls users that need a second factor but didn't enroll yet,
+// like admins if two-factor authentication is mandatory for them. The enrollment is confirmed by completing the login.
+func EnrollMFAAtLogin(mfa *token.MFAPolicy) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		vars, err := readStringVars(r)
+		if err != nil || vars["challenge"] == "" {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		_, userID, _, err := database.GetMFAChallenge(db, vars["challenge"])
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Error().Msg("MFA challenge is invalid, expired or was already used.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch mfa challenge.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		enrollTOTP(db, mfa, w, userID)
+	}
+}
+
+// enrollTOTP responds with a new unconfirmed TOTP enrollment for the user.
+func enrollTOTP(db *sql.DB, mfa *token.MFAPolicy, w http.ResponseWriter, userID string) {
+
+	requestedUser, err := database.GetUserByID(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	enrollment, err := token.NewTOTPEnrollment(mfa.Issuer, requestedUser.Email)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate TOTP secret.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	err = database.SetTOTPSecret(db, userID, enrollment.Secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusBadRequest, ErrorMFAAlreadyEnabled)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store TOTP secret.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Msg("User started TOTP enrollment.")
+	servertools.RespondJSON(w, http.StatusCreated, enrollment)
+}
+
+// EnrollTOTP returns a handler that lets users start a TOTP enrollment, the enrollment needs to be confirmed with a code.
+func EnrollTOTP(mfa *token.MFAPolicy) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		userID, err := objectID(r)
+		if err != nil || userID != claims.UserID {
+			log.Error().Msg("User is not authorized to enroll other users.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+
+		enrollTOTP(db, mfa, w, userID)
+	}
+}
+
+// ConfirmTOTP confirms the TOTP enrollment of the user with a code and returns new recovery codes.
+func ConfirmTOTP(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID != claims.UserID {
+		log.Error().Msg("User is not authorized to confirm the enrollment of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	vars, err := readStringVars(r)
+	if err != nil || vars["code"] == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	totp, err := getTOTP(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch TOTP enrollment of user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if totp == nil {
+		servertools.RespondError(w, http.StatusBadRequest, ErrorMFANotEnabled)
+		return
+	}
+	if totp.IsConfirmed() {
+		servertools.RespondError(w, http.StatusBadRequest, ErrorMFAAlreadyEnabled)
+		return
+	}
+
+	valid, err := verifyTOTPCode(db, totp, vars["code"])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to verify TOTP code.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if !valid {
+		log.Error().Str("user", userID).Msg("The TOTP code provided was wrong.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	codes, err := confirmTOTPEnrollment(db, userID)
+	if err != nil {
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Msg("User enrolled into two-factor authentication.")
+	servertools.RespondJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after verifying a one-time password.
+func RegenerateRecoveryCodes(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID != claims.UserID {
+		log.Error().Msg("User is not authorized to generate recovery codes for other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	vars, err := readStringVars(r)
+	if err != nil || vars["code"] == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	totp, err := getTOTP(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch TOTP enrollment of user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if totp == nil || !totp.IsConfirmed() {
+		servertools.RespondError(w, http.StatusBadRequest, ErrorMFANotEnabled)
+		return
+	}
+
+	valid, err := verifyTOTPCode(db, totp, vars["code"])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to verify TOTP code.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if !valid {
+		log.Error().Str("user", userID).Msg("The TOTP code provided was wrong.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	codes, err := database.ReplaceRecoveryCodes(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate recovery codes.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Msg("User regenerated recovery codes.")
+	servertools.RespondJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTP removes the TOTP enrollment and the recovery codes of a user. Users need to provide a one-time password
+// or a recovery code, admins can disable two-factor authentication of other users without a code.
+func DisableTOTP(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to disable two-factor authentication of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	totp, err := getTOTP(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch TOTP enrollment of user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if totp == nil {
+		servertools.RespondError(w, http.StatusBadRequest, ErrorMFANotEnabled)
+		return
+	}
+
+	if userID == claims.UserID && totp.IsConfirmed() {
+		vars, err := readStringVars(r)
+		if err != nil || vars["code"] == "" {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		valid, err := verifyMFACode(db, totp, vars["code"])
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to verify mfa code.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if !valid {
+			log.Error().Str("user", userID).Msg("The mfa code provided was wrong.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+	}
+
+	err = database.DeleteTOTP(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove TOTP enrollment.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("by", claims.UserID).Msg("Two-factor authentication was disabled.")
+	servertools.RespondCode(w, http.StatusOK)
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// testTOTPCode returns the current one-time password of the test secret.
+func testTOTPCode(t *testing.T) string {
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// mfaTestDatabase answers the queries of a login of the test user that completes the second factor, the TOTP
+// enrollment of the user is confirmed if confirmed is true.
+func mfaTestDatabase(t *testing.T, confirmed bool) *fakeDatabase {
+
+	db := newFakeDatabase(t)
+	var confirmedAt interface{}
+	if confirmed {
+		confirmedAt = time.Now()
+	}
+
+	db.on("SELECT `mfa_challenge_id`", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{{int64(1), int64(testUserID), "device"}}}, nil
+	})
+	db.on("SELECT * FROM users WHERE `user_id`=?", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{testUserRow(testUserID, testUserEmail)}}, nil
+	})
+	db.on("SELECT COUNT(*), COALESCE", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{{int64(0), int64(0)}}}, nil
+	})
+	db.on("SELECT * FROM totp_secrets", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{{int64(testUserID), testTOTPSecret, int64(0), time.Now(), confirmedAt}}}, nil
+	})
+	db.on("UPDATE totp_secrets SET `totp_last_step`", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rowsAffected: 1}, nil
+	})
+	db.on("UPDATE totp_secrets SET `totp_confirmedat`", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rowsAffected: 1}, nil
+	})
+	db.on("UPDATE mfa_challenges SET `mfa_challenge_usedat`", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rowsAffected: 1}, nil
+	})
+	db.on("DELETE FROM recovery_codes", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("INSERT INTO recovery_codes", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	db.on("INSERT INTO login_attempts", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	db.on("UPDATE login_attempts", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("SELECT `associated_", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("SELECT `role_permission_name`", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("DELETE FROM refresh_tokens", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	db.on("INSERT INTO refresh_tokens", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	return db
+}
+
+func TestCompleteMFALoginRecoveryCodes(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		confirmed bool
+	}{
+		{name: "enrollment during login", confirmed: false},
+		{name: "confirmed enrollment", confirmed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			fakeDB := mfaTestDatabase(t, test.confirmed)
+			throttle, err := token.NewLoginThrottle(5, 20, time.Second, time.Minute, time.Minute, nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := json.Marshal(map[string]string{"challenge": "challenge-1", "code": testTOTPCode(t)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			CompleteMFALogin(throttle)(newTestAuthService(t), fakeDB.open(), w, httptest.NewRequest(http.MethodPost, "/users/login/mfa", bytes.NewReader(body)))
+
+			if w.Code != http.StatusOK || w.Header().Get("Refresh-Token") == "" {
+				t.Fatalf("expected a session, got %d: %s", w.Code, w.Body.String())
+			}
+			codes := w.Header().Get("Recovery-Codes")
+			if test.confirmed {
+				if codes != "" || fakeDB.executed("INSERT INTO recovery_codes") {
+					t.Fatalf("expected no new recovery codes for a confirmed enrollment, got %q", codes)
+				}
+				return
+			}
+			if len(strings.Split(codes, ",")) != 10 || !fakeDB.executed("UPDATE totp_secrets SET `totp_confirmedat`") {
+				t.Fatalf("expected the enrollment to be confirmed with recovery codes, got %q", codes)
+			}
+		})
+	}
+}
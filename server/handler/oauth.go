@@ -0,0 +1,601 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// oauthClientChanges are the fields of an OAuth client that are set by admins when registering it.
+type oauthClientChanges struct {
+	Name         string   `json:"oauth_client_name"`
+	RedirectURIs []string `json:"oauth_client_redirect_uris"`
+	Confidential bool     `json:"oauth_client_confidential"`
+	Trusted      bool     `json:"oauth_client_trusted"`
+	Audiences    []string `json:"oauth_client_audiences"`
+}
+
+func (changes *oauthClientChanges) valid() bool {
+
+	if changes.Name == "" || len(changes.Name) > 255 || len(changes.RedirectURIs) == 0 {
+		return false
+	}
+	for _, redirectURI := range changes.RedirectURIs {
+		if !token.ValidRedirectURI(redirectURI) {
+			return false
+		}
+	}
+	for _, audience := range changes.Audiences {
+		if audience == "" || strings.ContainsAny(audience, " \t\r\n") {
+			return false
+		}
+	}
+	return len(strings.Join(changes.Audiences, " ")) <= 255
+}
+
+func GetOAuthClients(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysRead) {
+		log.Error().Msg("User is not authorized to get OAuth clients.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	clients, err := database.GetAllOAuthClients(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch all OAuth clients.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	respondJSONWithETag(w, r, clients)
+}
+
+// AddOAuthClient registers a new OAuth client together with the API key that is used as its credentials.
+// The API key has no scopes, so the client secret can't be used for the API key authenticated endpoints.
+func AddOAuthClient(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to create OAuth clients.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	changes := oauthClientChanges{Confidential: true}
+	err := decodeChanges(r, &changes)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if !changes.valid() {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	creatorID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read user ID from claims.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	apiKey, err := token.NewAPIKey("OAuth client " + changes.Name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate api key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	apiKey.Scopes = []string{}
+	apiKey.CreatedBy = &creatorID
+
+	client := token.OAuthClient{Name: changes.Name, RedirectURIs: changes.RedirectURIs, Confidential: changes.Confidential, Trusted: changes.Trusted, Audiences: changes.Audiences}
+	apiKeyID, err := database.AddOAuthClient(db, *apiKey, client)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to add OAuth client.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	createdClient, err := database.GetOAuthClient(db, strconv.Itoa(apiKeyID))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch created OAuth client.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	// the secret is only returned once, afterwards only its hash is known
+	createdClient.Secret = apiKey.Key
+	log.Info().Int("client", apiKeyID).Str("by", claims.UserID).Msg("OAuth client was registered.")
+	servertools.RespondJSON(w, http.StatusCreated, createdClient)
+}
+
+// DeleteOAuthClient removes the OAuth client together with its API key, consents, codes and refresh tokens.
+func DeleteOAuthClient(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to delete OAuth clients.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	clientID, err := objectID(r)
+	if err != nil || clientID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	_, err = database.GetOAuthClient(db, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch OAuth client.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	err = database.RemoveAPIKey(db, clientID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete OAuth client.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("client", clientID).Str("by", claims.UserID).Msg("OAuth client was removed.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+// validateAuthorizationRequest returns the client and the requested scopes of the authorization request. Errors
+// caused by the client or the redirect URI have no redirect URI, because the user can't be sent back to the client.
+func validateAuthorizationRequest(db *sql.DB, request *token.OAuthAuthorizationRequest) (*token.OAuthClient, []string, *token.OAuthError) {
+
+	client, err := database.GetOAuthClientByClientID(db, request.ClientID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Error().Err(err).Msg("Failed to fetch OAuth client.")
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorServerError}
+	}
+	if err != nil || !client.Usable {
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorInvalidClient, Description: "unknown client"}
+	}
+	if !client.AllowsRedirectURI(request.RedirectURI) {
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorInvalidRequest, Description: "redirect_uri is not registered for the client"}
+	}
+
+	if request.ResponseType != "code" {
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorUnsupportedResponseType, RedirectURI: request.RedirectURI, State: request.State}
+	}
+	scopes, ok := token.ParseOAuthScope(request.Scope)
+	if !ok {
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorInvalidScope, RedirectURI: request.RedirectURI, State: request.State}
+	}
+	// every client needs to use PKCE, so intercepted codes are useless without the code verifier
+	if request.CodeChallengeMethod != token.OAuthCodeChallengeMethod || !token.ValidCodeChallenge(request.CodeChallenge) {
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorInvalidRequest, Description: "a S256 code_challenge is required", RedirectURI: request.RedirectURI, State: request.State}
+	}
+	if len(request.Nonce) > 255 {
+		return nil, nil, &token.OAuthError{Code: token.OAuthErrorInvalidRequest, Description: "the nonce is too long", RedirectURI: request.RedirectURI, State: request.State}
+	}
+	return client, scopes, nil
+}
+
+// Authorize returns a handler that validates the authorization request of a client and redirects the user to the
+// authorize page of the web app. The web app logs the user in and sends the request to ApproveAuthorization.
+func Authorize(authorizeURL string) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		request := token.NewOAuthAuthorizationRequest(r.URL.Query())
+		_, _, oauthErr := validateAuthorizationRequest(db, request)
+		if oauthErr != nil {
+			if oauthErr.RedirectURI != "" {
+				http.Redirect(w, r, oauthErr.RedirectURL(), http.StatusFound)
+				return
+			}
+			respondOAuthError(w, http.StatusBadRequest, oauthErr)
+			return
+		}
+		http.Redirect(w, r, token.AppendQuery(authorizeURL, r.URL.Query()), http.StatusFound)
+	}
+}
+
+// ApproveAuthorization returns a handler that issues an authorization code for the logged in user. Users need to
+// consent to the requested scopes once per client, trusted clients are authorized without asking the user.
+func ApproveAuthorization(codeLifetime time.Duration) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		var request token.OAuthAuthorizationRequest
+		err := decodeChanges(r, &request)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal request body.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		client, scopes, oauthErr := validateAuthorizationRequest(db, &request)
+		if oauthErr != nil {
+			if oauthErr.RedirectURI != "" {
+				servertools.RespondJSON(w, http.StatusOK, token.OAuthAuthorization{RedirectURI: oauthErr.RedirectURL()})
+				return
+			}
+			servertools.RespondError(w, http.StatusBadRequest, oauthErr.Error())
+			return
+		}
+
+		user, err := database.GetUserByID(db, claims.UserID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch user.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if user.Suspended {
+			log.Error().Str("user", claims.UserID).Msg("Suspended user tried to authorize an OAuth client.")
+			suspendedResponse(w)
+			return
+		}
+
+		switch request.Consent {
+		case "deny":
+			log.Info().Str("user", claims.UserID).Int("client", client.APIKeyID).Msg("User denied the authorization of an OAuth client.")
+			denied := token.OAuthError{Code: token.OAuthErrorAccessDenied, RedirectURI: request.RedirectURI, State: request.State}
+			servertools.RespondJSON(w, http.StatusOK, token.OAuthAuthorization{RedirectURI: denied.RedirectURL()})
+			return
+		case "approve":
+			err = database.SetOAuthConsent(db, user.ID, client.APIKeyID, scopes)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to store OAuth consent.")
+				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			log.Info().Str("user", claims.UserID).Int("client", client.APIKeyID).Msg("User consented to the authorization of an OAuth client.")
+		case "":
+			if !client.Trusted {
+				consent, err := database.GetOAuthConsent(db, claims.UserID, client.APIKeyID)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					log.Error().Err(err).Msg("Failed to fetch OAuth consent.")
+					servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+				if err != nil || !consent.Covers(scopes) {
+					servertools.RespondJSON(w, http.StatusOK, token.OAuthAuthorization{ConsentRequired: true, ClientName: client.Name, Scope: strings.Join(scopes, " ")})
+					return
+				}
+			}
+		default:
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		code, err := database.GenerateOAuthCode(db, client, user, &request, scopes, codeLifetime)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate authorization code.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		values := url.Values{}
+		values.Set("code", code)
+		if request.State != "" {
+			values.Set("state", request.State)
+		}
+		servertools.RespondJSON(w, http.StatusOK, token.OAuthAuthorization{RedirectURI: token.AppendQuery(request.RedirectURI, values)})
+	}
+}
+
+// ExchangeOAuthToken returns a handler that authenticates the client and exchanges an authorization code or a refresh
+// token for tokens. ID tokens are issued by the given issuer. The parameters are form encoded and the response isn't
+// wrapped in a data field, as expected by OAuth clients.
+func ExchangeOAuthToken(issuer string) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		err := r.ParseForm()
+		if err != nil {
+			respondOAuthError(w, http.StatusBadRequest, &token.OAuthError{Code: token.OAuthErrorInvalidRequest})
+			return
+		}
+
+		// clients send their credentials with basic authentication or as form parameters
+		clientID, secret, ok := r.BasicAuth()
+		if !ok {
+			clientID = r.PostForm.Get("client_id")
+			secret = r.PostForm.Get("client_secret")
+		}
+		// services authenticate with their service key instead of the API key of an OAuth client
+		if r.PostForm.Get("grant_type") == token.OAuthGrantClientCredentials {
+			exchangeServiceToken(auth, db, w, r, clientID, secret)
+			return
+		}
+		client, err := database.GetOAuthClientByClientID(db, clientID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("Failed to fetch OAuth client.")
+			respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+			return
+		}
+		if err != nil || !client.Authenticate(secret) {
+			log.Error().Str("client", clientID).Msg("OAuth client failed to authenticate.")
+			respondOAuthError(w, http.StatusUnauthorized, &token.OAuthError{Code: token.OAuthErrorInvalidClient})
+			return
+		}
+
+		switch r.PostForm.Get("grant_type") {
+		case token.OAuthGrantAuthorizationCode:
+			exchangeOAuthCode(auth, db, w, r, client, issuer)
+		case token.OAuthGrantRefreshToken:
+			refreshOAuthToken(auth, db, w, r, client, issuer)
+		default:
+			respondOAuthError(w, http.StatusBadRequest, &token.OAuthError{Code: token.OAuthErrorUnsupportedGrantType})
+		}
+	}
+}
+
+func exchangeOAuthCode(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request, client *token.OAuthClient, issuer string) {
+
+	invalidGrant := &token.OAuthError{Code: token.OAuthErrorInvalidGrant}
+
+	code, err := database.GetOAuthCode(db, r.PostForm.Get("code"))
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Error().Int("client", client.APIKeyID).Msg("Unknown authorization code was presented.")
+		respondOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch authorization code.")
+		respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+		return
+	}
+
+	// An authorization code can only be used once, if it is presented again it was most likely
+	// intercepted, so we revoke the refresh tokens that were issued for it.
+	if code.UsedAt != nil {
+		log.Warn().Int("user", code.UserID).Int("client", code.ClientID).Msg("Authorization code reuse detected, revoking issued tokens.")
+		if code.Family != nil {
+			err = database.RevokeRefreshTokenFamily(db, *code.Family)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to revoke refresh token family.")
+			}
+		}
+		respondOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if code.Expired || code.ClientID != client.APIKeyID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		log.Error().Int("user", code.UserID).Int("client", client.APIKeyID).Msg("Expired or mismatching authorization code was presented.")
+		respondOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if !token.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		log.Error().Int("user", code.UserID).Int("client", client.APIKeyID).Msg("Code verifier doesn't match the code challenge.")
+		respondOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	family := uuid.New().String()
+	used, err := database.UseOAuthCode(db, code.ID, family)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update authorization code.")
+		respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+		return
+	}
+	if !used {
+		log.Warn().Int("user", code.UserID).Int("client", code.ClientID).Msg("Authorization code was exchanged concurrently.")
+		respondOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	err = database.RemoveExpiredOAuthCodes(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired authorization codes.")
+	}
+
+	user, err := database.GetUserByID(db, fmt.Sprint(code.UserID))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		respondOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if user.Suspended {
+		log.Error().Int("user", user.ID).Msg("Authorization code of a suspended user was presented.")
+		respondOAuthError(w, http.StatusBadRequest, &token.OAuthError{Code: token.OAuthErrorInvalidGrant, Description: ErrorAccountSuspended})
+		return
+	}
+	respondOAuthTokens(auth, db, w, user, client, code.Scopes, family, issuer, code.Nonce)
+}
+
+func refreshOAuthToken(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request, client *token.OAuthClient, issuer string) {
+
+	storedToken, user, err := rotateRefreshToken(db, r.PostForm.Get("refresh_token"), &client.APIKeyID)
+	if errors.Is(err, errInvalidRefreshToken) {
+		respondOAuthError(w, http.StatusBadRequest, &token.OAuthError{Code: token.OAuthErrorInvalidGrant})
+		return
+	}
+	if errors.Is(err, errAccountSuspended) {
+		respondOAuthError(w, http.StatusBadRequest, &token.OAuthError{Code: token.OAuthErrorInvalidGrant, Description: ErrorAccountSuspended})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to rotate refresh token.")
+		respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+		return
+	}
+
+	// the scopes of refresh tokens issued before they were stored are unknown, they were only issued for offline access
+	scopes := storedToken.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{token.OAuthScopeOfflineAccess}
+	}
+	respondOAuthTokens(auth, db, w, user, client, scopes, storedToken.Family, issuer, "")
+}
+
+// exchangeServiceToken responds with a service token for the service key with the given prefix and secret,
+// the token has the requested scopes or all scopes of the service key if the service requested none.
+func exchangeServiceToken(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request, clientID string, secret string) {
+
+	key, err := database.GetServiceKeyByHash(db, token.HashKey(secret))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Error().Err(err).Msg("Failed to fetch service key.")
+		respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+		return
+	}
+	if err != nil || secret == "" || key.Prefix != clientID || !key.IsUsable() {
+		log.Error().Str("client", clientID).Msg("Service failed to authenticate.")
+		respondOAuthError(w, http.StatusUnauthorized, &token.OAuthError{Code: token.OAuthErrorInvalidClient})
+		return
+	}
+
+	scopes, ok := key.GrantedScopes(r.PostForm.Get("scope"))
+	if !ok {
+		log.Error().Str("client", clientID).Msg("Service requested scopes its service key doesn't have.")
+		respondOAuthError(w, http.StatusBadRequest, &token.OAuthError{Code: token.OAuthErrorInvalidScope})
+		return
+	}
+
+	serviceToken, err := auth.Sign(token.NewServiceClaims(auth.Issuer, key, scopes, auth.ServiceTokenLifetime))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to sign service token.")
+		respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+		return
+	}
+	err = database.SetServiceKeysUsed(db, []int{key.ID})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update last used date of service key.")
+	}
+
+	respondOAuth(w, http.StatusOK, token.OAuthTokenResponse{
+		AccessToken: serviceToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(auth.ServiceTokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
+
+// respondOAuthTokens responds with a new access token of the user, a refresh token of the given family if the client
+// was granted offline access and an ID token with the nonce of the authorization request for the openid scope.
+func respondOAuthTokens(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, user *token.User, client *token.OAuthClient, scopes []string, family string, issuer string, nonce string) {
+
+	accessToken, err := database.GenerateClientAccessToken(user, client, scopes, auth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate access token for user.")
+		respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+		return
+	}
+	response := token.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(auth.TokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}
+	if slices.Contains(scopes, token.OAuthScopeOfflineAccess) {
+		response.RefreshToken, err = database.GenerateRefreshToken(user, family, client.Name, &client.APIKeyID, scopes, db, auth)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate refresh token for user.")
+			respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+			return
+		}
+	}
+	if slices.Contains(scopes, token.OAuthScopeOpenID) {
+		response.IDToken, err = auth.Sign(token.NewIDTokenClaims(issuer, user, client.ClientID, nonce, scopes, auth.TokenLifetime))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to sign ID token for user.")
+			respondOAuthError(w, http.StatusInternalServerError, &token.OAuthError{Code: token.OAuthErrorServerError})
+			return
+		}
+	}
+	respondOAuth(w, http.StatusOK, response)
+}
+
+func respondOAuthError(w http.ResponseWriter, code int, oauthErr *token.OAuthError) {
+	respondOAuth(w, code, oauthErr)
+}
+
+// respondOAuth writes the payload as is, tokens and errors of the token endpoint must not be cached.
+func respondOAuth(w http.ResponseWriter, code int, payload interface{}) {
+
+	response, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal payload")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+// GetOAuthConsents returns the OAuth clients the user consented to, admins can get the consents of every user.
+func GetOAuthConsents(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersRead) {
+		log.Error().Msg("User is not authorized to get the OAuth consents of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	consents, err := database.GetOAuthConsentsForUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch OAuth consents.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, consents)
+}
+
+// RevokeOAuthConsent removes the consent of the user for the OAuth client and revokes the refresh tokens the client
+// got for the user, admins can revoke the consents of every user.
+func RevokeOAuthConsent(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to revoke the OAuth consents of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	clientID, err := resourceID(r)
+	if err != nil || clientID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	err = database.RemoveOAuthConsent(db, userID, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove OAuth consent.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	err = database.RevokeRefreshTokensForClient(db, userID, clientID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke refresh tokens of OAuth client.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("client", clientID).Str("by", claims.UserID).Msg("OAuth consent was revoked.")
+	servertools.RespondCode(w, http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// GetOpenIDConfiguration returns a handler that serves the OpenID Connect discovery document of the given issuer.
+func GetOpenIDConfiguration(issuer string) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		// the document is served as is and not wrapped in a data field, as expected by OpenID Connect clients
+		response, err := json.Marshal(token.NewOpenIDConfiguration(issuer, auth.JSONWebKeySet()))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to marshal OpenID configuration.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondWithETag(w, r, "application/json", response)
+	}
+}
+
+// GetUserInfo returns the claims about the user the access token was issued for, the email is only
+// returned if the OAuth client was granted the email scope.
+func GetUserInfo(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	user, err := database.GetUserByID(db, claims.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	if user.Suspended {
+		log.Error().Str("user", claims.UserID).Msg("Userinfo of a suspended user was requested.")
+		suspendedResponse(w)
+		return
+	}
+	respondOAuth(w, http.StatusOK, token.NewUserInfo(user, claims))
+}
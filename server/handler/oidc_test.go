@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer       = "https://identity.festivalsapp.org"
+	testAuthorizeURL = "https://festivalsapp.org/authorize"
+	testClientID     = "festivals-web"
+	testClientSecret = "client-secret"
+	testRedirectURI  = "https://web.festivalsapp.org/callback"
+	testUserID       = 7
+	testUserEmail    = "user@festivalsapp.org"
+)
+
+// newTestAuthService returns an auth service that signs with a new ES256 key.
+func newTestAuthService(t *testing.T) *token.AuthService {
+
+	key, err := token.GenerateSigningKey(token.AlgorithmES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.State = token.KeyStateActive
+	hasher, err := token.NewPasswordHasher(token.PasswordHashBcrypt, 0, 0, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &token.AuthService{Algorithm: token.AlgorithmES256, ValidationKeys: token.NewKeySet(), TokenLifetime: 15 * time.Minute, Issuer: testIssuer, PasswordHasher: hasher}
+	err = auth.SetSigningKeys([]token.SigningKey{*key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+// testUserRow returns the users row of the verified test user.
+func testUserRow(id int64, email string) []driver.Value {
+	now := time.Now()
+	return []driver.Value{id, email, "", now, now, int64(token.CREATOR), false, "", nil, nil, true, now}
+}
+
+// oidcTestDatabase answers the queries of the authorization code flow of the test client and the test user.
+func oidcTestDatabase(t *testing.T) *fakeDatabase {
+
+	db := newFakeDatabase(t)
+	var code []driver.Value
+	consented := false
+
+	db.on("SELECT oauth_clients.*", func(args []driver.Value) (fakeResult, error) {
+		if args[0] != testClientID {
+			return fakeResult{}, nil
+		}
+		return fakeResult{rows: [][]driver.Value{{int64(3), "Festivals Web", testRedirectURI, true, false, time.Now(), "festivals-server", testClientID, token.HashKey(testClientSecret), true}}}, nil
+	})
+	db.on("SELECT * FROM users WHERE `user_id`=?", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{rows: [][]driver.Value{testUserRow(testUserID, testUserEmail)}}, nil
+	})
+	db.on("SELECT oauth_consents.*", func(args []driver.Value) (fakeResult, error) {
+		if !consented {
+			return fakeResult{}, nil
+		}
+		return fakeResult{rows: [][]driver.Value{{int64(testUserID), int64(3), "openid email", time.Now(), time.Now(), "Festivals Web"}}}, nil
+	})
+	db.on("INSERT INTO oauth_consents", func(args []driver.Value) (fakeResult, error) {
+		consented = true
+		return fakeResult{rowsAffected: 1}, nil
+	})
+	db.on("INSERT INTO oauth_codes", func(args []driver.Value) (fakeResult, error) {
+		// hash, client, user, redirect URI, scopes, challenge, nonce
+		code = []driver.Value{int64(1), args[0], args[1], args[2], args[3], args[4], args[5], nil, args[6], time.Now(), time.Now().Add(time.Minute), nil, false}
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	})
+	db.on("SELECT *, `oauth_code_expiresat`", func(args []driver.Value) (fakeResult, error) {
+		if code == nil || args[0] != code[1] {
+			return fakeResult{}, nil
+		}
+		return fakeResult{rows: [][]driver.Value{code}}, nil
+	})
+	db.on("UPDATE oauth_codes", func(args []driver.Value) (fakeResult, error) {
+		if code[11] != nil {
+			return fakeResult{}, nil
+		}
+		code[7] = args[0]
+		code[11] = time.Now()
+		return fakeResult{rowsAffected: 1}, nil
+	})
+	db.on("DELETE FROM oauth_codes", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	return db
+}
+
+// TestOpenIDConnectFlow runs the flow of an OpenID Connect client from the discovery of the provider to the userinfo.
+func TestOpenIDConnectFlow(t *testing.T) {
+
+	auth := newTestAuthService(t)
+	db := oidcTestDatabase(t).open()
+	loginClaims := &token.UserClaims{UserID: "7", UserRole: token.CREATOR}
+
+	// discovery
+	w := httptest.NewRecorder()
+	GetOpenIDConfiguration(testIssuer)(auth, db, w, httptest.NewRequest(http.MethodGet, token.OpenIDConfigurationPath, nil))
+	var configuration token.OpenIDConfiguration
+	decodeTestResponse(t, w, http.StatusOK, &configuration)
+	if configuration.Issuer != testIssuer || configuration.TokenEndpoint != testIssuer+token.OAuthTokenPath || configuration.UserInfoEndpoint != testIssuer+token.UserInfoPath {
+		t.Fatalf("unexpected discovery document: %+v", configuration)
+	}
+	w = httptest.NewRecorder()
+	GetJSONWebKeySet(auth, db, w, httptest.NewRequest(http.MethodGet, token.JSONWebKeySetPath, nil))
+	var keySet token.JSONWebKeySet
+	decodeTestResponse(t, w, http.StatusOK, &keySet)
+
+	// authorization
+	codeVerifier, codeChallenge, err := token.NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := token.OAuthAuthorizationRequest{
+		ClientID:            testClientID,
+		RedirectURI:         testRedirectURI,
+		ResponseType:        "code",
+		Scope:               "openid email",
+		State:               "state-1",
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: token.OAuthCodeChallengeMethod,
+		Nonce:               "nonce-1",
+	}
+	query := url.Values{}
+	query.Set("client_id", request.ClientID)
+	query.Set("redirect_uri", request.RedirectURI)
+	query.Set("response_type", request.ResponseType)
+	query.Set("scope", request.Scope)
+	query.Set("state", request.State)
+	query.Set("code_challenge", request.CodeChallenge)
+	query.Set("code_challenge_method", request.CodeChallengeMethod)
+	query.Set("nonce", request.Nonce)
+	w = httptest.NewRecorder()
+	Authorize(testAuthorizeURL)(auth, db, w, httptest.NewRequest(http.MethodGet, token.OAuthAuthorizePath+"?"+query.Encode(), nil))
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), testAuthorizeURL+"?") {
+		t.Fatalf("expected a redirect to the authorize page, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	authorization := approveTestAuthorization(t, auth, db, loginClaims, request)
+	if !authorization.ConsentRequired {
+		t.Fatalf("expected the consent of the user to be required: %+v", authorization)
+	}
+	request.Consent = "approve"
+	authorization = approveTestAuthorization(t, auth, db, loginClaims, request)
+	redirect, err := url.Parse(authorization.RedirectURI)
+	if err != nil || !strings.HasPrefix(authorization.RedirectURI, testRedirectURI+"?") || redirect.Query().Get("state") != request.State {
+		t.Fatalf("unexpected redirect to the client: %s", authorization.RedirectURI)
+	}
+
+	// token exchange
+	form := url.Values{}
+	form.Set("grant_type", token.OAuthGrantAuthorizationCode)
+	form.Set("code", redirect.Query().Get("code"))
+	form.Set("redirect_uri", testRedirectURI)
+	form.Set("code_verifier", codeVerifier)
+	tokenRequest := httptest.NewRequest(http.MethodPost, token.OAuthTokenPath, strings.NewReader(form.Encode()))
+	tokenRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenRequest.SetBasicAuth(testClientID, testClientSecret)
+	w = httptest.NewRecorder()
+	ExchangeOAuthToken(testIssuer)(auth, db, w, tokenRequest)
+	var tokens token.OAuthTokenResponse
+	decodeTestResponse(t, w, http.StatusOK, &tokens)
+	if tokens.RefreshToken != "" {
+		t.Error("expected no refresh token without the offline_access scope")
+	}
+
+	// ID token verification with the published keys
+	idClaims := &token.IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, idClaims, func(idToken *jwt.Token) (interface{}, error) {
+		for _, jwk := range keySet.Keys {
+			if jwk.KeyID == idToken.Header["kid"] && jwk.Algorithm == idToken.Method.Alg() {
+				return jwk.PublicKey()
+			}
+		}
+		return nil, errors.New("unknown key")
+	}, jwt.WithIssuer(configuration.Issuer), jwt.WithAudience(testClientID), jwt.WithExpirationRequired())
+	if err != nil {
+		t.Fatalf("failed to verify the ID token: %v", err)
+	}
+	if idClaims.Subject != "7" || idClaims.Nonce != request.Nonce || idClaims.Email != testUserEmail {
+		t.Fatalf("unexpected ID token claims: %+v", idClaims)
+	}
+
+	// the access token is bound to the client and only accepted by scoped endpoints of the identity service
+	validator := &token.ValidationService{Algorithm: auth.Algorithm, Keys: auth.ValidationKeys}
+	_, err = validator.ValidateAccessToken(tokens.AccessToken)
+	if err == nil {
+		t.Error("expected the first-party validation to reject the access token of the client")
+	}
+	claims, err := validator.ValidateAccessTokenForScope(tokens.AccessToken, token.OAuthScopeOpenID)
+	if err != nil {
+		t.Fatalf("failed to validate the access token for the openid scope: %v", err)
+	}
+	if claims.ClientID != testClientID || claims.UserRole != 0 || len(claims.UserPermissions) != 0 || len(claims.UserFestivals) != 0 {
+		t.Fatalf("expected the access token to only carry the client and its scopes: %+v", claims)
+	}
+
+	// userinfo
+	w = httptest.NewRecorder()
+	GetUserInfo(auth, claims, db, w, httptest.NewRequest(http.MethodGet, token.UserInfoPath, nil))
+	var userInfo token.UserInfo
+	decodeTestResponse(t, w, http.StatusOK, &userInfo)
+	if userInfo.Subject != idClaims.Subject || userInfo.Email != testUserEmail {
+		t.Fatalf("unexpected userinfo: %+v", userInfo)
+	}
+}
+
+func approveTestAuthorization(t *testing.T, auth *token.AuthService, db *sql.DB, claims *token.UserClaims, request token.OAuthAuthorizationRequest) token.OAuthAuthorization {
+
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	ApproveAuthorization(time.Minute)(auth, claims, db, w, httptest.NewRequest(http.MethodPost, token.OAuthAuthorizePath, bytes.NewReader(body)))
+	var response struct {
+		Data token.OAuthAuthorization `json:"data"`
+	}
+	decodeTestResponse(t, w, http.StatusOK, &response)
+	return response.Data
+}
+
+func decodeTestResponse(t *testing.T, w *httptest.ResponseRecorder, status int, payload interface{}) {
+
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	err := json.Unmarshal(w.Body.Bytes(), payload)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+}
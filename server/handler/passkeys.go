@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultPasskeyName = "Passkey"
+
+// passkeyResponse is the body clients send to finish a passkey registration or login,
+// the credential is the PublicKeyCredential returned by the browser.
+type passkeyResponse struct {
+	Challenge  string          `json:"challenge"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+func readPasskeyResponse(r *http.Request) (*passkeyResponse, error) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response passkeyResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Challenge == "" || len(response.Credential) == 0 {
+		return nil, errors.New("the passkey response is missing the challenge or the credential")
+	}
+	return &response, nil
+}
+
+// getPasskeyUser returns the user together with the registered passkeys of the user.
+func getPasskeyUser(db *sql.DB, userID string) (*token.PasskeyUser, error) {
+
+	user, err := database.GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := database.GetPasskeysForUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &token.PasskeyUser{User: user, Passkeys: passkeys}, nil
+}
+
+// StartPasskeyRegistration returns a handler that starts the registration of a new passkey for the user.
+func StartPasskeyRegistration(passkeys *token.PasskeyService) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		userID, err := objectID(r)
+		if err != nil || userID != claims.UserID {
+			log.Error().Msg("User is not authorized to register passkeys for other users.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+
+		passkeyUser, err := getPasskeyUser(db, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch user with passkeys.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// authenticators that already hold a passkey of the user don't create a second one
+		exclusions := make([]protocol.CredentialDescriptor, len(passkeyUser.Passkeys))
+		for i, credential := range passkeyUser.WebAuthnCredentials() {
+			exclusions[i] = credential.Descriptor()
+		}
+		options, session, err := passkeys.RelyingParty.BeginRegistration(passkeyUser, webauthn.WithExclusions(exclusions))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start passkey registration.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		challenge, err := database.GeneratePasskeyChallenge(db, &passkeyUser.User.ID, session, passkeys.ChallengeLifetime)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate passkey challenge.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		servertools.RespondJSON(w, http.StatusOK, token.PasskeyChallenge{Challenge: challenge, Options: options})
+	}
+}
+
+// FinishPasskeyRegistration returns a handler that verifies the new credential of the user and stores it as a passkey.
+func FinishPasskeyRegistration(passkeys *token.PasskeyService) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		userID, err := objectID(r)
+		if err != nil || userID != claims.UserID {
+			log.Error().Msg("User is not authorized to register passkeys for other users.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+
+		response, err := readPasskeyResponse(r)
+		if err != nil || len(response.Name) > 255 {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		name := response.Name
+		if name == "" {
+			name = defaultPasskeyName
+		}
+
+		session, err := database.UsePasskeyChallenge(db, response.Challenge, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Error().Msg("Passkey challenge is invalid, expired or was already used.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch passkey challenge.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		passkeyUser, err := getPasskeyUser(db, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch user with passkeys.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		parsedResponse, err := protocol.ParseCredentialCreationResponseBytes(response.Credential)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to parse passkey registration.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		credential, err := passkeys.RelyingParty.CreateCredential(passkeyUser, *session, parsedResponse)
+		if err != nil {
+			log.Error().Err(err).Str("user", userID).Msg("Failed to verify passkey registration.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		passkey := token.NewPasskey(passkeyUser.User.ID, credential, name)
+		passkey.ID, err = database.AddPasskey(db, passkey)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to store passkey.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		passkey.CreateDate = time.Now()
+
+		log.Info().Str("user", userID).Int("passkey", passkey.ID).Msg("User registered a passkey.")
+		servertools.RespondJSON(w, http.StatusCreated, passkey)
+	}
+}
+
+// GetPasskeys returns the passkeys of the user, admins can get the passkeys of every user.
+func GetPasskeys(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersRead) {
+		log.Error().Msg("User is not authorized to get the passkeys of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	passkeys, err := database.GetPasskeysForUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch passkeys.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, passkeys)
+}
+
+// DeletePasskey removes a passkey of the user, admins can remove the passkeys of every user.
+func DeletePasskey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if userID != claims.UserID && !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to remove the passkeys of other users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	passkeyID, err := resourceID(r)
+	if err != nil || passkeyID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	err = database.DeletePasskey(db, userID, passkeyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove passkey.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("passkey", passkeyID).Str("by", claims.UserID).Msg("Passkey was removed.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+// StartPasskeyLogin returns a handler that starts a passkey login. The login is discoverable,
+// so users don't enter their email and pick one of their passkeys on the device instead.
+func StartPasskeyLogin(passkeys *token.PasskeyService) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		err := database.RemoveExpiredPasskeyChallenges(db)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to remove expired passkey challenges.")
+		}
+
+		options, session, err := passkeys.RelyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start passkey login.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		challenge, err := database.GeneratePasskeyChallenge(db, nil, session, passkeys.ChallengeLifetime)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate passkey challenge.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		servertools.RespondJSON(w, http.StatusOK, token.PasskeyChallenge{Challenge: challenge, Options: options})
+	}
+}
+
+// FinishPasskeyLogin returns a handler that verifies the passkey assertion and responds with the same tokens as a
+// password login. Passkeys are verified by the authenticator with a PIN or biometrics, so no second factor is needed.
+// Passkeys whose signature counter didn't increase are rejected, because the authenticator was most likely cloned.
+func FinishPasskeyLogin(passkeys *token.PasskeyService, throttle *token.LoginThrottle) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		response, err := readPasskeyResponse(r)
+		if err != nil {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		// passkeys can't be guessed, so only the lockout of the IP address applies
+		ip := throttle.ClientIP(r)
+		peer := token.GetPeerName(r)
+		delay, err := ipLoginDelay(db, throttle, ip)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch failed login attempts.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if delay > 0 {
+			log.Error().Str("ip", ip).Msg("Login is locked after too many failed attempts.")
+			tooManyLoginAttemptsResponse(w, delay)
+			return
+		}
+
+		session, err := database.UsePasskeyChallenge(db, response.Challenge, "")
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Error().Msg("Passkey challenge is invalid, expired or was already used.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch passkey challenge.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		parsedResponse, err := protocol.ParseCredentialRequestResponseBytes(response.Credential)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to parse passkey login.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		var passkeyUser *token.PasskeyUser
+		findUser := func(rawID []byte, userHandle []byte) (webauthn.User, error) {
+			userID, err := token.PasskeyUserID(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			passkeyUser, err = getPasskeyUser(db, strconv.Itoa(userID))
+			return passkeyUser, err
+		}
+		credential, err := passkeys.RelyingParty.ValidateDiscoverableLogin(findUser, *session, parsedResponse)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to verify passkey login.")
+			failPasskeyLogin(db, w, passkeyUser, ip, peer)
+			return
+		}
+
+		passkey := passkeyUser.Passkey(credential.ID)
+		if passkey == nil {
+			log.Error().Int("user", passkeyUser.User.ID).Msg("Passkey of the login is not registered for the user.")
+			failPasskeyLogin(db, w, passkeyUser, ip, peer)
+			return
+		}
+		used := false
+		if !credential.Authenticator.CloneWarning {
+			used, err = database.UsePasskey(db, passkey.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to update passkey.")
+				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+		if !used {
+			log.Warn().Int("user", passkeyUser.User.ID).Int("passkey", passkey.ID).Msg("Passkey signature counter didn't increase, the authenticator might be cloned.")
+			failPasskeyLogin(db, w, passkeyUser, ip, peer)
+			return
+		}
+
+		requestedUser := passkeyUser.User
+		recordLoginAttempt(db, requestedUser.Email, requestedUser, ip, peer, true)
+		if requestedUser.Suspended {
+			log.Error().Str("user", fmt.Sprint(requestedUser.ID)).Msg("Suspended user tried to login.")
+			suspendedResponse(w)
+			return
+		}
+		if !requestedUser.Verified {
+			log.Error().Str("user", fmt.Sprint(requestedUser.ID)).Msg("Unverified user tried to login.")
+			unverifiedResponse(w)
+			return
+		}
+		issueSession(auth, db, w, requestedUser, token.GetDeviceName(r))
+	}
+}
+
+// failPasskeyLogin records the failed passkey login, the user is nil if the passkey didn't belong to any user.
+func failPasskeyLogin(db *sql.DB, w http.ResponseWriter, passkeyUser *token.PasskeyUser, ip string, peer string) {
+
+	if passkeyUser != nil {
+		recordLoginAttempt(db, passkeyUser.User.Email, passkeyUser.User, ip, peer, false)
+	} else {
+		recordLoginAttempt(db, "", nil, ip, peer, false)
+	}
+	servertools.UnauthorizedResponse(w)
+}
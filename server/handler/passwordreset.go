@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	"github.com/Festivals-App/festivals-identity-server/server/mail"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestPasswordReset returns a handler that emails a password reset link to the user with the requested email.
+// The response is the same whether the user exists or not, so the endpoint can't be used to find registered emails.
+func RequestPasswordReset(mailer mail.Mailer, resetURL string, lifetime time.Duration) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read request body.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		var resetVars map[string]string
+		err = json.Unmarshal(body, &resetVars)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal request body.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		email, err := token.NormalizeEmail(resetVars["email"])
+		if err != nil {
+			servertools.RespondError(w, http.StatusBadRequest, ErrorInvalidEmail)
+			return
+		}
+
+		// the reset is handled in the background, so the response time doesn't tell whether the user exists
+		go sendPasswordReset(db, mailer, email, resetURL, lifetime)
+
+		servertools.RespondCode(w, http.StatusAccepted)
+	}
+}
+
+func sendPasswordReset(db *sql.DB, mailer mail.Mailer, email string, resetURL string, lifetime time.Duration) {
+
+	err := database.RemoveExpiredPasswordResetTokens(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired password reset tokens.")
+	}
+
+	user, err := database.GetUserByEmail(db, email)
+	if err != nil {
+		log.Info().Msg("Password reset was requested for an unknown email.")
+		return
+	}
+	if user.Suspended {
+		log.Info().Int("user", user.ID).Msg("Password reset was requested for a suspended user.")
+		return
+	}
+
+	resetToken, err := database.GeneratePasswordResetToken(db, user, lifetime)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate password reset token.")
+		return
+	}
+
+	err = mailer.Send(&mail.Message{
+		To:      user.Email,
+		Subject: "Reset your FestivalsApp password",
+		Body: "Hello,\n\nsomeone requested to reset the password of your FestivalsApp account.\n" +
+			"Use the following link to choose a new password, it is valid for " + lifetime.String() + ":\n\n" +
+			resetURL + resetToken + "\n\n" +
+			"If you did not request a password reset you can ignore this email.\n",
+	})
+	if err != nil {
+		log.Error().Err(err).Int("user", user.ID).Msg("Failed to send password reset email.")
+		return
+	}
+	log.Info().Int("user", user.ID).Msg("Sent password reset email.")
+}
+
+// ConfirmPasswordReset returns a handler that sets the password of the user the password reset token was issued to
+// and revokes all sessions of the user. The new password needs to satisfy the password policy.
+func ConfirmPasswordReset(policy *token.PasswordPolicy) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read request body.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		var resetVars map[string]string
+		err = json.Unmarshal(body, &resetVars)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal request body.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		resetToken := resetVars["token"]
+		password := resetVars["password"]
+		if resetToken == "" {
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		if !checkPassword(w, policy, password) {
+			return
+		}
+
+		// hash the password before the token is used up, so a failure doesn't waste the token
+		passwordHash, err := auth.PasswordHasher.Hash(password)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate password hash from provided password.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		userID, err := database.UsePasswordResetToken(db, resetToken)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Error().Msg("Password reset token is invalid, expired or was already used.")
+			servertools.UnauthorizedResponse(w)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to use password reset token.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		_, err = database.SetPasswordForUser(db, userID, passwordHash)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set new password.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		revokeAllSessions(db, userID)
+
+		log.Info().Str("user", userID).Msg("Password was reset.")
+		servertools.RespondCode(w, http.StatusOK)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+func GetRevocationList(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	list, err := database.GetRevocationList(db, auth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch revocation list.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	respondJSONWithETag(w, r, list)
+}
+
+func Logout(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	err := database.RevokeAccessToken(db, claims)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke access token.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	// the refresh token of the device is optional, clients that send it will not be able to refresh anymore
+	body, err := io.ReadAll(r.Body)
+	if err == nil && len(body) > 0 {
+		var logoutVars map[string]string
+		err = json.Unmarshal(body, &logoutVars)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal request body.")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		presentedToken := logoutVars["refresh_token"]
+		if presentedToken != "" {
+			storedToken, err := database.GetRefreshToken(db, presentedToken)
+			if err == nil && fmt.Sprint(storedToken.UserID) == claims.UserID {
+				err = database.RevokeRefreshTokenFamily(db, storedToken.Family)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to revoke refresh token family.")
+				}
+			}
+		}
+	}
+
+	err = database.RemoveExpiredRevocations(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired revocations.")
+	}
+
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+func RevokeSessions(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to revoke sessions.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	_, err = database.GetUserByID(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	if !revokeAllSessions(db, userID) {
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("admin", claims.UserID).Msg("All sessions of user were revoked.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+// revokeAllSessions revokes every access and refresh token of the given user.
+func revokeAllSessions(db *sql.DB, userID string) bool {
+
+	err := database.RevokeSessionsForUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke access tokens of user.")
+		return false
+	}
+	err = database.RevokeRefreshTokensForUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke refresh tokens of user.")
+		return false
+	}
+	return true
+}
+
+// revokeAccessTokens revokes the access tokens of the given user after its claims changed,
+// the user can obtain new tokens with updated claims by using a refresh token.
+func revokeAccessTokens(db *sql.DB, userID string) {
+
+	err := database.RevokeSessionsForUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke access tokens of user.")
+	}
+}
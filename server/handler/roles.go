@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// roleChanges are the fields of a role that can be set by admins.
+type roleChanges struct {
+	Permissions []string `json:"role_permissions"`
+}
+
+func (changes *roleChanges) valid() bool {
+	return changes.Permissions != nil && token.ValidPermissions(changes.Permissions)
+}
+
+// GetPermissions returns the permission catalogue.
+func GetPermissions(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionRolesRead) {
+		log.Error().Msg("User is not authorized to get permissions.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, token.Permissions)
+}
+
+func GetRoles(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionRolesRead) {
+		log.Error().Msg("User is not authorized to get roles.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	roles, err := database.GetAllRoles(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch all roles.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, roles)
+}
+
+func GetRole(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionRolesRead) {
+		log.Error().Msg("User is not authorized to get roles.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	roleID, err := objectID(r)
+	if err != nil || roleID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	role, err := database.GetRole(db, roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch role.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, role)
+}
+
+// UpdateRole replaces the permissions of the role and revokes the access tokens of its users,
+// so they get the new permissions with their next refresh.
+func UpdateRole(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionRolesWrite) {
+		log.Error().Msg("User is not authorized to update roles.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	roleID, err := objectID(r)
+	if err != nil || roleID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	role, err := database.GetRole(db, roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch role.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	changes := roleChanges{Permissions: role.Permissions}
+	err = decodeChanges(r, &changes)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	if !changes.valid() {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+	// users can't take the permission to change roles from their own role, so nobody locks themselves out
+	if role.ID == claims.UserRole && !slices.Contains(changes.Permissions, token.PermissionRolesWrite) {
+		log.Error().Str("user", claims.UserID).Msg("User tried to remove the permission to change roles from their own role.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	err = database.SetRolePermissions(db, role.ID, changes.Permissions)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update role.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	err = database.RevokeSessionsForRole(db, role.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke access tokens of the users with the role.")
+	}
+	log.Info().Str("role", strconv.Itoa(role.ID)).Str("admin", claims.UserID).Strs("permissions", changes.Permissions).Msg("Permissions of role were changed.")
+
+	updatedRole, err := database.GetRole(db, roleID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch updated role.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, updatedRole)
+}
@@ -2,10 +2,10 @@ package handler
 
 import (
 	"database/sql"
-	"encoding/json"
-	"io"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 	"github.com/Festivals-App/festivals-identity-server/server/database"
@@ -13,6 +13,18 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// serviceKeyChanges are the fields of an service key that can be set by admins.
+type serviceKeyChanges struct {
+	Comment   string     `json:"service_key_comment"`
+	Enabled   bool       `json:"service_key_enabled"`
+	ExpiresAt *time.Time `json:"service_key_expiresat"`
+	Scopes    []string   `json:"service_key_scopes"`
+}
+
+func (changes *serviceKeyChanges) valid() bool {
+	return changes.Comment != "" && changes.Scopes != nil && token.ValidServiceKeyScopes(changes.Scopes)
+}
+
 func GetServiceKeys(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	keys, err := database.GetAllServiceKeys(db)
 	if err != nil {
@@ -20,138 +32,162 @@ func GetServiceKeys(auth *token.AuthService, db *sql.DB, w http.ResponseWriter,
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
-	servertools.RespondJSON(w, http.StatusOK, keys)
+	respondJSONWithETag(w, r, keys)
 }
 
-func AddServiceKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func GetServiceKey(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to create service keys.")
-		servertools.UnauthorizedResponse(w)
+	keyID, err := objectID(r)
+	if err != nil || keyID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to read request body.")
-		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	key, err := database.GetServiceKey(db, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 		return
 	}
-	var servicekeyValue map[string]string
-	err = json.Unmarshal(body, &servicekeyValue)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		log.Error().Err(err).Msg("Failed to fetch service key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
+	servertools.RespondJSON(w, http.StatusOK, key)
+}
 
-	newServiceKey := servicekeyValue["service_key"]
-	if newServiceKey == "" {
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+func AddServiceKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to create service keys.")
+		servertools.UnauthorizedResponse(w)
 		return
 	}
 
-	newServiceKeyComment := servicekeyValue["service_key_comment"]
-	if newServiceKeyComment == "" {
+	changes := serviceKeyChanges{Enabled: true, Scopes: token.ServiceKeyScopes}
+	err := decodeChanges(r, &changes)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
-
-	serviceKey := token.ServiceKey{
-		ID:      0,
-		Key:     newServiceKey,
-		Comment: newServiceKeyComment,
+	if !changes.valid() {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
 	}
 
-	err = database.AddServiceKey(db, serviceKey)
+	creatorID, err := strconv.Atoi(claims.UserID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to add service key.")
+		log.Error().Err(err).Msg("Failed to read user ID from claims.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	servertools.RespondCode(w, http.StatusCreated)
-}
-
-func UpdateServiceKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
-
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to update service keys.")
-		servertools.UnauthorizedResponse(w)
+	serviceKey, err := token.NewServiceKey(changes.Comment)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate service key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
+	serviceKey.Enabled = changes.Enabled
+	serviceKey.ExpiresAt = changes.ExpiresAt
+	serviceKey.Scopes = changes.Scopes
+	serviceKey.CreatedBy = &creatorID
 
-	body, err := io.ReadAll(r.Body)
+	serviceKeyID, err := database.AddServiceKey(db, *serviceKey)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to read request body.")
+		log.Error().Err(err).Msg("Failed to add service key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
-	var servicekeyValue map[string]string
-	err = json.Unmarshal(body, &servicekeyValue)
+
+	createdKey, err := database.GetServiceKey(db, strconv.Itoa(serviceKeyID))
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		log.Error().Err(err).Msg("Failed to fetch created service key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	serviceKey := servicekeyValue["service_key"]
-	if serviceKey == "" {
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	// the key is only returned once, afterwards only its prefix and hash are known
+	createdKey.Key = serviceKey.Key
+	servertools.RespondJSON(w, http.StatusCreated, createdKey)
+}
+
+func UpdateServiceKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to update service keys.")
+		servertools.UnauthorizedResponse(w)
 		return
 	}
 
-	serviceKeyComment := servicekeyValue["service_key_comment"]
-	if serviceKeyComment == "" {
+	keyID, err := objectID(r)
+	if err != nil || keyID == "" {
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
 
-	keyIDString, err := objectID(r)
+	serviceKey, err := database.GetServiceKey(db, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
 	if err != nil {
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		log.Error().Err(err).Msg("Failed to fetch service key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	id, err := strconv.Atoi(keyIDString)
+	// fields missing in the request body keep their current value
+	changes := serviceKeyChanges{Comment: serviceKey.Comment, Enabled: serviceKey.Enabled, ExpiresAt: serviceKey.ExpiresAt, Scopes: serviceKey.Scopes}
+	err = decodeChanges(r, &changes)
 	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
-
-	key := token.ServiceKey{
-		ID:      id,
-		Key:     serviceKey,
-		Comment: serviceKeyComment,
+	if !changes.valid() {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
 	}
+	serviceKey.Comment = changes.Comment
+	serviceKey.Enabled = changes.Enabled
+	serviceKey.ExpiresAt = changes.ExpiresAt
+	serviceKey.Scopes = changes.Scopes
 
-	err = database.UpdateServiceKey(db, key)
+	err = database.UpdateServiceKey(db, *serviceKey)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to update service key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	servertools.RespondCode(w, http.StatusOK)
+	updatedKey, err := database.GetServiceKey(db, keyID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch updated service key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, updatedKey)
 }
 
 func DeleteServiceKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionKeysWrite) {
 		log.Error().Msg("User is not authorized to delete service keys.")
 		servertools.UnauthorizedResponse(w)
 		return
 	}
 
 	keyID, err := objectID(r)
-	if err != nil {
+	if err != nil || keyID == "" {
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
 
 	err = database.RemoveServiceKey(db, keyID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to delete api key.")
+		log.Error().Err(err).Msg("Failed to delete service key.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
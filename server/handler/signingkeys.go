@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	servertools "github.com/Festivals-App/festivals-server-tools"
+	"github.com/rs/zerolog/log"
+)
+
+// jsonWebKeySetMaxAge is the time clients may cache the JSON web key set.
+const jsonWebKeySetMaxAge = 5 * time.Minute
+
+// signingKeyPublicationTime is the time a next key needs to be published before it can be promoted. Besides the cached
+// key sets, every identity server instance needs to reload the key ring and every validation service its keys.
+const signingKeyPublicationTime = jsonWebKeySetMaxAge + 2*time.Minute
+
+func GetSigningKeys(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysRead) {
+		log.Error().Msg("User is not authorized to get signing keys.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	keys, err := database.GetAllSigningKeys(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch all signing keys.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, keys)
+}
+
+func AddSigningKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to create signing keys.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	key, err := token.GenerateSigningKey(auth.Algorithm)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate signing key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	err = database.AddSigningKey(db, key)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to add signing key.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if !ReloadSigningKeys(auth, db) {
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("kid", key.ID).Str("admin", claims.UserID).Msg("Signing key was created.")
+	servertools.RespondJSON(w, http.StatusCreated, key)
+}
+
+func PromoteSigningKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to promote signing keys.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	keyID, err := objectID(r)
+	if err != nil || keyID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	err = database.PromoteSigningKey(db, keyID, signingKeyPublicationTime)
+	if errors.Is(err, database.ErrSigningKeyNotPublished) {
+		log.Error().Str("kid", keyID).Msg("Signing key was promoted before it was published long enough.")
+		servertools.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to promote signing key.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	if !ReloadSigningKeys(auth, db) {
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("kid", keyID).Str("admin", claims.UserID).Msg("Signing key was promoted.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+func RetireSigningKey(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionKeysWrite) {
+		log.Error().Msg("User is not authorized to retire signing keys.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	keyID, err := objectID(r)
+	if err != nil || keyID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	err = database.RetireSigningKey(db, keyID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to retire signing key.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	if !ReloadSigningKeys(auth, db) {
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("kid", keyID).Str("admin", claims.UserID).Msg("Signing key was retired.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+// ReloadSigningKeys loads the key ring from the database. If there are no signing keys yet,
+// the key configured in the config file is stored as the active signing key.
+func ReloadSigningKeys(auth *token.AuthService, db *sql.DB) bool {
+
+	keys, err := database.GetAllSigningKeys(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load signing keys from database.")
+		return false
+	}
+
+	if len(keys) == 0 {
+		err = database.AddSigningKey(db, auth.ConfiguredKey)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to store configured signing key.")
+			return false
+		}
+		log.Info().Str("kid", auth.ConfiguredKey.ID).Msg("Stored configured signing key as active signing key.")
+		keys = append(keys, *auth.ConfiguredKey)
+	}
+
+	// if the configured algorithm changed, the configured key replaces the active key
+	if activeAlgorithm(keys) != auth.Algorithm {
+		keys, err = promoteConfiguredKey(auth, db, keys)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to promote configured signing key.")
+			return false
+		}
+	}
+
+	err = auth.SetSigningKeys(keys)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set signing keys.")
+		return false
+	}
+	return true
+}
+
+func activeAlgorithm(keys []token.SigningKey) string {
+	for _, key := range keys {
+		if key.State == token.KeyStateActive {
+			return key.Algorithm
+		}
+	}
+	return ""
+}
+
+func promoteConfiguredKey(auth *token.AuthService, db *sql.DB, keys []token.SigningKey) ([]token.SigningKey, error) {
+
+	known := false
+	for _, key := range keys {
+		known = known || key.ID == auth.ConfiguredKey.ID
+	}
+	if !known {
+		key := *auth.ConfiguredKey
+		key.State = token.KeyStateNext
+		err := database.AddSigningKey(db, &key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the algorithm can only change with a restart, so the configured key has to sign right away
+	err := database.PromoteSigningKey(db, auth.ConfiguredKey.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	log.Warn().Str("kid", auth.ConfiguredKey.ID).Str("algorithm", auth.Algorithm).Msg("The signing algorithm changed, the configured signing key is now the active signing key.")
+	return database.GetAllSigningKeys(db)
+}
@@ -12,7 +12,7 @@ import (
 
 func GetVersion(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionServerRead) {
 		log.Error().Msg("User is not authorized to get server version.")
 		servertools.UnauthorizedResponse(w)
 		return
@@ -23,7 +23,7 @@ func GetVersion(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w
 
 func GetInfo(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionServerRead) {
 		log.Error().Msg("User is not authorized to get server info.")
 		servertools.UnauthorizedResponse(w)
 		return
@@ -34,7 +34,7 @@ func GetInfo(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w ht
 
 func GetHealth(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
+	if !claims.HasPermission(token.PermissionServerRead) {
 		log.Error().Msg("User is not authorized to get server health.")
 		servertools.UnauthorizedResponse(w)
 		return
@@ -42,3 +42,14 @@ func GetHealth(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w
 
 	servertools.RespondCode(w, status.HealthStatus())
 }
+
+func GetMetrics(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionServerRead) {
+		log.Error().Msg("User is not authorized to get server metrics.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	servertools.RespondJSON(w, http.StatusOK, status.MetricsString())
+}
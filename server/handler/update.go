@@ -12,8 +12,8 @@ import (
 
 func MakeUpdate(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to get server version.")
+	if !claims.HasPermission(token.PermissionServerUpdate) {
+		log.Error().Msg("User is not authorized to update the server.")
 		servertools.UnauthorizedResponse(w)
 		return
 	}
@@ -3,98 +3,202 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"time"
 
 	token "github.com/Festivals-App/festivals-identity-server/auth"
 	"github.com/Festivals-App/festivals-identity-server/server/database"
+	"github.com/Festivals-App/festivals-identity-server/server/mail"
 	servertools "github.com/Festivals-App/festivals-server-tools"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func Signup(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+// Signup returns a handler that creates unverified users and emails them a link to verify their email.
+// Emails with a domain on the given blocklist and passwords that violate the password policy are rejected.
+func Signup(mailer mail.Mailer, blocklist *token.EmailDomainBlocklist, policy *token.PasswordPolicy, verificationURL string, lifetime time.Duration) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to read request body.")
-		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-		return
-	}
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	var signupVars map[string]string
-	err = json.Unmarshal(body, &signupVars)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshal request body.")
-		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-		return
-	}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read request body.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
 
-	email := signupVars["email"]
-	password := signupVars["password"]
+		var signupVars map[string]string
+		err = json.Unmarshal(body, &signupVars)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal request body.")
+			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
 
-	if validEmail(email) && validPassword(password) {
+		email, err := token.NormalizeEmail(signupVars["email"])
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to signup with invalid email.")
+			servertools.RespondError(w, http.StatusBadRequest, ErrorInvalidEmail)
+			return
+		}
+		if blocklist.Blocks(email) {
+			log.Error().Str("domain", token.EmailDomain(email)).Msg("Failed to signup with blocked email domain.")
+			servertools.RespondError(w, http.StatusBadRequest, ErrorEmailDomainNotAllowed)
+			return
+		}
+		password := signupVars["password"]
+		if !checkPassword(w, policy, password, passwordUserInputs(email)...) {
+			return
+		}
 
-		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		passwordHash, err := auth.PasswordHasher.Hash(password)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to generate password hash from provided password.")
 			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
 		}
 
-		_, err = database.CreateUserWithEmailAndPasswordHash(db, email, string(passwordHash))
+		_, err = database.CreateUserWithEmailAndPasswordHash(db, email, passwordHash)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to create user with given email and password.")
 			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
 		}
 
+		go sendEmailVerification(db, mailer, email, verificationURL, lifetime)
+
 		servertools.RespondCode(w, http.StatusCreated)
-		return
 	}
-
-	// If the Authentication header is not present, is invalid, or the username or password is wrong
-	servertools.UnauthorizedResponse(w)
 }
 
-func Login(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+// Login returns a handler that logs users in with their email and password. Logins of an email or from an IP address
+// are locked after too many failed attempts as configured by the given throttle. Users that need a second factor
+// as configured by the given MFA policy get an MFA challenge instead of the tokens.
+func Login(throttle *token.LoginThrottle, mfa *token.MFAPolicy) func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	// Extract the username and password from the request
-	// Authorization header. If no Authentication header is present
-	// or the header value is invalid, then the 'ok' return value
-	// will be false.
-	email, password, ok := r.BasicAuth()
+	return func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if ok {
+		// Extract the username and password from the request
+		// Authorization header. If no Authentication header is present
+		// or the header value is invalid, then the 'ok' return value
+		// will be false.
+		email, password, ok := r.BasicAuth()
 
-		// retrieve user for the given username
-		requestedUser, err := database.GetUserByEmail(db, email)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to fetch user.")
-			// do i need to mitigate timing attacks on email guessing?
-			servertools.UnauthorizedResponse(w)
-			return
-		}
+		if ok {
 
-		err = bcrypt.CompareHashAndPassword([]byte(requestedUser.PasswordHash), []byte(password))
-		// If the password is correct return the authentication jwt token
-		if err == nil {
-			token, err := database.GenerateAccessToken(requestedUser, db, auth)
+			ip := throttle.ClientIP(r)
+			peer := token.GetPeerName(r)
+			if normalized, err := token.NormalizeEmail(email); err == nil {
+				email = normalized
+			}
+
+			delay, err := loginDelay(db, throttle, email, ip)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to generate access token for user.")
+				log.Error().Err(err).Msg("Failed to fetch failed login attempts.")
 				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 				return
 			}
-			servertools.RespondString(w, http.StatusOK, token)
-			return
-		} else {
-			log.Error().Err(err).Msg("The password provided was wrong.")
+			if delay > 0 {
+				log.Error().Str("ip", ip).Msg("Login is locked after too many failed attempts.")
+				tooManyLoginAttemptsResponse(w, delay)
+				return
+			}
+
+			// retrieve user for the given username
+			requestedUser, err := database.GetUserByEmail(db, email)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to fetch user.")
+				// verify the password anyway, so the response time doesn't tell whether the user exists
+				auth.PasswordHasher.VerifyDummy(password)
+				recordLoginAttempt(db, email, nil, ip, peer, false)
+				servertools.UnauthorizedResponse(w)
+				return
+			}
+
+			matches, err := auth.PasswordHasher.Verify(password, requestedUser.PasswordHash)
+			// If the password is correct return the authentication jwt token
+			if matches {
+				if requestedUser.Suspended {
+					log.Error().Str("user", fmt.Sprint(requestedUser.ID)).Msg("Suspended user tried to login.")
+					suspendedResponse(w)
+					return
+				}
+				if !requestedUser.Verified {
+					log.Error().Str("user", fmt.Sprint(requestedUser.ID)).Msg("Unverified user tried to login.")
+					unverifiedResponse(w)
+					return
+				}
+				// only the hashes of users that may login are upgraded, the password is not known after the MFA challenge
+				upgradePasswordHash(auth, db, requestedUser, password)
+				totp, err := getTOTP(db, fmt.Sprint(requestedUser.ID))
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to fetch TOTP enrollment of user.")
+					servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+				enrolled := totp != nil && totp.IsConfirmed()
+				if mfa.Required(requestedUser, enrolled) {
+					// the password alone doesn't clear the failed attempts, so codes can't be guessed with a known password
+					startMFAChallenge(db, mfa, w, requestedUser, !enrolled, token.GetDeviceName(r))
+					return
+				}
+				recordLoginAttempt(db, email, requestedUser, ip, peer, true)
+				issueSession(auth, db, w, requestedUser, token.GetDeviceName(r))
+				return
+			} else {
+				recordLoginAttempt(db, email, requestedUser, ip, peer, false)
+				log.Error().Err(err).Msg("The password provided was wrong.")
+			}
 		}
+
+		// If the Authentication header is not present, is invalid, or the username or password is wrong
+		servertools.UnauthorizedResponse(w)
+	}
+}
+
+// issueSession responds with a new access token and sends a new refresh token for the device in the Refresh-Token header.
+func issueSession(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, user *token.User, device string) {
+
+	accessToken, err := database.GenerateAccessToken(user, db, auth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate access token for user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	err = database.RemoveExpiredRefreshTokensForUser(db, user)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired refresh tokens for user.")
+	}
+	refreshToken, err := database.GenerateRefreshToken(user, "", device, nil, nil, db, auth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate refresh token for user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
 	}
+	w.Header().Set("Refresh-Token", refreshToken)
+	servertools.RespondString(w, http.StatusOK, accessToken)
+}
 
-	// If the Authentication header is not present, is invalid, or the username or password is wrong
-	servertools.UnauthorizedResponse(w)
+// upgradePasswordHash hashes the password of the user again if the stored hash was made with
+// another algorithm or outdated parameters. Failures are only logged, the old hash stays valid.
+func upgradePasswordHash(auth *token.AuthService, db *sql.DB, user *token.User, password string) {
+
+	if !auth.PasswordHasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	passwordHash, err := auth.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Error().Err(err).Str("user", fmt.Sprint(user.ID)).Msg("Failed to upgrade password hash.")
+		return
+	}
+	err = database.UpgradePasswordHash(db, fmt.Sprint(user.ID), user.PasswordHash, passwordHash)
+	if err != nil {
+		log.Error().Err(err).Str("user", fmt.Sprint(user.ID)).Msg("Failed to upgrade password hash.")
+		return
+	}
+	log.Info().Str("user", fmt.Sprint(user.ID)).Msg("Upgraded password hash.")
 }
 
 func Refresh(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
@@ -106,6 +210,12 @@ func Refresh(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w ht
 		return
 	}
 
+	if requestedUser.Suspended {
+		log.Error().Str("user", claims.UserID).Msg("Suspended user tried to refresh access token.")
+		suspendedResponse(w)
+		return
+	}
+
 	token, err := database.RegenerateAccessToken(requestedUser, claims, db, auth)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to regenerate access token for user.")
@@ -115,110 +225,327 @@ func Refresh(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w ht
 	servertools.RespondString(w, http.StatusOK, token)
 }
 
-func GetUsers(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func ExchangeRefreshToken(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to get user summaries.")
-		servertools.UnauthorizedResponse(w)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read request body.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	users, err := database.GetAllUserSummaries(db)
+	var refreshVars map[string]string
+	err = json.Unmarshal(body, &refreshVars)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to fetch all user summaries.")
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	presentedToken := refreshVars["refresh_token"]
+	if presentedToken == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	storedToken, requestedUser, err := rotateRefreshToken(db, presentedToken, nil)
+	if errors.Is(err, errInvalidRefreshToken) {
 		servertools.UnauthorizedResponse(w)
 		return
 	}
-	servertools.RespondJSON(w, http.StatusOK, users)
-}
+	if errors.Is(err, errAccountSuspended) {
+		suspendedResponse(w)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to rotate refresh token.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
 
-func ChangePassword(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	accessToken, err := database.GenerateAccessToken(requestedUser, db, auth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate access token for user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
 
-	userID, err := objectID(r)
+	refreshToken, err := database.GenerateRefreshToken(requestedUser, storedToken.Family, storedToken.Device, storedToken.ClientID, storedToken.Scopes, db, auth)
 	if err != nil {
-		log.Error().Err(err).Msg("User did not provide a user id")
-		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		log.Error().Err(err).Msg("Failed to generate refresh token for user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	if claims.UserID == userID {
+	servertools.RespondJSON(w, http.StatusOK, token.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.TokenLifetime.Seconds()),
+	})
+}
 
-		body, err := io.ReadAll(r.Body)
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errAccountSuspended    = errors.New(ErrorAccountSuspended)
+)
+
+// rotateRefreshToken marks the presented refresh token as used and returns it together with its user. The token needs
+// to be issued to the given OAuth client or to a login if the client is nil. It returns errInvalidRefreshToken if the
+// token is unknown, revoked, expired or was issued to someone else and errAccountSuspended if the user is suspended.
+func rotateRefreshToken(db *sql.DB, presentedToken string, clientID *int) (*token.RefreshToken, *token.User, error) {
+
+	storedToken, err := database.GetRefreshToken(db, presentedToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Error().Msg("Unknown refresh token was presented.")
+		return nil, nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if storedToken.Revoked || storedToken.Expired {
+		log.Error().Int("user", storedToken.UserID).Msg("Revoked or expired refresh token was presented.")
+		return nil, nil, errInvalidRefreshToken
+	}
+	if (storedToken.ClientID == nil) != (clientID == nil) || (clientID != nil && *storedToken.ClientID != *clientID) {
+		log.Error().Int("user", storedToken.UserID).Msg("Refresh token was presented by someone it wasn't issued to.")
+		return nil, nil, errInvalidRefreshToken
+	}
+
+	// A refresh token can only be used once, if it is presented again it was most likely stolen,
+	// so we revoke every token that was rotated from the same login.
+	rotated, err := database.UseRefreshToken(db, storedToken.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rotated {
+		log.Warn().Int("user", storedToken.UserID).Str("family", storedToken.Family).Msg("Refresh token reuse detected, revoking token family.")
+		err = database.RevokeRefreshTokenFamily(db, storedToken.Family)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to read request body.")
-			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-			return
+			log.Error().Err(err).Msg("Failed to revoke refresh token family.")
 		}
+		return nil, nil, errInvalidRefreshToken
+	}
 
-		var passwordChangeVars map[string]string
-		err = json.Unmarshal(body, &passwordChangeVars)
+	requestedUser, err := database.GetUserByID(db, fmt.Sprint(storedToken.UserID))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		return nil, nil, errInvalidRefreshToken
+	}
+
+	if requestedUser.Suspended {
+		log.Error().Int("user", requestedUser.ID).Msg("Suspended user tried to exchange refresh token.")
+		err = database.RevokeRefreshTokenFamily(db, storedToken.Family)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to unmarshal request body.")
-			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-			return
+			log.Error().Err(err).Msg("Failed to revoke refresh token family.")
 		}
+		return nil, nil, errAccountSuspended
+	}
+	return storedToken, requestedUser, nil
+}
 
-		oldpassword := passwordChangeVars["old-password"]
-		newpassword := passwordChangeVars["new-password"]
+func GetUsers(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-		if validPassword(newpassword) && newpassword != oldpassword {
+	if !claims.HasPermission(token.PermissionUsersRead) {
+		log.Error().Msg("User is not authorized to get user summaries.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
 
-			// retrieve user for the given username
-			requestedUser, err := database.GetUserByID(db, userID)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to fetch user.")
-				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-				return
-			}
+	users, err := database.GetAllUserSummaries(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch all user summaries.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+	servertools.RespondJSON(w, http.StatusOK, users)
+}
 
-			err = bcrypt.CompareHashAndPassword([]byte(requestedUser.PasswordHash), []byte(oldpassword))
-			if err != nil {
-				log.Error().Err(err).Msg("Old password is incorrect.")
-				servertools.UnauthorizedResponse(w)
-				return
-			}
+// ChangePassword returns a handler that lets users change their password, the new password needs to satisfy the password policy.
+func ChangePassword(policy *token.PasswordPolicy) func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+		userID, err := objectID(r)
+		if err != nil {
+			log.Error().Err(err).Msg("User did not provide a user id")
+			servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		if claims.UserID == userID {
 
-			passwordHash, err := bcrypt.GenerateFromPassword([]byte(newpassword), bcrypt.DefaultCost)
+			body, err := io.ReadAll(r.Body)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to generate password hash from provided password.")
+				log.Error().Err(err).Msg("Failed to read request body.")
 				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 				return
 			}
 
-			_, err = database.SetPasswordForUser(db, userID, string(passwordHash))
+			var passwordChangeVars map[string]string
+			err = json.Unmarshal(body, &passwordChangeVars)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to set new password for user.")
+				log.Error().Err(err).Msg("Failed to unmarshal request body.")
 				servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 				return
 			}
 
-			servertools.RespondCode(w, http.StatusOK)
-			return
+			oldpassword := passwordChangeVars["old-password"]
+			newpassword := passwordChangeVars["new-password"]
+
+			if newpassword != "" && newpassword != oldpassword {
+
+				// retrieve user for the given username
+				requestedUser, err := database.GetUserByID(db, userID)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to fetch user.")
+					servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+
+				matches, err := auth.PasswordHasher.Verify(oldpassword, requestedUser.PasswordHash)
+				if !matches {
+					log.Error().Err(err).Msg("Old password is incorrect.")
+					servertools.UnauthorizedResponse(w)
+					return
+				}
+
+				if !checkPassword(w, policy, newpassword, passwordUserInputs(requestedUser.Email)...) {
+					return
+				}
+
+				passwordHash, err := auth.PasswordHasher.Hash(newpassword)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to generate password hash from provided password.")
+					servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+
+				_, err = database.SetPasswordForUser(db, userID, passwordHash)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to set new password for user.")
+					servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+
+				revokeAllSessions(db, userID)
+
+				servertools.RespondCode(w, http.StatusOK)
+				return
+			} else {
+				log.Error().Err(err).Msg("User did not provide a new password when trying to change the password.")
+			}
 		} else {
-			log.Error().Err(err).Msg("User did not provide new or valid password when trying to change the password.")
+			log.Error().Err(err).Msg("User did not provide correct user id. Provided '" + userID + "' but expected '" + claims.UserID + "'.")
 		}
-	} else {
-		log.Error().Err(err).Msg("User did not provide correct user id. Provided '" + userID + "' but expected '" + claims.UserID + "'.")
-	}
 
-	servertools.UnauthorizedResponse(w)
+		servertools.UnauthorizedResponse(w)
+	}
 }
 
 func SuspendUser(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to get users.")
+	if !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to suspend users.")
 		servertools.UnauthorizedResponse(w)
 		return
 	}
-	// /users/{objectID}/suspend
-	servertools.UnauthorizedResponse(w)
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	if userID == claims.UserID {
+		log.Error().Msg("Admin tried to suspend their own account.")
+		servertools.RespondError(w, http.StatusBadRequest, "admins can not suspend their own account")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read request body.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	var suspendVars map[string]string
+	err = json.Unmarshal(body, &suspendVars)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal request body.")
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	reason := suspendVars["reason"]
+	if reason == "" || len(reason) > 255 {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	_, err = database.GetUserByID(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	_, err = database.SuspendUser(db, userID, reason, claims.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to suspend user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	revokeAllSessions(db, userID)
+
+	log.Info().Str("user", userID).Str("admin", claims.UserID).Msg("User was suspended.")
+	servertools.RespondCode(w, http.StatusOK)
+}
+
+func UnsuspendUser(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+
+	if !claims.HasPermission(token.PermissionUsersWrite) {
+		log.Error().Msg("User is not authorized to unsuspend users.")
+		servertools.UnauthorizedResponse(w)
+		return
+	}
+
+	userID, err := objectID(r)
+	if err != nil || userID == "" {
+		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		return
+	}
+
+	requestedUser, err := database.GetUserByID(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch user.")
+		servertools.RespondError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	if !requestedUser.Suspended {
+		servertools.RespondCode(w, http.StatusOK)
+		return
+	}
+
+	_, err = database.UnsuspendUser(db, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unsuspend user.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	log.Info().Str("user", userID).Str("admin", claims.UserID).Msg("User was unsuspended.")
+	servertools.RespondCode(w, http.StatusOK)
 }
 
 func SetUserRole(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
-	if claims.UserRole != token.ADMIN {
-		log.Error().Msg("User is not authorized to get users.")
+	if !claims.HasPermission(token.PermissionRolesWrite) {
+		log.Error().Msg("User is not authorized to set the role of users.")
 		servertools.UnauthorizedResponse(w)
 		return
 	}
@@ -234,19 +561,27 @@ func SetUserRole(auth *token.AuthService, claims *token.UserClaims, db *sql.DB,
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
-	resourceID, err := strconv.ParseInt(resourceIDstring, 10, 64)
-	if err != nil || (resourceID != int64(token.ADMIN) && resourceID != int64(token.CREATOR)) {
+	// every role stored in the roles table can be assigned
+	role, err := database.GetRole(db, resourceIDstring)
+	if errors.Is(err, sql.ErrNoRows) {
 		servertools.RespondError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
 		return
 	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch role.")
+		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
 
-	_, err = database.SetRoleForUser(db, userID, int(resourceID))
+	_, err = database.SetRoleForUser(db, userID, role.ID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to set new role for user.")
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
+	revokeAccessTokens(db, userID)
+
 	servertools.RespondCode(w, http.StatusOK)
 }
 
@@ -356,5 +691,6 @@ func RemoveEntityForUser(entity database.Entity, db *sql.DB, w http.ResponseWrit
 		servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
+	revokeAccessTokens(db, userID)
 	servertools.RespondCode(w, http.StatusOK)
 }
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	token "github.com/Festivals-App/festivals-identity-server/auth"
+	"github.com/Festivals-App/festivals-identity-server/server/database"
+	"github.com/Festivals-App/festivals-identity-server/server/status"
+	"github.com/rs/zerolog/log"
+)
+
+// keyCache holds the hashes of all usable API and service keys in memory, so authenticating
+// requests does not need to query the database. It is reloaded periodically and
+// whenever keys are changed on this instance. The IDs of used keys are collected and
+// their last used date is written to the database on the next reload.
+type keyCache struct {
+	db          *sql.DB
+	apiKeys     atomic.Pointer[map[string]token.APIKey]
+	serviceKeys atomic.Pointer[map[string]token.ServiceKey]
+
+	usedLock        sync.Mutex
+	usedAPIKeys     map[int]struct{}
+	usedServiceKeys map[int]struct{}
+}
+
+func newKeyCache(db *sql.DB) *keyCache {
+	return &keyCache{db: db, usedAPIKeys: map[int]struct{}{}, usedServiceKeys: map[int]struct{}{}}
+}
+
+// Reload replaces the cached keys with the usable keys stored in the database.
+func (cache *keyCache) Reload() error {
+
+	cache.flushUsedKeys()
+
+	apiKeys, err := database.GetAllAPIKeys(cache.db)
+	if err != nil {
+		status.KeyCacheReloadFailures.Add(1)
+		return errors.New("failed to load API keys: " + err.Error())
+	}
+	serviceKeys, err := database.GetAllServiceKeys(cache.db)
+	if err != nil {
+		status.KeyCacheReloadFailures.Add(1)
+		return errors.New("failed to load service keys: " + err.Error())
+	}
+
+	apiKeyHashes := make(map[string]token.APIKey, len(apiKeys))
+	for _, key := range apiKeys {
+		if key.IsUsable() {
+			apiKeyHashes[key.Hash] = key
+		}
+	}
+	serviceKeyHashes := make(map[string]token.ServiceKey, len(serviceKeys))
+	for _, key := range serviceKeys {
+		if key.IsUsable() {
+			serviceKeyHashes[key.Hash] = key
+		}
+	}
+	cache.apiKeys.Store(&apiKeyHashes)
+	cache.serviceKeys.Store(&serviceKeyHashes)
+	status.KeyCacheReloads.Add(1)
+	return nil
+}
+
+func (cache *keyCache) APIKey(key string) (*token.APIKey, error) {
+
+	keys := cache.apiKeys.Load()
+	if keys == nil {
+		return nil, errors.New("the key cache is not loaded")
+	}
+	if key == "" {
+		status.KeyCacheMisses.Add(1)
+		return nil, nil
+	}
+	// keys that expired since the last reload are not usable anymore
+	apiKey, ok := (*keys)[token.HashKey(key)]
+	if !ok || !apiKey.IsUsable() {
+		status.KeyCacheMisses.Add(1)
+		return nil, nil
+	}
+	status.KeyCacheHits.Add(1)
+	cache.markUsed(cache.usedAPIKeys, apiKey.ID)
+	return &apiKey, nil
+}
+
+func (cache *keyCache) ServiceKey(key string) (*token.ServiceKey, error) {
+
+	keys := cache.serviceKeys.Load()
+	if keys == nil {
+		return nil, errors.New("the key cache is not loaded")
+	}
+	if key == "" {
+		status.KeyCacheMisses.Add(1)
+		return nil, nil
+	}
+	// keys that expired since the last reload are not usable anymore
+	serviceKey, ok := (*keys)[token.HashKey(key)]
+	if !ok || !serviceKey.IsUsable() {
+		status.KeyCacheMisses.Add(1)
+		return nil, nil
+	}
+	status.KeyCacheHits.Add(1)
+	cache.markUsed(cache.usedServiceKeys, serviceKey.ID)
+	return &serviceKey, nil
+}
+
+func (cache *keyCache) markUsed(used map[int]struct{}, keyID int) {
+	cache.usedLock.Lock()
+	used[keyID] = struct{}{}
+	cache.usedLock.Unlock()
+}
+
+func (cache *keyCache) flushUsedKeys() {
+
+	cache.usedLock.Lock()
+	usedAPIKeys := keyIDs(cache.usedAPIKeys)
+	usedServiceKeys := keyIDs(cache.usedServiceKeys)
+	clear(cache.usedAPIKeys)
+	clear(cache.usedServiceKeys)
+	cache.usedLock.Unlock()
+
+	err := database.SetAPIKeysUsed(cache.db, usedAPIKeys)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set last used date of API keys.")
+	}
+	err = database.SetServiceKeysUsed(cache.db, usedServiceKeys)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set last used date of service keys.")
+	}
+}
+
+func keyIDs(keys map[int]struct{}) []int {
+	ids := make([]int, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// FileMailer appends emails to a file instead of sending them, it is meant for local development and tests.
+// If no path is set the emails are written to stdout.
+type FileMailer struct {
+	From string
+	Path string
+
+	lock sync.Mutex
+}
+
+func (mailer *FileMailer) Send(message *Message) error {
+
+	data, err := format(mailer.From, message)
+	if err != nil {
+		return err
+	}
+
+	mailer.lock.Lock()
+	defer mailer.lock.Unlock()
+
+	var out io.Writer = os.Stdout
+	if mailer.Path != "" {
+		file, err := os.OpenFile(mailer.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	_, err = out.Write(append(data, []byte("\r\n\r\n")...))
+	return err
+}
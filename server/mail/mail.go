@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users.
+type Mailer interface {
+	Send(message *Message) error
+}
+
+// NewMailer returns the mailer with the given name, either "smtp" or "file".
+func NewMailer(name string, from string, file string, smtpHost string, smtpPort int, smtpUsername string, smtpPassword string) (Mailer, error) {
+
+	switch name {
+	case "smtp":
+		if smtpHost == "" {
+			return nil, errors.New("the smtp mailer needs a host")
+		}
+		return &SMTPMailer{From: from, Host: smtpHost, Port: smtpPort, Username: smtpUsername, Password: smtpPassword}, nil
+	case "file":
+		return &FileMailer{From: from, Path: file}, nil
+	}
+	return nil, errors.New("unknown mailer '" + name + "'")
+}
+
+// format returns the message with its headers as it is sent to the mail server.
+func format(from string, message *Message) ([]byte, error) {
+
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return nil, errors.New("the recipient or subject of the message contains a line break")
+	}
+
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + message.To + "\r\n")
+	builder.WriteString("Subject: " + message.Subject + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(builder.String()), nil
+}
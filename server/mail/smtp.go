@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends emails via an SMTP server. The connection is upgraded with STARTTLS
+// if the server supports it, credentials are only sent over encrypted connections.
+type SMTPMailer struct {
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func (mailer *SMTPMailer) Send(message *Message) error {
+
+	data, err := format(mailer.From, message)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+	address := net.JoinHostPort(mailer.Host, strconv.Itoa(mailer.Port))
+	return smtp.SendMail(address, auth, mailer.From, []string{message.To}, data)
+}
@@ -1,13 +1,10 @@
 package server
 
 import (
-	"crypto/rsa"
 	"crypto/tls"
 	"database/sql"
 	"fmt"
 	"net/http"
-	"os"
-	"slices"
 	"strconv"
 	"time"
 
@@ -15,12 +12,12 @@ import (
 	"github.com/Festivals-App/festivals-identity-server/server/config"
 	"github.com/Festivals-App/festivals-identity-server/server/database"
 	"github.com/Festivals-App/festivals-identity-server/server/handler"
+	"github.com/Festivals-App/festivals-identity-server/server/mail"
 	festivalspki "github.com/Festivals-App/festivals-pki"
 	servertools "github.com/Festivals-App/festivals-server-tools"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/rs/zerolog/log"
 )
 
@@ -32,6 +29,16 @@ type Server struct {
 	TLSConfig *tls.Config
 	Auth      *token.AuthService
 	Validator *token.ValidationService
+	Mailer    mail.Mailer
+
+	emailBlocklist *token.EmailDomainBlocklist
+	passwordPolicy *token.PasswordPolicy
+	loginThrottle  *token.LoginThrottle
+	mfaPolicy      *token.MFAPolicy
+	passkeys       *token.PasskeyService
+	federation     *token.Federation
+	services       *token.ServiceIdentities
+	keys           *keyCache
 }
 
 func NewServer(config *config.Config) *Server {
@@ -49,6 +56,13 @@ func (s *Server) initialize(config *config.Config) {
 	s.setDatabase()
 	s.setTLSHandling()
 	s.setIdentityService()
+	s.setMailer()
+	s.setEmailBlocklist()
+	s.setPasswordPolicy()
+	s.setLoginThrottle()
+	s.setMFAPolicy()
+	s.setPasskeyService()
+	s.setFederation()
 	s.setMiddleware()
 	s.setRoutes()
 }
@@ -79,6 +93,128 @@ func (s *Server) setDatabase() {
 	db.SetConnMaxLifetime(time.Minute * 5)
 
 	s.DB = db
+	s.hashPlaintextKeys()
+}
+
+// API and service keys used to be stored in plaintext, every remaining plaintext key is hashed on startup.
+func (s *Server) hashPlaintextKeys() {
+
+	count, err := database.HashPlaintextAPIKeys(s.DB)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to hash plaintext API keys")
+	}
+	if count > 0 {
+		log.Info().Int("count", count).Msg("Hashed plaintext API keys.")
+	}
+	count, err = database.HashPlaintextServiceKeys(s.DB)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to hash plaintext service keys")
+	}
+	if count > 0 {
+		log.Info().Int("count", count).Msg("Hashed plaintext service keys.")
+	}
+}
+
+func (s *Server) setMailer() {
+
+	mailer, err := mail.NewMailer(s.Config.Mail.Mailer, s.Config.Mail.From, s.Config.Mail.File, s.Config.Mail.SMTPHost, s.Config.Mail.SMTPPort, s.Config.Mail.SMTPUsername, s.Config.Mail.SMTPPassword)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create mailer")
+	}
+	s.Mailer = mailer
+}
+
+func (s *Server) setEmailBlocklist() {
+
+	blocklist, err := token.LoadEmailDomainBlocklist(s.Config.EmailDomainBlocklist)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load email domain blocklist")
+	}
+	if blocklist.Len() > 0 {
+		log.Info().Int("count", blocklist.Len()).Msg("Loaded email domain blocklist.")
+	}
+	s.emailBlocklist = blocklist
+}
+
+func (s *Server) setPasswordPolicy() {
+
+	conf := s.Config.Password
+	if max := s.Auth.PasswordHasher.MaxPasswordLength(); max > 0 && (conf.MaxLength == 0 || conf.MaxLength > max) {
+		log.Fatal().Int("max", max).Msg("the maximum password length is longer than the password hash algorithm supports")
+	}
+	policy, err := token.NewPasswordPolicy(conf.MinLength, conf.MaxLength, conf.RequireLowercase, conf.RequireUppercase, conf.RequireDigit, conf.RequireSymbol, conf.MinStrength, conf.BreachedPasswords)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create password policy")
+	}
+	s.passwordPolicy = policy
+}
+
+func (s *Server) setLoginThrottle() {
+
+	conf := s.Config.LoginProtection
+	throttle, err := token.NewLoginThrottle(conf.AccountThreshold, conf.IPThreshold, time.Duration(conf.Lockout)*time.Second, time.Duration(conf.MaxLockout)*time.Second, time.Duration(conf.Window)*time.Minute, conf.TrustedProxies, conf.ClientIPHeader)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create login throttle")
+	}
+	s.loginThrottle = throttle
+	go s.removeExpiredLoginAttempts()
+}
+
+func (s *Server) setMFAPolicy() {
+
+	s.mfaPolicy = &token.MFAPolicy{
+		Issuer:            s.Config.MFA.Issuer,
+		RequireForAdmin:   s.Config.MFA.RequireForAdmin,
+		ChallengeLifetime: time.Duration(s.Config.MFA.ChallengeExpiration) * time.Minute,
+	}
+}
+
+func (s *Server) setPasskeyService() {
+
+	conf := s.Config.WebAuthn
+	passkeys, err := token.NewPasskeyService(conf.RPID, conf.RPName, conf.Origins, time.Duration(conf.ChallengeExpiration)*time.Minute)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create passkey service")
+	}
+	s.passkeys = passkeys
+}
+
+func (s *Server) setFederation() {
+
+	conf := s.Config.Federation
+	providers := []*token.FederationProvider{}
+	for _, providerConf := range conf.Providers {
+		provider, err := token.NewFederationProvider(providerConf.ID, providerConf.Name, providerConf.Issuer, providerConf.ClientID, providerConf.ClientSecret)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create federation provider")
+		}
+		providers = append(providers, provider)
+	}
+	federation, err := token.NewFederation(conf.RedirectURL, time.Duration(conf.ChallengeExpiration)*time.Minute, providers)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create federation")
+	}
+	if len(providers) > 0 {
+		log.Info().Int("count", len(providers)).Msg("Loaded federation providers.")
+	}
+	s.federation = federation
+}
+
+// Login attempts are only needed for the lockout window and for admins to review them,
+// so every instance regularly removes the ones that are older than the configured retention.
+const loginAttemptsCleanupInterval = 1 * time.Hour
+
+func (s *Server) removeExpiredLoginAttempts() {
+
+	retention := time.Duration(s.Config.LoginProtection.Retention) * 24 * time.Hour
+	t := time.NewTicker(loginAttemptsCleanupInterval)
+	defer t.Stop()
+	for range t.C {
+		err := database.RemoveExpiredLoginAttempts(s.DB, retention)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to remove expired login attempts.")
+		}
+	}
 }
 
 func (s *Server) setTLSHandling() {
@@ -88,12 +224,97 @@ func (s *Server) setTLSHandling() {
 		log.Fatal().Err(err).Msg("failed to set TLS handling")
 	}
 	s.TLSConfig = tlsConfig
+
+	identities := make([]token.ServiceIdentity, 0, len(s.Config.ServiceIdentities))
+	for _, identity := range s.Config.ServiceIdentities {
+		identities = append(identities, token.ServiceIdentity{Name: identity.Name, Subjects: identity.Subjects, Scopes: identity.Scopes})
+	}
+	s.services, err = token.NewServiceIdentities(identities)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set the service identities.")
+	}
 }
 
 func (s *Server) setIdentityService() {
 
-	s.Auth = token.NewAuthService(s.Config.AccessTokenPrivateKeyPath, s.Config.AccessTokenPublicKeyPath, s.Config.JwtExpiration, s.Config.ServiceBindHost)
-	s.Validator = newLocalValidationService(s.Config.AccessTokenPublicKeyPath)
+	s.Auth = token.NewAuthService(s.Config.AccessTokenPrivateKeyPath, s.Config.AccessTokenPublicKeyPath, s.Config.JwtAlgorithm, s.Config.JwtExpiration, s.Config.RefreshExpiration, s.Config.ServiceBindHost)
+	hashing := s.Config.PasswordHashing
+	hasher, err := token.NewPasswordHasher(hashing.Algorithm, hashing.Argon2Memory, hashing.Argon2Time, hashing.Argon2Parallelism, hashing.BcryptCost)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create the password hasher.")
+	}
+	s.Auth.PasswordHasher = hasher
+	s.Auth.ServiceTokenLifetime = time.Duration(s.Config.ServiceTokenExpiration) * time.Minute
+	if !handler.ReloadSigningKeys(s.Auth, s.DB) {
+		log.Fatal().Msg("Failed to initialize the signing key ring.")
+	}
+	s.keys = newKeyCache(s.DB)
+	err = s.keys.Reload()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load the key cache.")
+	}
+	s.Validator = newLocalValidationService(s.Auth, s.keys, s.services)
+	s.loadRevocationList()
+	go s.refreshRevocationList()
+	go s.refreshSigningKeys()
+	go s.refreshKeyCache()
+}
+
+// Keys are added and deleted on any identity server instance, the key cache
+// is reloaded right away on the instance that made the change and regularly on all others.
+const keyCacheRefreshInterval = 30 * time.Second
+
+func (s *Server) refreshKeyCache() {
+
+	t := time.NewTicker(keyCacheRefreshInterval)
+	defer t.Stop()
+	for range t.C {
+		s.reloadKeyCache()
+	}
+}
+
+func (s *Server) reloadKeyCache() {
+
+	err := s.keys.Reload()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reload the key cache.")
+	}
+}
+
+// Signing keys are changed by admins on any identity server instance,
+// so every instance reloads the key ring from the database regularly.
+const signingKeysRefreshInterval = 1 * time.Minute
+
+func (s *Server) refreshSigningKeys() {
+
+	t := time.NewTicker(signingKeysRefreshInterval)
+	defer t.Stop()
+	for range t.C {
+		handler.ReloadSigningKeys(s.Auth, s.DB)
+	}
+}
+
+// The identity server is the source of the revocation list, so it reloads it from the
+// database a lot more frequently than other validation services pull it.
+const localRevocationListRefreshInterval = 5 * time.Second
+
+func (s *Server) refreshRevocationList() {
+
+	t := time.NewTicker(localRevocationListRefreshInterval)
+	defer t.Stop()
+	for range t.C {
+		s.loadRevocationList()
+	}
+}
+
+func (s *Server) loadRevocationList() {
+
+	list, err := database.GetRevocationList(s.DB, s.Auth)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load revocation list from database.")
+		return
+	}
+	s.Validator.SetRevocationList(list)
 }
 
 func (s *Server) setMiddleware() {
@@ -113,46 +334,109 @@ func (s *Server) setRoutes() {
 	s.Router.Get("/version", s.handleRequest(handler.GetVersion))
 	s.Router.Get("/info", s.handleRequest(handler.GetInfo))
 	s.Router.Get("/health", s.handleRequest(handler.GetHealth))
+	s.Router.Get("/metrics", s.handleRequest(handler.GetMetrics))
 
 	s.Router.Post("/update", s.handleRequest(handler.MakeUpdate))
 	s.Router.Get("/log", s.handleRequest(handler.GetLog))
 	s.Router.Get("/log/trace", s.handleRequest(handler.GetTraceLog))
 
-	s.Router.Post("/users/signup", s.handleAPIRequest(handler.Signup))
-	s.Router.Get("/users/login", s.handleAPIRequest(handler.Login))
+	s.Router.Post("/users/signup", s.handleAPIRequest(token.ScopeSignup, handler.Signup(s.Mailer, s.emailBlocklist, s.passwordPolicy, s.Config.VerificationURL, s.verificationLifetime())))
+	s.Router.Get("/users/login", s.handleAPIRequest(token.ScopeLogin, handler.Login(s.loginThrottle, s.mfaPolicy)))
+	s.Router.Post("/users/login/mfa", s.handleAPIRequest(token.ScopeLogin, handler.CompleteMFALogin(s.loginThrottle)))
+	s.Router.Post("/users/login/mfa/enroll", s.handleAPIRequest(token.ScopeLogin, handler.EnrollMFAAtLogin(s.mfaPolicy)))
+	s.Router.Post("/users/login/passkey/challenge", s.handleAPIRequest(token.ScopeLogin, handler.StartPasskeyLogin(s.passkeys)))
+	s.Router.Post("/users/login/passkey", s.handleAPIRequest(token.ScopeLogin, handler.FinishPasskeyLogin(s.passkeys, s.loginThrottle)))
+	s.Router.Get("/federation/providers", s.handleAPIRequest(token.ScopeLogin, handler.GetFederationProviders(s.federation)))
+	s.Router.Post("/users/login/federated/challenge", s.handleAPIRequest(token.ScopeLogin, handler.StartFederatedLogin(s.federation)))
+	s.Router.Post("/users/login/federated", s.handleAPIRequest(token.ScopeLogin, handler.FinishFederatedLogin(s.federation, s.emailBlocklist, s.loginThrottle, s.mfaPolicy)))
 	s.Router.Get("/users/refresh", s.handleRequest(handler.Refresh))
+	s.Router.Post("/users/logout", s.handleRequest(handler.Logout))
+	s.Router.Post("/users/refresh-token", s.handleAPIRequest(token.ScopeLogin, handler.ExchangeRefreshToken))
+	s.Router.Post("/users/verify-email", s.handleAPIRequest(token.ScopeSignup, handler.VerifyEmail))
+	s.Router.Post("/users/password-reset/request", s.handleAPIRequest(token.ScopeLogin, handler.RequestPasswordReset(s.Mailer, s.Config.PasswordResetURL, time.Minute*time.Duration(s.Config.PasswordResetExpiration))))
+	s.Router.Post("/users/password-reset/confirm", s.handleAPIRequest(token.ScopeLogin, handler.ConfirmPasswordReset(s.passwordPolicy)))
 	s.Router.Get("/users", s.handleRequest(handler.GetUsers))
-	s.Router.Post("/users/{objectID}/change-password", s.handleRequest(handler.ChangePassword))
+	s.Router.Get("/users/login-attempts", s.handleRequest(handler.GetLoginAttempts))
+	s.Router.Post("/users/{objectID}/change-password", s.handleRequest(handler.ChangePassword(s.passwordPolicy)))
 	s.Router.Post("/users/{objectID}/suspend", s.handleRequest(handler.SuspendUser))
+	s.Router.Post("/users/{objectID}/unsuspend", s.handleRequest(handler.UnsuspendUser))
+	s.Router.Post("/users/{objectID}/unlock", s.handleRequest(handler.UnlockUser))
+	s.Router.Post("/users/{objectID}/mfa/totp", s.handleRequest(handler.EnrollTOTP(s.mfaPolicy)))
+	s.Router.Post("/users/{objectID}/mfa/totp/confirm", s.handleRequest(handler.ConfirmTOTP))
+	s.Router.Delete("/users/{objectID}/mfa/totp", s.handleRequest(handler.DisableTOTP))
+	s.Router.Post("/users/{objectID}/mfa/recovery-codes", s.handleRequest(handler.RegenerateRecoveryCodes))
+	s.Router.Get("/users/{objectID}/passkeys", s.handleRequest(handler.GetPasskeys))
+	s.Router.Post("/users/{objectID}/passkeys/challenge", s.handleRequest(handler.StartPasskeyRegistration(s.passkeys)))
+	s.Router.Post("/users/{objectID}/passkeys", s.handleRequest(handler.FinishPasskeyRegistration(s.passkeys)))
+	s.Router.Delete("/users/{objectID}/passkeys/{resourceID}", s.handleRequest(handler.DeletePasskey))
+	s.Router.Get("/users/{objectID}/linked-identities", s.handleRequest(handler.GetLinkedIdentities))
+	s.Router.Post("/users/{objectID}/linked-identities/challenge", s.handleRequest(handler.StartIdentityLinking(s.federation)))
+	s.Router.Post("/users/{objectID}/linked-identities", s.handleRequest(handler.FinishIdentityLinking(s.federation)))
+	s.Router.Delete("/users/{objectID}/linked-identities/{resourceID}", s.handleRequest(handler.DeleteLinkedIdentity))
+	s.Router.Post("/users/{objectID}/verify", s.handleRequest(handler.ForceVerifyEmail))
+	s.Router.Post("/users/{objectID}/resend-verification", s.handleRequest(handler.ResendEmailVerification(s.Mailer, s.Config.VerificationURL, s.verificationLifetime())))
 	s.Router.Post("/users/{objectID}/role/{resourceID}", s.handleRequest(handler.SetUserRole))
+	s.Router.Post("/users/{objectID}/revoke-sessions", s.handleRequest(handler.RevokeSessions))
+	s.Router.Get("/users/{objectID}/oauth-consents", s.handleRequest(handler.GetOAuthConsents))
+	s.Router.Delete("/users/{objectID}/oauth-consents/{resourceID}", s.handleRequest(handler.RevokeOAuthConsent))
+
+	s.Router.Post("/users/{objectID}/festival/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetFestivalForUser))
+	s.Router.Post("/users/{objectID}/artist/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetArtistForUser))
+	s.Router.Post("/users/{objectID}/location/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetLocationForUser))
+	s.Router.Post("/users/{objectID}/event/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetEventForUser))
+	s.Router.Post("/users/{objectID}/link/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetLinkForUser))
+	s.Router.Post("/users/{objectID}/image/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetImageForUser))
+	s.Router.Post("/users/{objectID}/place/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetPlaceForUser))
+	s.Router.Post("/users/{objectID}/tag/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.SetTagForUser))
+
+	s.Router.Delete("/users/{objectID}/festival/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveFestivalForUser))
+	s.Router.Delete("/users/{objectID}/artist/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveArtistForUser))
+	s.Router.Delete("/users/{objectID}/location/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveLocationForUser))
+	s.Router.Delete("/users/{objectID}/event/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveEventForUser))
+	s.Router.Delete("/users/{objectID}/link/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveLinkForUser))
+	s.Router.Delete("/users/{objectID}/image/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveImageForUser))
+	s.Router.Delete("/users/{objectID}/place/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemovePlaceForUser))
+	s.Router.Delete("/users/{objectID}/tag/{resourceID}", s.handleServiceRequest(token.ServiceScopeUserEntities, handler.RemoveTagForUser))
+
+	s.Router.Get("/validation-key", s.handleServiceRequest(token.ServiceScopeKeysRead, handler.GetValidationKey))
+	s.Router.Get("/.well-known/jwks.json", s.handlePublicRequest(handler.GetJSONWebKeySet))
+	s.Router.Get("/.well-known/openid-configuration", s.handlePublicRequest(handler.GetOpenIDConfiguration(s.Config.OIDC.Issuer)))
+
+	s.Router.Get("/roles", s.handleRequest(handler.GetRoles))
+	s.Router.Get("/roles/{objectID}", s.handleRequest(handler.GetRole))
+	s.Router.Patch("/roles/{objectID}", s.handleRequest(handler.UpdateRole))
+	s.Router.Get("/permissions", s.handleRequest(handler.GetPermissions))
+
+	s.Router.Get("/signing-keys", s.handleRequest(handler.GetSigningKeys))
+	s.Router.Post("/signing-keys", s.handleRequest(handler.AddSigningKey))
+	s.Router.Post("/signing-keys/{objectID}/promote", s.handleRequest(handler.PromoteSigningKey))
+	s.Router.Post("/signing-keys/{objectID}/retire", s.handleRequest(handler.RetireSigningKey))
+	s.Router.Get("/revocation-list", s.handleServiceRequest(token.ServiceScopeKeysRead, handler.GetRevocationList))
+
+	s.Router.Get("/api-keys", s.handleServiceRequest(token.ServiceScopeKeysRead, handler.GetAPIKeys))
+	s.Router.Post("/api-keys", s.handleRequest(s.invalidatingKeyCache(handler.AddAPIKey)))
+	s.Router.Get("/api-keys/{objectID}", s.handleServiceRequest(token.ServiceScopeKeysRead, handler.GetAPIKey))
+	s.Router.Patch("/api-keys/{objectID}", s.handleRequest(s.invalidatingKeyCache(handler.UpdateAPIKey)))
+	s.Router.Delete("/api-keys/{objectID}", s.handleRequest(s.invalidatingKeyCache(handler.DeleteAPIKey)))
+
+	s.Router.Get("/oauth/authorize", s.handlePublicRequest(handler.Authorize(s.Config.OAuth.AuthorizeURL)))
+	s.Router.Post("/oauth/authorize", s.handleRequest(handler.ApproveAuthorization(time.Minute*time.Duration(s.Config.OAuth.CodeExpiration))))
+	s.Router.Post("/oauth/token", s.handlePublicRequest(handler.ExchangeOAuthToken(s.Config.OIDC.Issuer)))
+	s.Router.Get("/userinfo", s.handleScopedRequest(token.OAuthScopeOpenID, handler.GetUserInfo))
+	s.Router.Post("/userinfo", s.handleScopedRequest(token.OAuthScopeOpenID, handler.GetUserInfo))
+	s.Router.Get("/oauth/clients", s.handleRequest(handler.GetOAuthClients))
+	s.Router.Post("/oauth/clients", s.handleRequest(s.invalidatingKeyCache(handler.AddOAuthClient)))
+	s.Router.Delete("/oauth/clients/{objectID}", s.handleRequest(s.invalidatingKeyCache(handler.DeleteOAuthClient)))
+
+	s.Router.Get("/service-keys", s.handleServiceRequest(token.ServiceScopeKeysRead, handler.GetServiceKeys))
+	s.Router.Post("/service-keys", s.handleRequest(s.invalidatingKeyCache(handler.AddServiceKey)))
+	s.Router.Get("/service-keys/{objectID}", s.handleServiceRequest(token.ServiceScopeKeysRead, handler.GetServiceKey))
+	s.Router.Patch("/service-keys/{objectID}", s.handleRequest(s.invalidatingKeyCache(handler.UpdateServiceKey)))
+	s.Router.Delete("/service-keys/{objectID}", s.handleRequest(s.invalidatingKeyCache(handler.DeleteServiceKey)))
+}
 
-	s.Router.Post("/users/{objectID}/festival/{resourceID}", s.handleServiceRequest(handler.SetFestivalForUser))
-	s.Router.Post("/users/{objectID}/artist/{resourceID}", s.handleServiceRequest(handler.SetArtistForUser))
-	s.Router.Post("/users/{objectID}/location/{resourceID}", s.handleServiceRequest(handler.SetLocationForUser))
-	s.Router.Post("/users/{objectID}/event/{resourceID}", s.handleServiceRequest(handler.SetEventForUser))
-	s.Router.Post("/users/{objectID}/link/{resourceID}", s.handleServiceRequest(handler.SetLinkForUser))
-	s.Router.Post("/users/{objectID}/image/{resourceID}", s.handleServiceRequest(handler.SetImageForUser))
-	s.Router.Post("/users/{objectID}/place/{resourceID}", s.handleServiceRequest(handler.SetPlaceForUser))
-	s.Router.Post("/users/{objectID}/tag/{resourceID}", s.handleServiceRequest(handler.SetTagForUser))
-
-	s.Router.Delete("/users/{objectID}/festival/{resourceID}", s.handleServiceRequest(handler.RemoveFestivalForUser))
-	s.Router.Delete("/users/{objectID}/artist/{resourceID}", s.handleServiceRequest(handler.RemoveArtistForUser))
-	s.Router.Delete("/users/{objectID}/location/{resourceID}", s.handleServiceRequest(handler.RemoveLocationForUser))
-	s.Router.Delete("/users/{objectID}/event/{resourceID}", s.handleServiceRequest(handler.RemoveEventForUser))
-	s.Router.Delete("/users/{objectID}/link/{resourceID}", s.handleServiceRequest(handler.RemoveLinkForUser))
-	s.Router.Delete("/users/{objectID}/image/{resourceID}", s.handleServiceRequest(handler.RemoveImageForUser))
-	s.Router.Delete("/users/{objectID}/place/{resourceID}", s.handleServiceRequest(handler.RemovePlaceForUser))
-	s.Router.Delete("/users/{objectID}/tag/{resourceID}", s.handleServiceRequest(handler.RemoveTagForUser))
-
-	s.Router.Get("/validation-key", s.handleServiceRequest(handler.GetValidationKey))
-
-	s.Router.Get("/api-keys", s.handleServiceRequest(handler.GetAPIKeys))
-	s.Router.Post("/api-keys", s.handleRequest(handler.AddAPIKey))
-	s.Router.Delete("/api-keys", s.handleRequest(handler.DeleteAPIKey))
-
-	s.Router.Get("/service-keys", s.handleServiceRequest(handler.GetServiceKeys))
-	s.Router.Post("/service-keys", s.handleRequest(handler.AddServiceKey))
-	s.Router.Delete("/service-keys", s.handleRequest(handler.DeleteServiceKey))
+func (s *Server) verificationLifetime() time.Duration {
+	return time.Minute * time.Duration(s.Config.VerificationExpiration)
 }
 
 func (s *Server) Run(conf *config.Config) {
@@ -176,95 +460,68 @@ type JWTAuthenticatedHandlerFunction func(auth *token.AuthService, claims *token
 
 func (s *Server) handleRequest(requestHandler JWTAuthenticatedHandlerFunction) http.HandlerFunc {
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return s.Validator.RequireJWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		claims := token.GetValidClaims(r, s.Validator)
-		if claims == nil {
-			servertools.UnauthorizedResponse(w)
-			return
-		}
+		claims, _ := token.ClaimsFromRequest(r)
 		requestHandler(s.Auth, claims, s.DB, w, r)
-	})
+	})).ServeHTTP
 }
 
-type APIKeyAuthenticatedHandlerFunction func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request)
+// handleScopedRequest serves requests with a JWT of an OAuth client that was granted the given scope,
+// the JWT may be issued for any audience.
+func (s *Server) handleScopedRequest(scope string, requestHandler JWTAuthenticatedHandlerFunction) http.HandlerFunc {
 
-func (s *Server) handleAPIRequest(requestHandler APIKeyAuthenticatedHandlerFunction) http.HandlerFunc {
+	return s.Validator.RequireJWTWithScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := token.ClaimsFromRequest(r)
+		requestHandler(s.Auth, claims, s.DB, w, r)
+	})).ServeHTTP
+}
 
-		apikey := token.GetAPIToken(r)
-		allAPIKeys, err := database.GetAllAPIKeys(s.DB)
-		if err != nil {
-			log.Error().Msg("failed to load API keys from database")
-			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-			return
-		}
-		if !slices.Contains(getAPIKeyValues(allAPIKeys), apikey) {
-			servertools.UnauthorizedResponse(w)
-			return
+// invalidatingKeyCache reloads the key cache after the given handler changed API or service keys,
+// requests that failed or were rejected changed nothing, so they don't reload the key cache.
+func (s *Server) invalidatingKeyCache(requestHandler JWTAuthenticatedHandlerFunction) JWTAuthenticatedHandlerFunction {
+
+	return func(auth *token.AuthService, claims *token.UserClaims, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		recorder := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		requestHandler(auth, claims, db, recorder, r)
+		if recorder.Status() >= 200 && recorder.Status() < 300 {
+			s.reloadKeyCache()
 		}
-		requestHandler(s.Auth, s.DB, w, r)
-	})
+	}
 }
 
-type ServiceKeyAuthenticatedHandlerFunction func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request)
+type PublicHandlerFunction func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request)
 
-func (s *Server) handleServiceRequest(requestHandler ServiceKeyAuthenticatedHandlerFunction) http.HandlerFunc {
+// handlePublicRequest serves requests that need no authentication besides the mTLS client certificate.
+func (s *Server) handlePublicRequest(requestHandler PublicHandlerFunction) http.HandlerFunc {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		servicekey := token.GetServiceToken(r)
-		if servicekey == "" {
-			claims := token.GetValidClaims(r, s.Validator)
-			if claims != nil && claims.UserRole == token.ADMIN {
-				requestHandler(s.Auth, s.DB, w, r)
-				return
-			}
-			servertools.UnauthorizedResponse(w)
-			return
-		}
-		allServiceKeys, err := database.GetAllServiceKeys(s.DB)
-		if err != nil {
-			log.Error().Msg("failed to load servive keys from database")
-			servertools.RespondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-			return
-		}
-		if !slices.Contains(getServiceKeyValues(allServiceKeys), servicekey) {
-			servertools.UnauthorizedResponse(w)
-			return
-		}
 		requestHandler(s.Auth, s.DB, w, r)
 	})
 }
 
-func getServiceKeyValues(keys []token.ServiceKey) []string {
-	var data []string
-	for _, key := range keys {
-		data = append(data, key.Key)
-	}
-	return data
-}
+type APIKeyAuthenticatedHandlerFunction func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request)
 
-func getAPIKeyValues(keys []token.APIKey) []string {
-	var data []string
-	for _, key := range keys {
-		data = append(data, key.Key)
-	}
-	return data
+func (s *Server) handleAPIRequest(scope string, requestHandler APIKeyAuthenticatedHandlerFunction) http.HandlerFunc {
+
+	return s.Validator.RequireAPIScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestHandler(s.Auth, s.DB, w, r)
+	})).ServeHTTP
 }
 
-func newLocalValidationService(publickey string) *token.ValidationService {
+type ServiceKeyAuthenticatedHandlerFunction func(auth *token.AuthService, db *sql.DB, w http.ResponseWriter, r *http.Request)
 
-	var verifyKey *rsa.PublicKey = nil
-	verifyBytes, err := os.ReadFile(publickey)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Unable to read public auth key.")
-	}
-	verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Unable to parse public auth key.")
-	}
+// handleServiceRequest serves requests with a service key, a service token with the given scope or the JWT of an admin.
+func (s *Server) handleServiceRequest(scope string, requestHandler ServiceKeyAuthenticatedHandlerFunction) http.HandlerFunc {
+
+	return s.Validator.RequireServiceScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestHandler(s.Auth, s.DB, w, r)
+	})).ServeHTTP
+}
 
-	return &token.ValidationService{Key: verifyKey, APIKeys: nil, ServiceKeys: nil, Client: nil, Endpoint: ""}
+// newLocalValidationService returns the validation service of the identity service itself. It has no audience,
+// so access tokens of OAuth clients are only accepted by the scoped endpoints like /userinfo.
+func newLocalValidationService(auth *token.AuthService, keys token.KeySource, services *token.ServiceIdentities) *token.ValidationService {
+	return &token.ValidationService{Algorithm: auth.Algorithm, Key: nil, Keys: auth.ValidationKeys, Client: nil, Endpoint: "", KeySource: keys, Audience: "", ServiceIdentities: services}
 }
@@ -0,0 +1,26 @@
+package status
+
+import "sync/atomic"
+
+// KeyCacheHits counts the API and service keys that were found in the key cache.
+var KeyCacheHits atomic.Int64
+
+// KeyCacheMisses counts the API and service keys that were not found in the key cache.
+var KeyCacheMisses atomic.Int64
+
+// KeyCacheReloads counts the successful reloads of the key cache.
+var KeyCacheReloads atomic.Int64
+
+// KeyCacheReloadFailures counts the failed reloads of the key cache.
+var KeyCacheReloadFailures atomic.Int64
+
+func MetricsString() interface{} {
+	keyCache := map[string]interface{}{
+		"Hits":           KeyCacheHits.Load(),
+		"Misses":         KeyCacheMisses.Load(),
+		"Reloads":        KeyCacheReloads.Load(),
+		"ReloadFailures": KeyCacheReloadFailures.Load(),
+	}
+	resultMap := map[string]interface{}{"KeyCache": keyCache}
+	return resultMap
+}